@@ -1,38 +1,375 @@
 package ast
 
+// Pos is a 1-based source position of a node.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+// Position returns the position itself, so that every node embedding Pos exposes it.
+func (p Pos) Position() Pos {
+	return p
+}
+
+// Node is implemented by every AST node that carries a source position.
+type Node interface {
+	Position() Pos
+}
+
+// PosOf returns the position of n, or the zero Pos if n carries none.
+func PosOf(n any) Pos {
+	if node, ok := n.(Node); ok {
+		return node.Position()
+	}
+	return Pos{}
+}
+
 type Expr interface{}
 
 type Variable struct {
+	Pos
 	Name string
 }
 
 type Number struct {
+	Pos
 	Value float64
 }
 
 type String struct {
+	Pos
 	Value string
 }
 
+type Bool struct {
+	Pos
+	Value bool
+}
+
 type Binary struct {
+	Pos
 	Op    string
 	Left  Expr
 	Right Expr
 }
 
+type Unary struct {
+	Pos
+	Op      string
+	Operand Expr
+}
+
+// ListLit is a list literal: [a, b, c]
+type ListLit struct {
+	Pos
+	Elems []Expr
+}
+
+// MapLit is a map literal: {key: value, ...}
+type MapLit struct {
+	Pos
+	Entries []MapEntry
+}
+
+type MapEntry struct {
+	Key   Expr
+	Value Expr
+}
+
+// StructLit is a struct literal: Type{field: value, ...}, where Type may be qualified by Module
+type StructLit struct {
+	Pos
+	Module string
+	Type   string
+	Fields []FieldInit
+}
+
+type FieldInit struct {
+	Pos
+	Name  string
+	Value Expr
+}
+
+// Selector is a field access: X.Name
+type Selector struct {
+	Pos
+	X    Expr
+	Name string
+}
+
+// Index is an element access of a list or map: X[Index]
+type Index struct {
+	Pos
+	X     Expr
+	Index Expr
+}
+
+// Call is a function call: Func(Args...)
+type Call struct {
+	Pos
+	Func Expr
+	Args []Expr
+}
+
+// FuncLit is an anonymous function: fn(param[: Type], ...)[: Result] { ... }
+// Parameter types may be omitted where the expected function type supplies them. A final
+// expression statement in Body gives the value the function returns.
+type FuncLit struct {
+	Pos
+	Params []Field
+	Result TypeExpr
+	Body   *Block
+}
+
+// Propagate unwraps a result value: X? yields the value of X, or passes its error on to the
+// enclosing try statement or the caller.
+type Propagate struct {
+	Pos
+	X Expr
+}
+
+// TypeExpr is a type written in the source, such as `float` or `[string]`.
+type TypeExpr interface{}
+
+// NamedType refers to a type by name: float, string, bool or a declared type,
+// optionally qualified by an imported module: geometry.Point
+type NamedType struct {
+	Pos
+	Module string
+	Name   string
+}
+
+// ListType is the type of lists: [Elem]
+type ListType struct {
+	Pos
+	Elem TypeExpr
+}
+
+// ResultType is the type of values that are either a Value or an error: !Value
+type ResultType struct {
+	Pos
+	Value TypeExpr
+}
+
+// FuncType is the type of function values: fn(Params...)[: Result]
+type FuncType struct {
+	Pos
+	Params []TypeExpr
+	Result TypeExpr
+}
+
+// Match selects an arm by the variant of an enum value: match Subject { Variant(a, b) => ..., _ => ... }
+type Match struct {
+	Pos
+	Subject Expr
+	Arms    []*MatchArm
+	End     Pos // position of the closing brace
+}
+
+// MatchArm is one arm of a match. Variant is "_" for the wildcard arm. The body is either
+// the expression Expr or, for matches used as statements, the block Block.
+type MatchArm struct {
+	Pos
+	Variant  string
+	Bindings []string // names bound to the variant fields; "_" ignores a field
+	Expr     Expr
+	Block    *Block
+}
+
+// MapType is the type of maps: {Key: Value}
+type MapType struct {
+	Pos
+	Key   TypeExpr
+	Value TypeExpr
+}
+
 type Stmt interface{}
 
+// Assignment binds Expr to Name; Type is the optional annotation in `name: Type = expr`.
+// Let is set for `let name = expr`, which declares a binding that cannot be reassigned.
 type Assignment struct {
+	Pos
+	Let  bool
 	Name string
+	Type TypeExpr
 	Expr Expr
 }
 
 var _ Stmt = (*Assignment)(nil)
 
 type PrintStmt struct {
+	Pos
 	Expr Expr
 }
 
-type Program struct {
+// IndexAssign stores Expr into a list element or map entry: Target = Expr
+type IndexAssign struct {
+	Pos
+	Target *Index
+	Expr   Expr
+}
+
+// FieldAssign stores Expr into a struct field: Target = Expr
+type FieldAssign struct {
+	Pos
+	Target *Selector
+	Expr   Expr
+}
+
+// CompoundAssign updates a variable, list or map element, or struct field in place:
+// target op= expr, target++ or target--. Op is the arithmetic operator applied, one of
+// + - * /, and Expr is 1 for ++ and --, which set IncDec.
+type CompoundAssign struct {
+	Pos
+	Target Expr // *Variable, *Index or *Selector
+	Op     string
+	Expr   Expr
+	IncDec bool
+}
+
+// TypeDecl declares a struct type: [pub] type Name { field: Type, ... }
+type TypeDecl struct {
+	Pos
+	Pub    bool
+	Name   string
+	Fields []Field
+}
+
+type Field struct {
+	Pos
+	Name string
+	Type TypeExpr // nil for untyped enum variant fields
+}
+
+// EnumDecl declares a tagged union: [pub] enum Name { Variant(field, ...), ... }
+type EnumDecl struct {
+	Pos
+	Pub      bool
+	Name     string
+	Variants []Variant
+}
+
+// FuncDecl declares a function: [pub] [export] fn Name(param: Type, ...): Result { ... }
+// or a C function: [pub] extern fn Name(param: Type, ...): Result
+// Result is nil for functions that return no value, Body is nil for extern functions.
+// Exported functions are callable from C under their own name.
+type FuncDecl struct {
+	Pos
+	Pub    bool
+	Export bool
+	Extern bool
+	Name   string
+	Params []Field
+	Result TypeExpr
+	Body   *Block
+}
+
+// TestDecl declares a test, run by pede test and left out of other builds: test "Name" { ... }
+type TestDecl struct {
+	Pos
+	Name string
+	Body *Block
+}
+
+// BenchDecl declares a benchmark, run repeatedly by pede bench and left out of other builds:
+// bench "Name" { ... }
+type BenchDecl struct {
+	Pos
+	Name string
+	Body *Block
+}
+
+// ReturnStmt returns from the enclosing function; Value is nil in functions without a result.
+type ReturnStmt struct {
+	Pos
+	Value Expr
+}
+
+// ConstDecl declares a constant evaluated at compile time: [pub] const Name [: Type] = Value
+type ConstDecl struct {
+	Pos
+	Pub   bool
+	Name  string
+	Type  TypeExpr
+	Value Expr
+}
+
+// ImportDecl imports another .pede file: import [Name] "Path"
+// Name defaults to the last element of Path.
+type ImportDecl struct {
+	Pos
+	Name string
+	Path string
+}
+
+// Variant is one alternative of an enum; fields without a type annotation are floats.
+type Variant struct {
+	Pos
+	Name   string
+	Fields []Field
+}
+
+// ExprStmt is an expression evaluated for its side effects, such as a call.
+type ExprStmt struct {
+	Pos
+	Expr Expr
+}
+
+// Block is a brace-delimited list of statements.
+type Block struct {
+	Pos
 	Stmts []Stmt
+	End   Pos // position of the closing brace
+}
+
+// IfStmt is `if cond { ... } else { ... }`; Else is nil, a *Block or an *IfStmt.
+type IfStmt struct {
+	Pos
+	Cond Expr
+	Then *Block
+	Else Stmt
+}
+
+type WhileStmt struct {
+	Pos
+	Cond Expr
+	Body *Block
+}
+
+// ForStmt iterates over the elements of a list or the keys of a map: for Var in Iter { ... }
+type ForStmt struct {
+	Pos
+	Var  string
+	Iter Expr
+	Body *Block
+}
+
+// TryStmt runs Body; an error propagated with ? inside it runs Catch instead, with the error
+// message bound to Var unless it is empty: try { ... } catch [Var] { ... }
+type TryStmt struct {
+	Pos
+	Body  *Block
+	Var   string
+	Catch *Block
+}
+
+type Program struct {
+	Stmts    []Stmt
+	Comments []Comment // every comment of the source, in order
+}
+
+// Comment is a // comment, which runs to the end of its line. Text includes the slashes.
+type Comment struct {
+	Pos
+	Text string
+}
+
+// Module is a parsed .pede file together with the modules it imports.
+type Module struct {
+	Path    string             // unique module path, used to qualify its symbols; "main" for the root
+	File    string             // path of the source file
+	Source  string             // preprocessed source, used to render errors
+	Program *Program           // parsed top-level statements
+	Imports map[string]*Module // imported modules, by the name they are bound to
+	Ignores map[int][]string   // warnings suppressed by // pede:ignore comments, by line; empty for all
 }
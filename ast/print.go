@@ -0,0 +1,193 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var posType = reflect.TypeOf(Pos{})
+
+// Fprint writes the tree rooted at node to w for debugging: each node on a line of its own with
+// its type and position, followed by its fields indented below it. Empty fields are left out.
+// annotate, if not nil, returns a note shown after a node, such as the type of an expression.
+func Fprint(w io.Writer, node any, annotate func(node any) string) error {
+	p := &treePrinter{annotate: annotate}
+	p.node(reflect.ValueOf(node), 0)
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+type treePrinter struct {
+	buf      bytes.Buffer
+	annotate func(node any) string
+}
+
+// node prints the node v at depth, continuing the line started by its field name if any.
+func (p *treePrinter) node(v reflect.Value, depth int) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			p.buf.WriteString("nil\n")
+			return
+		}
+		if v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Struct {
+			break
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Pointer {
+		p.structNode(v.Elem(), v.Interface(), depth)
+		return
+	}
+	if v.Kind() == reflect.Struct && v.Type() != posType {
+		var n any
+		if v.CanAddr() {
+			n = v.Addr().Interface()
+		}
+		p.structNode(v, n, depth)
+		return
+	}
+	p.buf.WriteString(scalar(v) + "\n")
+}
+
+// structNode prints the struct v, which is the node n if that is not nil.
+func (p *treePrinter) structNode(v reflect.Value, n any, depth int) {
+	t := v.Type()
+	p.buf.WriteString(t.Name())
+	if pos, ok := v.Interface().(Node); ok && t != posType {
+		if pos := pos.Position(); pos.Line > 0 {
+			fmt.Fprintf(&p.buf, " %d:%d", pos.Line, pos.Column)
+		}
+	}
+	if p.annotate != nil && n != nil {
+		if note := p.annotate(n); note != "" {
+			p.buf.WriteString(" (" + note + ")")
+		}
+	}
+	p.buf.WriteString("\n")
+	indent := strings.Repeat("  ", depth+1)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous || !f.IsExported() {
+			continue
+		}
+		fv := v.Field(i)
+		if fv.IsZero() || (fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) && fv.Len() == 0 {
+			continue
+		}
+		p.buf.WriteString(indent + f.Name + " ")
+		switch fv.Kind() {
+		case reflect.Slice:
+			fmt.Fprintf(&p.buf, "[%d]\n", fv.Len())
+			for j := range fv.Len() {
+				p.buf.WriteString(indent + "  - ")
+				p.node(fv.Index(j), depth+2)
+			}
+		case reflect.Map:
+			fmt.Fprintf(&p.buf, "{%d}\n", fv.Len())
+		default:
+			p.node(fv, depth+1)
+		}
+	}
+}
+
+// scalar formats a value that is not a node: a position, string, number or bool.
+func scalar(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Struct:
+		if pos, ok := v.Interface().(Pos); ok {
+			return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+		}
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// MarshalJSON returns the tree rooted at node as indented JSON for tools. Every node is an object
+// whose "node" member names its type and whose "pos" member is its position, followed by its
+// fields under their Go names.
+func MarshalJSON(node any) ([]byte, error) {
+	return json.MarshalIndent(jsonValue(reflect.ValueOf(node)), "", "  ")
+}
+
+// object is a JSON object that keeps its members in order.
+type object struct {
+	keys   []string
+	values []any
+}
+
+func (o *object) add(key string, value any) {
+	o.keys = append(o.keys, key)
+	o.values = append(o.values, value)
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonValue converts v to a value encoding/json marshals as described by MarshalJSON.
+func jsonValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return jsonValue(v.Elem())
+	case reflect.Slice:
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = jsonValue(v.Index(i))
+		}
+		return list
+	case reflect.Map:
+		// Only modules have maps, of imported modules, which are dumped on their own
+		return v.Len()
+	case reflect.Struct:
+		if pos, ok := v.Interface().(Pos); ok {
+			obj := &object{}
+			obj.add("line", pos.Line)
+			obj.add("column", pos.Column)
+			return obj
+		}
+		obj := &object{}
+		obj.add("node", v.Type().Name())
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			switch {
+			case f.Anonymous && f.Type == posType:
+				obj.add("pos", jsonValue(v.Field(i)))
+			case f.IsExported():
+				obj.add(f.Name, jsonValue(v.Field(i)))
+			}
+		}
+		return obj
+	}
+	return v.Interface()
+}
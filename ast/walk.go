@@ -0,0 +1,112 @@
+package ast
+
+// Inspect visits the statements and expressions of the tree rooted at node in depth-first order:
+// it calls f(n) for each node n, and then, if f returns true, inspects the children of n. Type
+// expressions and declarations of types are not visited below their own node.
+func Inspect(node any, f func(any) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *Program:
+		inspectStmts(n.Stmts, f)
+	case *Block:
+		inspectStmts(n.Stmts, f)
+	case *Binary:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *Unary:
+		Inspect(n.Operand, f)
+	case *ListLit:
+		inspectExprs(n.Elems, f)
+	case *MapLit:
+		for _, e := range n.Entries {
+			Inspect(e.Key, f)
+			Inspect(e.Value, f)
+		}
+	case *StructLit:
+		for _, fi := range n.Fields {
+			Inspect(fi.Value, f)
+		}
+	case *Selector:
+		Inspect(n.X, f)
+	case *Index:
+		Inspect(n.X, f)
+		Inspect(n.Index, f)
+	case *Call:
+		Inspect(n.Func, f)
+		inspectExprs(n.Args, f)
+	case *FuncLit:
+		Inspect(n.Body, f)
+	case *Propagate:
+		Inspect(n.X, f)
+	case *Match:
+		Inspect(n.Subject, f)
+		for _, arm := range n.Arms {
+			Inspect(arm, f)
+		}
+	case *MatchArm:
+		if n.Expr != nil {
+			Inspect(n.Expr, f)
+		}
+		if n.Block != nil {
+			Inspect(n.Block, f)
+		}
+	case *Assignment:
+		Inspect(n.Expr, f)
+	case *PrintStmt:
+		Inspect(n.Expr, f)
+	case *IndexAssign:
+		Inspect(n.Target, f)
+		Inspect(n.Expr, f)
+	case *FieldAssign:
+		Inspect(n.Target, f)
+		Inspect(n.Expr, f)
+	case *CompoundAssign:
+		Inspect(n.Target, f)
+		Inspect(n.Expr, f)
+	case *FuncDecl:
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+	case *TestDecl:
+		Inspect(n.Body, f)
+	case *BenchDecl:
+		Inspect(n.Body, f)
+	case *ReturnStmt:
+		if n.Value != nil {
+			Inspect(n.Value, f)
+		}
+	case *ConstDecl:
+		Inspect(n.Value, f)
+	case *ExprStmt:
+		Inspect(n.Expr, f)
+	case *IfStmt:
+		Inspect(n.Cond, f)
+		Inspect(n.Then, f)
+		if n.Else != nil {
+			Inspect(n.Else, f)
+		}
+	case *WhileStmt:
+		Inspect(n.Cond, f)
+		Inspect(n.Body, f)
+	case *ForStmt:
+		Inspect(n.Iter, f)
+		Inspect(n.Body, f)
+	case *TryStmt:
+		Inspect(n.Body, f)
+		Inspect(n.Catch, f)
+	}
+}
+
+func inspectStmts(stmts []Stmt, f func(any) bool) {
+	for _, s := range stmts {
+		Inspect(s, f)
+	}
+}
+
+func inspectExprs(exprs []Expr, f func(any) bool) {
+	for _, e := range exprs {
+		Inspect(e, f)
+	}
+}
@@ -1,19 +1,28 @@
 package builder
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/engpetarmarinov/pede/ast"
 	"github.com/engpetarmarinov/pede/codegen"
+	"github.com/engpetarmarinov/pede/diag"
 	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/lint"
 	"github.com/engpetarmarinov/pede/parser"
 	"github.com/engpetarmarinov/pede/preprocessor"
+	"github.com/engpetarmarinov/pede/rt"
+	"github.com/engpetarmarinov/pede/sema"
 )
 
-// Preprocess preprocesses the input string, strips comments and empty/unknown lines, and returns a cleaned string.
+// Preprocess preprocesses the input string, blanking out comment and empty lines, and returns a cleaned string.
 func Preprocess(input string) (string, error) {
 	filtered, err := preprocessor.Preprocess(input, preprocessor.DefaultRules())
 	return filtered, err
@@ -25,22 +34,40 @@ func Lex(input string) *lexer.Lexer {
 }
 
 // Parse parses the input using the lexer and returns an AST
-func Parse(lx *lexer.Lexer) *ast.Program {
-	p := parser.NewParser(lx)
-	astProgram, err := p.Parse()
-	if err != nil {
-		slog.Error("builder parse failed", "err", err)
-		os.Exit(1)
-	}
-	return astProgram
+func Parse(lx *lexer.Lexer) (*ast.Program, error) {
+	return parser.NewParser(lx).Parse()
 }
 
-// Codegen generates LLVM IR from the AST
-func Codegen(ast *ast.Program, buildOS, buildARCH string) *codegen.Codegen {
-	cg := codegen.NewCodegen(buildOS, buildARCH)
-	cg.GenProgram(ast)
+// Check runs semantic analysis and type checking on the module and its imports and returns the computed types,
+// and library reports whether it is built as a library
+func Check(root *ast.Module, library bool) (*sema.Info, error) {
+	checker := sema.NewChecker()
+	checker.Library = library
+	return checker.Check(root)
+}
+
+// Codegen generates LLVM IR from the module and its imports for the kind of output emit; libraries
+// get no main function, and test and benchmark binaries one running the test or bench blocks, and
+// stackTrace makes runtime panics print the pede call stack
+func Codegen(root *ast.Module, info *sema.Info, buildOS, buildARCH, emit string, stackTrace bool) (*codegen.Codegen, error) {
+	cg := codegen.NewCodegen(buildOS, buildARCH, root.File, info)
+	cg.StackTrace = stackTrace
+	var err error
+	switch emit {
+	case EmitStaticLib:
+		err = cg.GenLibrary(root)
+	case EmitTest:
+		err = cg.GenTests(root)
+	case EmitBench:
+		err = cg.GenBenches(root)
+	default:
+		err = cg.GenModule(root)
+	}
+	if err != nil {
+		return nil, err
+	}
 	cg.Finish()
-	return cg
+	return cg, nil
 }
 
 // WriteIR writes the generated IR to a file
@@ -59,55 +86,277 @@ func WriteIR(cg *codegen.Codegen, output string) (string, error) {
 	return irFile, nil
 }
 
-// Link compiles and links the generated IR file into an executable
-func Link(cc, irFile, output string) error {
-	cmd := exec.Command(cc, irFile, "-o", output)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// Link compiles and links the generated IR file together with the pede runtime into an executable.
+// Each of libPaths is searched for libraries, and each of libs is linked, as with cc's -L and -l.
+// opt is the optimization level passed to cc as -O<opt>, or empty for its default.
+func Link(ctx context.Context, cc, opt, irFile, output string, libPaths, libs []string) error {
+	dir, err := os.MkdirTemp("", "pede-rt")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	rtFile, err := rt.WriteSource(dir)
+	if err != nil {
+		return err
+	}
+	args := []string{irFile, rtFile, "-o", output}
+	if opt != "" {
+		args = append(args, "-O"+opt)
+	}
+	for _, dir := range libPaths {
+		args = append(args, "-L"+dir)
+	}
+	// Libraries come after the objects that use them, which single-pass linkers require
+	for _, lib := range libs {
+		args = append(args, "-l"+lib)
+	}
+	return run(ctx, cc, args...)
+}
+
+// Archive compiles the generated IR file and the pede runtime and bundles them into a static library,
+// optimized at the level opt as for Link
+func Archive(ctx context.Context, cc, ar, opt, irFile, output string) error {
+	dir, err := os.MkdirTemp("", "pede-lib")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	rtFile, err := rt.WriteSource(dir)
+	if err != nil {
+		return err
+	}
+	objs := []string{filepath.Join(dir, "pede.o"), filepath.Join(dir, "pede_rt.o")}
+	for i, src := range []string{irFile, rtFile} {
+		args := []string{"-c", src, "-o", objs[i]}
+		if opt != "" {
+			args = append(args, "-O"+opt)
+		}
+		if err := run(ctx, cc, args...); err != nil {
+			return err
+		}
+	}
+	// ar adds to an existing archive, so start from a fresh one
+	if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return run(ctx, ar, append([]string{"rcs", output}, objs...)...)
+}
+
+// HeaderPath returns the path of the C header generated next to the static library output:
+// libgeometry.a gets geometry.h
+func HeaderPath(output string) string {
+	base := filepath.Base(output)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	base = strings.TrimPrefix(base, "lib")
+	return filepath.Join(filepath.Dir(output), base+".h")
 }
 
+// WriteHeader writes the C header declaring the exported functions to path
+func WriteHeader(info *sema.Info, input, path string) error {
+	name := strings.TrimSuffix(filepath.Base(path), ".h")
+	slog.Debug("Writing header", "file", path)
+	header, err := codegen.GenHeader(name, input, info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(header), 0o644)
+}
+
+// run runs a tool of the C toolchain. Its output is returned in the error if it fails.
+func run(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if out := strings.TrimSpace(string(out)); out != "" {
+		return fmt.Errorf("%s: %w\n%s", name, err, out)
+	}
+	return fmt.Errorf("%s: %w", name, err)
+}
+
+// Kinds of output produced by Compile
+const (
+	EmitExe       = "exe"       // a native executable
+	EmitStaticLib = "staticlib" // a static library of the exported functions, with a C header
+	EmitTest      = "test"      // an executable running the test block named by its argument
+	EmitBench     = "bench"     // an executable timing the bench block named by its argument
+)
+
+// OptLevels are the optimization levels Options.OptLevel accepts.
+var OptLevels = []string{"0", "1", "2", "3", "s", "z"}
+
 type Options struct {
 	OS     string // Target operating system
 	ARCH   string // Target architecture
 	Input  string // Input .pede file
-	Output string // Output binary name
+	Output string // Output binary name (default: OutputName)
 	KeepIR bool   // Whether to keep the generated LLVM IR file
 	CC     string // C compiler to use (default: clang)
+	AR     string // Archiver used for static libraries (default: ar)
+	Emit   string // Kind of output, EmitExe, EmitStaticLib, EmitTest or EmitBench (default: EmitExe)
+
+	OptLevel string // One of OptLevels, passed to CC as -O<level> (default: the default of CC)
+
+	StackTrace bool        // Whether runtime panics print the pede call stack
+	Warnings   lint.Config // Which warnings are reported, and whether they fail the build
+
+	// DumpAfter is one of DumpStages to write the output of that stage to Dump, for debugging:
+	// the preprocessed source, tokens or syntax tree of each file, the syntax trees with the
+	// type of each expression, or the LLVM IR. The build goes on after the dump.
+	DumpAfter Stage
+	Dump      io.Writer
+
+	ImportPaths []string // Directories searched for imported modules
+	LibPaths    []string // Directories searched for libraries at link time
+	Libs        []string // Libraries linked into the executable, such as "m" for libm
 }
 
-// Build orchestrates the build process
-func Build(opts *Options) {
-	f, err := os.Open(opts.Input)
-	if err != nil {
-		slog.Error("failed to open input", "err", err)
-		os.Exit(1)
+// Result describes the files written by Compile
+type Result struct {
+	Output   string             // The executable or static library
+	Header   string             // The C header of a static library, empty for executables
+	IR       string             // The LLVM IR file, empty unless KeepIR is set
+	Warnings []*diag.Diagnostic // Suspicious but valid code found by the checker and the linter
+	Tests    []*ast.TestDecl    // The test blocks of the root module, run by name by a test binary
+	Benches  []*ast.BenchDecl   // The bench blocks of the root module, run by name by a benchmark binary
+}
+
+// OutputName returns the default output of building input: the file name without its extension,
+// or lib<name>.a for a static library
+func OutputName(input, emit string) string {
+	base := filepath.Base(input)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	if name != "" && emit == EmitStaticLib {
+		name = "lib" + name + ".a"
+	}
+	return name
+}
+
+// Compile builds opts.Input and the modules it imports into an executable, a static library, or a
+// test or benchmark binary; a program without such blocks is only checked for the latter. A
+// failure is returned as an *Error naming the stage that failed, StageOptions for invalid options;
+// ctx cancels the build between stages and stops the C toolchain.
+func Compile(ctx context.Context, opts Options) (*Result, error) {
+	if opts.Emit == "" {
+		opts.Emit = EmitExe
+	}
+	if opts.Emit != EmitExe && opts.Emit != EmitStaticLib && opts.Emit != EmitTest && opts.Emit != EmitBench {
+		return nil, &Error{Stage: StageOptions, Err: fmt.Errorf("unknown output kind %q, want %q, %q, %q or %q", opts.Emit, EmitExe, EmitStaticLib, EmitTest, EmitBench)}
+	}
+	if opts.OptLevel != "" && !slices.Contains(OptLevels, opts.OptLevel) {
+		return nil, &Error{Stage: StageOptions, Err: fmt.Errorf("unknown optimization level %q, want one of %s", opts.OptLevel, strings.Join(OptLevels, ", "))}
+	}
+	if opts.Output == "" {
+		opts.Output = OutputName(opts.Input, opts.Emit)
+		if opts.Output == "" {
+			return nil, &Error{Stage: StageOptions, Err: fmt.Errorf("cannot derive an output name from %q", opts.Input)}
+		}
+	}
+	if opts.CC == "" {
+		opts.CC = "clang"
+	}
+	if opts.AR == "" {
+		opts.AR = "ar"
+	}
+	if opts.DumpAfter != "" && !slices.Contains(DumpStages, opts.DumpAfter) {
+		return nil, &Error{Stage: StageOptions, Err: fmt.Errorf("cannot dump the output of stage %q", opts.DumpAfter)}
 	}
-	code, err := io.ReadAll(f)
-	f.Close()
+	library := opts.Emit == EmitStaticLib
+
+	root, info, warnings, err := front(ctx, opts, library)
 	if err != nil {
-		slog.Error("failed to read input", "err", err)
-		os.Exit(1)
+		return nil, err
+	}
+	res := &Result{Output: opts.Output, Warnings: warnings}
+	if opts.Emit == EmitTest || opts.Emit == EmitBench {
+		for _, stmt := range root.Program.Stmts {
+			switch decl := stmt.(type) {
+			case *ast.TestDecl:
+				if opts.Emit == EmitTest {
+					res.Tests = append(res.Tests, decl)
+				}
+			case *ast.BenchDecl:
+				if opts.Emit == EmitBench {
+					res.Benches = append(res.Benches, decl)
+				}
+			}
+		}
+		// A program without tests or benchmarks has no binary to write
+		if len(res.Tests) == 0 && len(res.Benches) == 0 {
+			res.Output = ""
+			return res, nil
+		}
 	}
-	preprocessed, err := Preprocess(string(code))
+	if err := ctx.Err(); err != nil {
+		return nil, &Error{Stage: StageCodegen, Err: err}
+	}
+	cg, err := Codegen(root, info, opts.OS, opts.ARCH, opts.Emit, opts.StackTrace)
 	if err != nil {
-		slog.Error("preprocess failed", "err", err)
-		os.Exit(1)
+		return nil, &Error{Stage: StageCodegen, Err: err}
+	}
+	if opts.DumpAfter == StageCodegen && opts.Dump != nil {
+		if _, err := cg.WriteTo(opts.Dump); err != nil {
+			return nil, &Error{Stage: StageCodegen, Err: err}
+		}
 	}
-	lx := Lex(preprocessed)
-	program := Parse(lx)
-	cg := Codegen(program, opts.OS, opts.ARCH)
 	irFile, err := WriteIR(cg, opts.Output)
 	if err != nil {
-		slog.Error("failed to write IR", "err", err)
-		os.Exit(1)
+		return nil, &Error{Stage: StageCodegen, Err: err}
+	}
+	if opts.KeepIR {
+		res.IR = irFile
+	} else {
+		defer os.Remove(irFile)
+	}
+
+	if library {
+		if err := Archive(ctx, opts.CC, opts.AR, opts.OptLevel, irFile, opts.Output); err != nil {
+			return nil, &Error{Stage: StageLink, Err: err}
+		}
+		res.Header = HeaderPath(opts.Output)
+		if err := WriteHeader(info, opts.Input, res.Header); err != nil {
+			return nil, &Error{Stage: StageLink, Err: err}
+		}
+	} else if err := Link(ctx, opts.CC, opts.OptLevel, irFile, opts.Output, opts.LibPaths, opts.Libs); err != nil {
+		return nil, &Error{Stage: StageLink, Err: err}
+	}
+	return res, nil
+}
+
+// Validate runs the stages of Compile up to and including lint on opts.Input and the modules it
+// imports, without generating code or running the C toolchain, and returns the warnings found.
+// Only Input, ImportPaths, Emit, Warnings and the dump options are used; a failure is an *Error
+// as for Compile.
+func Validate(ctx context.Context, opts Options) ([]*diag.Diagnostic, error) {
+	_, _, warnings, err := front(ctx, opts, opts.Emit == EmitStaticLib)
+	return warnings, err
+}
+
+// front loads and checks the program of opts and lints it, which are the stages before codegen.
+func front(ctx context.Context, opts Options, library bool) (*ast.Module, *sema.Info, []*diag.Diagnostic, error) {
+	root, err := LoadModulesWith(opts.Input, LoadOptions{
+		ImportPaths: opts.ImportPaths,
+		DumpAfter:   opts.DumpAfter,
+		Dump:        opts.Dump,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, &Error{Stage: StageCheck, Err: err}
+	}
+	info, err := Check(root, library)
+	if err != nil {
+		return nil, nil, nil, &Error{Stage: StageCheck, Err: err}
 	}
-	if err := Link(opts.CC, irFile, opts.Output); err != nil {
-		slog.Error("failed to link executable", "err", err)
-		os.Exit(1)
+	if opts.DumpAfter == StageCheck && opts.Dump != nil {
+		if err := DumpTypes(opts.Dump, root, info); err != nil {
+			return nil, nil, nil, &Error{Stage: StageCheck, Err: err}
+		}
 	}
-	if !opts.KeepIR {
-		os.Remove(irFile)
+	warnings := opts.Warnings.Apply(root, append(info.Warnings, lint.Check(root, info)...))
+	if opts.Warnings.Werror && len(warnings) > 0 {
+		return nil, nil, nil, &Error{Stage: StageLint, Err: diag.List(warnings)}
 	}
-	slog.Info("pede was built", "OS", opts.OS, "ARCH", opts.ARCH)
+	return root, info, warnings, nil
 }
@@ -0,0 +1,203 @@
+package builder_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/builder/buildertest"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lint"
+)
+
+func TestCompileRejectsOptions(t *testing.T) {
+	file := buildertest.WriteSource(t, "main.pede", "print(1)\n")
+	tests := []struct {
+		name string
+		opts builder.Options
+		want string
+	}{
+		{"emit", builder.Options{Input: file, Emit: "dll"}, `unknown output kind "dll"`},
+		{"opt level", builder.Options{Input: file, OptLevel: "4"}, `unknown optimization level "4"`},
+		{"dump stage", builder.Options{Input: file, DumpAfter: "link"}, `cannot dump the output of stage "link"`},
+		{"output", builder.Options{Input: ""}, "cannot derive an output name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := builder.Compile(context.Background(), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("builder.Compile() error = %v, want %q", err, tt.want)
+			}
+			var buildErr *builder.Error
+			if !errors.As(err, &buildErr) || buildErr.Stage != builder.StageOptions {
+				t.Errorf("builder.Compile() error = %#v, want an *Error of stage %s", err, builder.StageOptions)
+			}
+		})
+	}
+}
+
+func TestCompileReturnsStageErrors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name  string
+		src   string
+		ctx   context.Context
+		opts  builder.Options
+		stage builder.Stage
+		code  string
+		line  int
+	}{
+		{name: "read", stage: builder.StageRead, code: diag.CodeRead},
+		{name: "import", src: "import \"missing\"\n", stage: builder.StageRead, code: diag.CodeModuleNotFound, line: 1},
+		{name: "lex", src: "x = 1\ny = @\n", stage: builder.StageLex, code: diag.CodeUnknownChar, line: 2},
+		{name: "parse", src: "x = (1\n", stage: builder.StageParse, code: diag.CodeSyntax, line: 1},
+		{name: "check", src: "print(1)\nprint(y)\n", stage: builder.StageCheck, code: diag.CodeUndefined, line: 2},
+		{name: "canceled", src: "print(1)\n", ctx: canceled, stage: builder.StageCheck},
+		{
+			name:  "werror",
+			src:   "fn f() {\n    x = 1\n}\nf()\n",
+			opts:  builder.Options{Warnings: lint.Config{Werror: true}},
+			stage: builder.StageLint,
+			code:  diag.CodeUnusedVariable,
+			line:  2,
+		},
+		{name: "link", src: "print(1)\n", opts: builder.Options{CC: "/nonexistent/cc"}, stage: builder.StageLink, code: diag.CodeLink},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Input = filepath.Join(t.TempDir(), "missing.pede")
+			if tt.src != "" {
+				opts.Input = buildertest.WriteSource(t, "main.pede", tt.src)
+			}
+			opts.Output = filepath.Join(t.TempDir(), "main")
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			res, err := builder.Compile(ctx, opts)
+			if res != nil {
+				t.Errorf("builder.Compile() = %+v, want no result", res)
+			}
+			var buildErr *builder.Error
+			if !errors.As(err, &buildErr) {
+				t.Fatalf("builder.Compile() error = %v, want an *builder.Error", err)
+			}
+			if buildErr.Stage != tt.stage {
+				t.Errorf("builder.Compile() failed at stage %s, want %s: %v", buildErr.Stage, tt.stage, err)
+			}
+			if tt.code == "" {
+				return
+			}
+			diags := buildErr.Diagnostics()
+			if len(diags) == 0 || diags[0].Code != tt.code || diags[0].Span.Start.Line != tt.line {
+				t.Errorf("Diagnostics() = %v, want %s at line %d", diags, tt.code, tt.line)
+			}
+		})
+	}
+}
+
+func TestCompileReportsColumnsOfIndentedCode(t *testing.T) {
+	file := buildertest.WriteSource(t, "main.pede", "x = 1\nif x > 0 {\n        print(x / y) // y is undefined\n}\n")
+	_, err := builder.Validate(context.Background(), builder.Options{Input: file})
+	var buildErr *builder.Error
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("Validate() error = %v, want an *Error", err)
+	}
+	d := buildErr.Diagnostics()[0]
+	if d.Span.Start != (diag.Pos{Line: 3, Column: 19}) || d.Source != "        print(x / y) // y is undefined" {
+		t.Errorf("Validate() error at %v in %q, want 3:19 in the line as written", d.Span.Start, d.Source)
+	}
+}
+
+func TestCompileWithoutTests(t *testing.T) {
+	for _, emit := range []string{builder.EmitTest, builder.EmitBench} {
+		t.Run(emit, func(t *testing.T) {
+			file := buildertest.WriteSource(t, "main.pede", "fn f() {\n    x = 1\n}\n")
+			// No binary is built, so no C compiler is needed
+			res, err := builder.Compile(context.Background(), builder.Options{Input: file, Emit: emit, CC: "/nonexistent/cc"})
+			if err != nil {
+				t.Fatalf("builder.Compile() error = %v", err)
+			}
+			if res.Output != "" || len(res.Tests) != 0 || len(res.Benches) != 0 {
+				t.Errorf("builder.Compile() = %+v, want no output", res)
+			}
+			if len(res.Warnings) != 1 || res.Warnings[0].Code != diag.CodeUnusedVariable {
+				t.Errorf("builder.Compile() warnings = %v, want %s", res.Warnings, diag.CodeUnusedVariable)
+			}
+		})
+	}
+}
+
+func TestCompileTests(t *testing.T) {
+	src := "test \"a\" {\n    assert(true)\n}\nbench \"b\" {\n    assert(true)\n}\ntest \"c\" {\n    assert(true)\n}\n"
+	// Without arguments a test binary lists its tests
+	out, code := buildertest.Run(t, src, builder.Options{Emit: builder.EmitTest})
+	if code != 0 || out != "a\nc\n" {
+		t.Errorf("test binary listed %q and exited with %d, want the tests a and c", out, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	file := buildertest.WriteSource(t, "main.pede", "x = 1\nx = 2\n")
+	warnings, err := builder.Validate(context.Background(), builder.Options{Input: file})
+	if err != nil {
+		t.Fatalf("builder.Validate() error = %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Code != diag.CodeUnreadVariable {
+		t.Errorf("builder.Validate() = %v, want %s", warnings, diag.CodeUnreadVariable)
+	}
+	if _, err := builder.Validate(context.Background(), builder.Options{Input: buildertest.WriteSource(t, "bad.pede", "print(y)\n")}); err == nil {
+		t.Error("builder.Validate() of an undefined variable succeeded")
+	}
+}
+
+func TestValidateChecksImportsInOrder(t *testing.T) {
+	dir := buildertest.WriteFiles(t, map[string]string{
+		"main.pede": "import \"d\"\nimport \"c\"\nprint(c.f() + d.f())\n",
+		"c.pede":    "pub fn f(): float {\n    return x\n}\n",
+		"d.pede":    "pub fn f(): float {\n    return y\n}\n",
+	})
+	// Each run must report the same module first, the one whose name sorts first
+	for range 10 {
+		_, err := builder.Validate(context.Background(), builder.Options{Input: filepath.Join(dir, "main.pede")})
+		var buildErr *builder.Error
+		if !errors.As(err, &buildErr) {
+			t.Fatalf("Validate() error = %v, want an *Error", err)
+		}
+		if d := buildErr.Diagnostics()[0]; filepath.Base(d.Span.File) != "c.pede" {
+			t.Fatalf("Validate() reported %s first, want c.pede", d.Span.File)
+		}
+	}
+}
+
+func TestValidateRejectsPrivateDeclarations(t *testing.T) {
+	tests := []struct {
+		use  string
+		want string
+	}{
+		{"print(m.f())\n", "function m.f is not public; declare it with pub"},
+		{"print(m.N)\n", "constant m.N is not public; declare it with pub"},
+		{"fn g(p: m.P) {\n}\n", "type m.P is not public; declare it with pub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			dir := buildertest.WriteFiles(t, map[string]string{
+				"main.pede": "import \"m\"\n" + tt.use,
+				"m.pede":    "export fn f(): float {\n    return 1\n}\nconst N = 1\ntype P {\n    x: float\n}\n",
+			})
+			_, err := builder.Validate(context.Background(), builder.Options{Input: filepath.Join(dir, "main.pede")})
+			var buildErr *builder.Error
+			if !errors.As(err, &buildErr) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.want)
+			}
+			if diags := buildErr.Diagnostics(); len(diags) != 1 || diags[0].Code != diag.CodeNotPublic {
+				t.Errorf("Diagnostics() = %v, want one %s", diags, diag.CodeNotPublic)
+			}
+		})
+	}
+}
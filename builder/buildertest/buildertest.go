@@ -0,0 +1,74 @@
+// Package buildertest provides helpers for tests that build and run pede programs.
+package buildertest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/builder"
+)
+
+// CC returns the C compiler that links the programs of the tests: $PEDE_TEST_CC, or clang. The
+// test is skipped if it is not installed.
+func CC(t testing.TB) string {
+	t.Helper()
+	cc := os.Getenv("PEDE_TEST_CC")
+	if cc == "" {
+		cc = "clang"
+	}
+	if _, err := exec.LookPath(cc); err != nil {
+		t.Skipf("no C compiler %s to link with; set PEDE_TEST_CC", cc)
+	}
+	return cc
+}
+
+// WriteSource writes src to name in a new temporary directory and returns its path.
+func WriteSource(t testing.TB, name, src string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// WriteFiles writes each source of files, keyed by file name, into a new temporary directory and
+// returns the directory.
+func WriteFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// Run builds src as the file main.pede with the options opts, runs it with args, and returns what it
+// wrote to stdout and stderr and its exit code. The test fails if the program does not build.
+func Run(t testing.TB, src string, opts builder.Options, args ...string) (string, int) {
+	t.Helper()
+	opts.CC = CC(t)
+	opts.Input = WriteSource(t, "main.pede", src)
+	if opts.Output == "" {
+		opts.Output = filepath.Join(filepath.Dir(opts.Input), "main")
+	}
+	res, err := builder.Compile(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+	out, err := exec.Command(res.Output, args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("running the program: %v", err)
+	}
+	return string(out), 0
+}
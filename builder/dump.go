@@ -0,0 +1,85 @@
+package builder
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/sema"
+)
+
+// DumpStages are the stages whose output Compile can dump, in the order they run
+var DumpStages = []Stage{StagePreprocess, StageLex, StageParse, StageCheck, StageCodegen}
+
+// DumpTokens writes the tokens of source to w, one per line with its position, type and value,
+// up to the first lexical error, which it returns.
+func DumpTokens(w io.Writer, source string) error {
+	lx := Lex(source)
+	for {
+		tok, err := lx.Next()
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%d:%d\t%s\t%s\n", tok.Line, tok.Col, tok.Type, strconv.Quote(tok.Value)); err != nil {
+			return err
+		}
+		if tok.Type == lexer.TokenEOF {
+			return nil
+		}
+	}
+}
+
+// DumpAST writes program to w as an indented tree, or as JSON if asJSON is set.
+func DumpAST(w io.Writer, program *ast.Program, asJSON bool) error {
+	if !asJSON {
+		return ast.Fprint(w, program, nil)
+	}
+	out, err := ast.MarshalJSON(program)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(out, '\n'))
+	return err
+}
+
+// DumpTypes writes the tree of the root module and of every module it imports to w, with the type
+// the checker computed for each expression.
+func DumpTypes(w io.Writer, root *ast.Module, info *sema.Info) error {
+	annotate := func(n any) string {
+		if t := info.TypeOf(n); t != nil {
+			return t.String()
+		}
+		return ""
+	}
+	seen := make(map[*ast.Module]bool)
+	var dump func(mod *ast.Module) error
+	dump = func(mod *ast.Module) error {
+		if seen[mod] {
+			return nil
+		}
+		seen[mod] = true
+		if err := dumpHeader(w, mod.File); err != nil {
+			return err
+		}
+		if err := ast.Fprint(w, mod.Program, annotate); err != nil {
+			return err
+		}
+		for _, name := range slices.Sorted(maps.Keys(mod.Imports)) {
+			if err := dump(mod.Imports[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return dump(root)
+}
+
+// dumpHeader starts the dump of a source file.
+func dumpHeader(w io.Writer, file string) error {
+	_, err := fmt.Fprintf(w, "// %s\n", file)
+	return err
+}
@@ -0,0 +1,78 @@
+package builder
+
+import (
+	"errors"
+
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// Stage is a step of Compile.
+type Stage string
+
+// Stages of Compile, in the order they run
+const (
+	StageOptions    Stage = "options"    // validating the options of the build
+	StageRead       Stage = "read"       // reading source files and resolving their imports
+	StagePreprocess Stage = "preprocess" // blanking out comment and blank lines
+	StageLex        Stage = "lex"        // splitting the source into tokens
+	StageParse      Stage = "parse"      // building the syntax tree
+	StageCheck      Stage = "check"      // semantic analysis and type checking
+	StageLint       Stage = "lint"       // warnings about suspicious code, which only fail with -Werror
+	StageCodegen    Stage = "codegen"    // generating and writing the LLVM IR
+	StageLink       Stage = "link"       // compiling, linking or archiving with the C toolchain
+)
+
+// Error is the failure of one stage of Compile. Err is a *diag.Diagnostic when the failure points
+// at pede source, or a diag.List for the errors of a check stage finding several and for the
+// warnings of a lint stage failing with -Werror.
+type Error struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return string(e.Stage) + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// stageCodes are the diagnostic codes of stage failures that are not diagnostics themselves
+var stageCodes = map[Stage]string{
+	StageOptions:    diag.CodeOptions,
+	StageRead:       diag.CodeRead,
+	StagePreprocess: diag.CodePreprocess,
+	StageLex:        diag.CodeSyntax,
+	StageParse:      diag.CodeSyntax,
+	StageCheck:      diag.CodeType,
+	StageLint:       diag.CodeType,
+	StageCodegen:    diag.CodeCodegen,
+	StageLink:       diag.CodeLink,
+}
+
+// Diagnostics returns the failure as diagnostics: Err itself if it is a diagnostic or a list of
+// them, or else a diagnostic with the code of the stage and no source location.
+func (e *Error) Diagnostics() []*diag.Diagnostic {
+	var list diag.List
+	if errors.As(e.Err, &list) {
+		return list
+	}
+	var d *diag.Diagnostic
+	if errors.As(e.Err, &d) {
+		return []*diag.Diagnostic{d}
+	}
+	return []*diag.Diagnostic{{Severity: diag.Error, Code: stageCodes[e.Stage], Message: e.Err.Error()}}
+}
+
+// stageError wraps err as a failure of stage. A diagnostic without a file is attributed to file.
+func stageError(stage Stage, file string, err error) *Error {
+	var d *diag.Diagnostic
+	if errors.As(err, &d) && d.Span.File == "" {
+		d.Span.File = file
+		for i := range d.Suggestions {
+			d.Suggestions[i].Span.File = file
+		}
+	}
+	return &Error{Stage: stage, Err: err}
+}
@@ -0,0 +1,258 @@
+package builder
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/preprocessor"
+)
+
+// loader reads, parses and links together a program and the modules it imports.
+type loader struct {
+	root    string                 // directory of the main file
+	opts    LoadOptions            // how modules are found and read
+	modules map[string]*ast.Module // loaded modules, by absolute file path
+	stack   []string               // files being loaded, innermost last, to detect import cycles
+}
+
+// LoadOptions configures LoadModulesWith.
+type LoadOptions struct {
+	// ImportPaths are extra directories searched for imported modules
+	ImportPaths []string
+	// ReadFile reads the file at an absolute path; nil reads it from disk. Editors pass the
+	// buffers of open files through it.
+	ReadFile func(abs string) ([]byte, error)
+	// Raw parses the sources as they are instead of preprocessing them, which keeps the
+	// comments as written
+	Raw bool
+	// Cache, if set, reuses the parse of files whose source has not changed since the last load
+	Cache *ParseCache
+	// DumpAfter is StagePreprocess, StageLex or StageParse to write the output of that stage for
+	// each file to Dump as it is loaded: its source, tokens or syntax tree
+	DumpAfter Stage
+	Dump      io.Writer
+}
+
+// ParseCache keeps the programs parsed from each file, so that loading a program again only
+// parses the files that changed. It is safe for concurrent use.
+type ParseCache struct {
+	mu      sync.Mutex
+	entries map[string]parsed // by absolute file path
+}
+
+type parsed struct {
+	code    string
+	source  string
+	program *ast.Program
+}
+
+func (c *ParseCache) get(abs, code string) (parsed, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.entries[abs]
+	return p, ok && p.code == code
+}
+
+func (c *ParseCache) put(abs string, p parsed) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]parsed)
+	}
+	c.entries[abs] = p
+}
+
+// LoadModules parses the main file and, recursively, every module it imports. An import "p" is
+// resolved to p.pede next to the importing file, then in each of the import paths in order.
+// Failures are returned as an *Error of the read, preprocess, lex or parse stage.
+func LoadModules(file string, importPaths []string) (*ast.Module, error) {
+	return LoadModulesWith(file, LoadOptions{ImportPaths: importPaths})
+}
+
+// LoadModulesWith is LoadModules configured by opts.
+func LoadModulesWith(file string, opts LoadOptions) (*ast.Module, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, stageError(StageRead, file, err)
+	}
+	if opts.ReadFile == nil {
+		opts.ReadFile = os.ReadFile
+	}
+	l := &loader{
+		root:    filepath.Dir(abs),
+		opts:    opts,
+		modules: make(map[string]*ast.Module),
+	}
+	return l.load(abs, file, "main")
+}
+
+// load parses the file at abs, displayed as file in errors, then loads its imports.
+func (l *loader) load(abs, file, path string) (*ast.Module, error) {
+	code, err := l.opts.ReadFile(abs)
+	if err != nil {
+		return nil, stageError(StageRead, file, err)
+	}
+	p, err := l.parse(abs, file, string(code))
+	if err != nil {
+		return nil, err
+	}
+	source, program := p.source, p.program
+	mod := &ast.Module{
+		Path:    path,
+		File:    file,
+		Source:  source,
+		Program: program,
+		Imports: make(map[string]*ast.Module),
+		Ignores: preprocessor.Ignores(string(code)),
+	}
+	l.modules[abs] = mod
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	lines := strings.Split(source, "\n")
+	// errorf reports an error spanning the rest of the line of decl
+	errorf := func(decl *ast.ImportDecl, code, format string, args ...any) error {
+		src := ""
+		if decl.Line >= 1 && decl.Line <= len(lines) {
+			src = lines[decl.Line-1]
+		}
+		span := diag.Span{
+			File:  file,
+			Start: diag.Pos{Line: decl.Line, Column: decl.Column},
+			End:   diag.Pos{Line: decl.Line, Column: utf8.RuneCountInString(src) + 1},
+		}
+		return stageError(StageRead, file, diag.Errorf(code, span, src, format, args...))
+	}
+	for _, stmt := range program.Stmts {
+		decl, ok := stmt.(*ast.ImportDecl)
+		if !ok {
+			continue
+		}
+		if _, dup := mod.Imports[decl.Name]; dup {
+			return nil, errorf(decl, diag.CodeDuplicateImport, "%s is already imported", decl.Name)
+		}
+		depAbs, depPath, ok := l.resolve(filepath.Dir(abs), decl.Path)
+		if !ok {
+			return nil, errorf(decl, diag.CodeModuleNotFound, "cannot find module %q", decl.Path)
+		}
+		for i, loading := range l.stack {
+			if loading == depAbs {
+				return nil, errorf(decl, diag.CodeImportCycle, "import cycle: %s", l.cycle(l.stack[i:], depAbs))
+			}
+		}
+		dep, loaded := l.modules[depAbs]
+		if !loaded {
+			if dep, err = l.load(depAbs, l.display(depAbs), depPath); err != nil {
+				return nil, err
+			}
+		}
+		mod.Imports[decl.Name] = dep
+	}
+	return mod, nil
+}
+
+// parse preprocesses, lexes and parses code, the content of the file at abs displayed as file,
+// or takes the result from the cache.
+func (l *loader) parse(abs, file, code string) (parsed, error) {
+	if l.opts.Cache != nil {
+		if p, ok := l.opts.Cache.get(abs, code); ok {
+			return p, nil
+		}
+	}
+	source := code
+	if !l.opts.Raw {
+		var err error
+		if source, err = Preprocess(code); err != nil {
+			return parsed{}, stageError(StagePreprocess, file, err)
+		}
+	}
+	if err := l.dump(StagePreprocess, file, func(w io.Writer) error {
+		_, err := io.WriteString(w, source)
+		return err
+	}); err != nil {
+		return parsed{}, stageError(StagePreprocess, file, err)
+	}
+	if err := lexer.Check(source); err != nil {
+		return parsed{}, stageError(StageLex, file, err)
+	}
+	if err := l.dump(StageLex, file, func(w io.Writer) error { return DumpTokens(w, source) }); err != nil {
+		return parsed{}, stageError(StageLex, file, err)
+	}
+	program, err := Parse(Lex(source))
+	if err != nil {
+		return parsed{}, stageError(StageParse, file, err)
+	}
+	if err := l.dump(StageParse, file, func(w io.Writer) error { return DumpAST(w, program, false) }); err != nil {
+		return parsed{}, stageError(StageParse, file, err)
+	}
+	p := parsed{code: code, source: source, program: program}
+	if l.opts.Cache != nil {
+		l.opts.Cache.put(abs, p)
+	}
+	return p, nil
+}
+
+// dump writes the output of stage for file with write if the options ask for it.
+func (l *loader) dump(stage Stage, file string, write func(w io.Writer) error) error {
+	if l.opts.DumpAfter != stage || l.opts.Dump == nil {
+		return nil
+	}
+	if err := dumpHeader(l.opts.Dump, file); err != nil {
+		return err
+	}
+	return write(l.opts.Dump)
+}
+
+// resolve finds the file of the module imported as importPath from a file in dir, and returns its
+// absolute path and module path.
+func (l *loader) resolve(dir, importPath string) (string, string, bool) {
+	rel := filepath.FromSlash(importPath) + ".pede"
+	candidates := []string{filepath.Join(dir, rel)}
+	for _, p := range l.opts.ImportPaths {
+		candidates = append(candidates, filepath.Join(p, rel))
+	}
+	for _, c := range candidates {
+		abs, err := filepath.Abs(c)
+		if err != nil {
+			continue
+		}
+		if info, err := os.Stat(abs); err == nil && !info.IsDir() {
+			return abs, l.modulePath(abs), true
+		}
+	}
+	return "", "", false
+}
+
+// modulePath derives the unique path of a module from its file, relative to the main file's
+// directory when possible.
+func (l *loader) modulePath(abs string) string {
+	p := l.display(abs)
+	return filepath.ToSlash(strings.TrimSuffix(p, ".pede"))
+}
+
+// display returns the path of a file relative to the main file's directory, or abs if it lies
+// outside of it.
+func (l *loader) display(abs string) string {
+	rel, err := filepath.Rel(l.root, abs)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return abs
+	}
+	return rel
+}
+
+// cycle renders an import cycle as a.pede -> b.pede -> a.pede.
+func (l *loader) cycle(stack []string, back string) string {
+	var names []string
+	for _, f := range stack {
+		names = append(names, l.display(f))
+	}
+	names = append(names, l.display(back))
+	return strings.Join(names, " -> ")
+}
@@ -5,7 +5,14 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/engpetarmarinov/pede/cli/cmds/bench"
 	"github.com/engpetarmarinov/pede/cli/cmds/build"
+	"github.com/engpetarmarinov/pede/cli/cmds/check"
+	"github.com/engpetarmarinov/pede/cli/cmds/dump"
+	"github.com/engpetarmarinov/pede/cli/cmds/fmt"
+	"github.com/engpetarmarinov/pede/cli/cmds/lsp"
+	"github.com/engpetarmarinov/pede/cli/cmds/test"
+	"github.com/engpetarmarinov/pede/logutil"
 )
 
 type Options struct {
@@ -23,6 +30,14 @@ Options:
 
 Commands:
   build <input.pede>   Build the specified .pede file
+  check [path ...]     Check .pede files for errors without building them
+  test [path ...]      Run the test blocks of .pede files
+  bench [path ...]     Run the benchmark blocks of .pede files
+  fmt [path ...]       Format .pede files in the canonical style
+  tokens <input.pede>  Print the tokens of a .pede file
+  ast <input.pede>     Print the syntax tree of a .pede file (--json for JSON)
+  ir <input.pede>      Print the LLVM IR generated for a .pede file
+  lsp                  Run the language server for editors over stdio
   help                 Show this help message
 `)
 }
@@ -40,7 +55,31 @@ func Run(opts *Options) {
 	switch opts.Cmd {
 	case "build":
 		buildOpts := build.Parse(flag.Args()[1:])
+		if buildOpts.DumpAfter != "" {
+			// The dump goes to standard output, so logs go to standard error
+			logutil.SetupTo(opts.LogLevel, os.Stderr)
+		}
 		build.Run(buildOpts)
+	case "check":
+		checkOpts := check.Parse(flag.Args()[1:])
+		check.Run(checkOpts)
+	case "test":
+		testOpts := test.Parse(flag.Args()[1:])
+		test.Run(testOpts)
+	case "bench":
+		benchOpts := bench.Parse(flag.Args()[1:])
+		bench.Run(benchOpts)
+	case "fmt":
+		fmtOpts := fmt.Parse(flag.Args()[1:])
+		fmt.Run(fmtOpts)
+	case "tokens", "ast", "ir":
+		dumpOpts := dump.Parse(opts.Cmd, flag.Args()[1:])
+		dump.Run(dumpOpts)
+	case "lsp":
+		// Standard output carries the protocol, so logs go to standard error
+		logutil.SetupTo(opts.LogLevel, os.Stderr)
+		lspOpts := lsp.Parse(flag.Args()[1:])
+		lsp.Run(lspOpts)
 	case "help":
 		Usage()
 	default:
@@ -0,0 +1,407 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/cli/cmdutil"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lint"
+)
+
+type Options struct {
+	Paths     []string
+	Run       string
+	OptLevel  string
+	BenchTime time.Duration
+	Count     int
+	Timeout   time.Duration
+	Save      string
+	Compare   string
+	CC        string
+
+	Warnings lint.Config
+
+	ImportPaths []string
+	LibPaths    []string
+	Libs        []string
+}
+
+func Usage() {
+	slog.Info(`pede bench - Run the benchmark blocks of .pede files
+
+Usage:
+  pede bench [options] [path ...]
+
+Builds every file given and every .pede file found in the directories given, the current
+directory by default, into a benchmark binary of its bench "name" { ... } blocks, and runs each
+benchmark in a process of its own. The number of iterations is calibrated until a sample takes
+--benchtime, then --count samples are timed, and the mean time per iteration is reported with its
+standard deviation. The exit status is 1 if any benchmark failed or any file did not build.
+
+Options:
+  -run <regexp>     Only run the benchmarks whose name matches regexp
+  -O<level>         Optimize at level 0, 1, 2, 3, s or z, as with cc -O (default: 2)
+  --benchtime <d>   Time each sample should take, e.g. 500ms (default: 100ms)
+  --count <n>       Number of samples of each benchmark (default: 10)
+  --save <file>     Write the results to file as JSON, to compare later runs against
+  --compare <file>  Compare the results with those saved in file by --save
+  --timeout <d>     Stop a benchmark running longer than d (default: 10m)
+  -I <dir>          Also look for imported modules in dir (repeatable)
+  -l <lib>          Link the library lib, e.g. -l m for libm (alias --link-lib, repeatable)
+  -L <dir>          Also look for libraries in dir (repeatable)
+  --cc <compiler>   Use specified C compiler (clang or gcc, default: clang)
+  -W<name>          Report the warning name, by name or code; -Wall reports every
+                    warning (the default)
+  -Wno-<name>       Do not report the warning name; -Wno-all reports none
+  -Werror           Treat warnings as errors
+`)
+}
+
+// Result is the outcome of a benchmark, as saved by --save.
+type Result struct {
+	File       string  `json:"file"`
+	Name       string  `json:"name"`
+	Iterations int64   `json:"iterations"` // iterations of each sample
+	Samples    int     `json:"samples"`
+	NsPerOp    float64 `json:"ns_per_op"` // mean of the samples
+	StdDev     float64 `json:"stddev"`    // standard deviation of the samples, in ns/op
+}
+
+// variance returns the standard deviation of r as a percentage of its mean.
+func (r *Result) variance() float64 {
+	if r.NsPerOp == 0 {
+		return 0
+	}
+	return 100 * r.StdDev / r.NsPerOp
+}
+
+// Baseline is the JSON file written by --save and read by --compare.
+type Baseline struct {
+	OptLevel   string    `json:"opt_level"`
+	Benchmarks []*Result `json:"benchmarks"`
+}
+
+// Run runs the benchmarks of the files of opts and exits with status 1 if any of them failed.
+func Run(opts *Options) {
+	var baseline map[string]*Result
+	if opts.Compare != "" {
+		var err error
+		baseline, err = loadBaseline(opts.Compare, opts.OptLevel)
+		if err != nil {
+			slog.Error("failed to read the baseline", "file", opts.Compare, "err", err)
+			os.Exit(1)
+		}
+	}
+	files, err := cmdutil.Collect(opts.Paths)
+	if err != nil {
+		slog.Error("failed to read source", "err", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		slog.Error("No .pede files to benchmark.", "paths", strings.Join(opts.Paths, " "))
+		os.Exit(1)
+	}
+	filter := regexp.MustCompile(opts.Run)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	dir, err := os.MkdirTemp("", "pede-bench")
+	if err != nil {
+		slog.Error("failed to create a directory for benchmark binaries", "err", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	saved := &Baseline{OptLevel: opts.OptLevel}
+	found := false
+	passed := true
+	for i, file := range files {
+		results, has, ok := benchFile(ctx, opts, filter, baseline, file, filepath.Join(dir, fmt.Sprintf("bench%d", i)))
+		found = found || has
+		passed = passed && ok
+		saved.Benchmarks = append(saved.Benchmarks, results...)
+	}
+	if !found {
+		slog.Warn("No bench blocks found.", "paths", strings.Join(opts.Paths, " "))
+	}
+	// A failed run would overwrite the baseline with partial results
+	if opts.Save != "" && passed {
+		if err := saveBaseline(opts.Save, saved); err != nil {
+			slog.Error("failed to save the results", "file", opts.Save, "err", err)
+			passed = false
+		}
+	}
+	if !passed {
+		// os.Exit skips the deferred calls
+		stop()
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+}
+
+// benchFile builds file into the benchmark binary output and runs those of its benchmarks that
+// match filter, reporting them on stdout next to their baseline results if there are any. It
+// returns the results, whether the file has benchmarks and whether it built and every benchmark ran.
+func benchFile(ctx context.Context, opts *Options, filter *regexp.Regexp, baseline map[string]*Result, file, output string) ([]*Result, bool, bool) {
+	start := time.Now()
+	res, err := builder.Compile(ctx, builder.Options{
+		Input:    file,
+		Output:   output,
+		CC:       opts.CC,
+		Emit:     builder.EmitBench,
+		OptLevel: opts.OptLevel,
+		// The call stack bookkeeping would be timed along with the benchmarks
+		StackTrace:  false,
+		Warnings:    opts.Warnings,
+		ImportPaths: opts.ImportPaths,
+		LibPaths:    opts.LibPaths,
+		Libs:        opts.Libs,
+	})
+	var diags []*diag.Diagnostic
+	if res != nil {
+		diags = res.Warnings
+	}
+	if err != nil {
+		var buildErr *builder.Error
+		if errors.As(err, &buildErr) {
+			diags = append(diags, buildErr.Diagnostics()...)
+		} else {
+			diags = append(diags, &diag.Diagnostic{Severity: diag.Error, Message: err.Error()})
+		}
+	}
+	if werr := diag.Write(os.Stderr, diag.FormatText, diags, diag.ColorEnabled(os.Stderr)); werr != nil {
+		slog.Error("failed to write diagnostics", "err", werr)
+	}
+	if err != nil {
+		fmt.Printf("FAIL\t%s\t[build failed]\n", file)
+		return nil, true, false
+	}
+	if len(res.Benches) == 0 {
+		return nil, false, true
+	}
+
+	var benches []*ast.BenchDecl
+	width := 0
+	for _, bench := range res.Benches {
+		if filter.MatchString(bench.Name) {
+			benches = append(benches, bench)
+			width = max(width, len(bench.Name))
+		}
+	}
+	var results []*Result
+	ok := true
+	for _, bench := range benches {
+		r, out, err := runBench(ctx, opts, res.Output, file, bench)
+		if err != nil {
+			ok = false
+			fmt.Printf("--- ERROR: %s at %s:%d\n", bench.Name, file, bench.Line)
+			for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+				if line != "" {
+					fmt.Printf("    %s\n", line)
+				}
+			}
+			fmt.Printf("    %v\n", err)
+			continue
+		}
+		results = append(results, r)
+		line := fmt.Sprintf("%-*s\t%10d\t%12.2f ns/op ±%.1f%%", width, bench.Name, r.Iterations, r.NsPerOp, r.variance())
+		if old, ok := baseline[key(file, bench.Name)]; ok {
+			line += "\t" + compare(old, r)
+		} else if baseline != nil {
+			line += "\t(not in baseline)"
+		}
+		fmt.Println(line)
+	}
+	elapsed := time.Since(start)
+	switch {
+	case !ok:
+		fmt.Printf("FAIL\t%s\t%.2fs\n", file, elapsed.Seconds())
+	case len(results) == 0:
+		fmt.Printf("ok  \t%s\t%.2fs [no benchmarks to run]\n", file, elapsed.Seconds())
+	default:
+		fmt.Printf("ok  \t%s\t%.2fs\n", file, elapsed.Seconds())
+	}
+	return results, true, ok
+}
+
+// runBench runs bench in a process of its own, by running the benchmark binary bin with its name,
+// and returns its result, or the output of the binary and the reason it failed.
+func runBench(ctx context.Context, opts *Options, bin, file string, bench *ast.BenchDecl) (*Result, string, error) {
+	samples, err := os.CreateTemp(filepath.Dir(bin), "samples")
+	if err != nil {
+		return nil, "", err
+	}
+	samples.Close()
+	defer os.Remove(samples.Name())
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, bench.Name, fmt.Sprint(opts.BenchTime.Nanoseconds()), fmt.Sprint(opts.Count), samples.Name())
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, out.String(), fmt.Errorf("benchmark timed out after %s", opts.Timeout)
+	}
+	if err != nil {
+		return nil, out.String(), fmt.Errorf("benchmark binary: %w", err)
+	}
+	r, err := readSamples(samples.Name())
+	if err != nil {
+		return nil, out.String(), err
+	}
+	r.File = file
+	r.Name = bench.Name
+	return r, out.String(), nil
+}
+
+// readSamples reads the samples written by a benchmark binary, one "iterations nanoseconds" line
+// per sample, and returns their mean and standard deviation per iteration.
+func readSamples(file string) (*Result, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &Result{}
+	var perOp []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var n, ns int64
+		if _, err := fmt.Sscan(scanner.Text(), &n, &ns); err != nil || n <= 0 {
+			return nil, fmt.Errorf("malformed sample %q", scanner.Text())
+		}
+		r.Iterations = n
+		perOp = append(perOp, float64(ns)/float64(n))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(perOp) == 0 {
+		return nil, errors.New("the benchmark wrote no samples")
+	}
+	r.Samples = len(perOp)
+	for _, v := range perOp {
+		r.NsPerOp += v
+	}
+	r.NsPerOp /= float64(len(perOp))
+	if len(perOp) > 1 {
+		var sum float64
+		for _, v := range perOp {
+			sum += (v - r.NsPerOp) * (v - r.NsPerOp)
+		}
+		r.StdDev = math.Sqrt(sum / float64(len(perOp)-1))
+	}
+	return r, nil
+}
+
+// compare describes the change from old to cur. A difference within the sum of their standard
+// deviations is reported as ~, no significant change.
+func compare(old, cur *Result) string {
+	was := fmt.Sprintf("was %.2f ns/op ±%.1f%%", old.NsPerOp, old.variance())
+	diff := cur.NsPerOp - old.NsPerOp
+	if math.Abs(diff) <= old.StdDev+cur.StdDev || old.NsPerOp == 0 {
+		return fmt.Sprintf("~ (%s)", was)
+	}
+	return fmt.Sprintf("%+.2f%% (%s)", 100*diff/old.NsPerOp, was)
+}
+
+// key identifies a benchmark across runs.
+func key(file, name string) string {
+	return filepath.ToSlash(filepath.Clean(file)) + "\x00" + name
+}
+
+// loadBaseline reads the results saved in file, by benchmark key. It warns if they were measured
+// at another optimization level than optLevel.
+func loadBaseline(file, optLevel string) (map[string]*Result, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, err
+	}
+	if b.OptLevel != optLevel {
+		slog.Warn("The baseline was measured at another optimization level.", "baseline", "-O"+b.OptLevel, "now", "-O"+optLevel)
+	}
+	results := make(map[string]*Result, len(b.Benchmarks))
+	for _, r := range b.Benchmarks {
+		results[key(r.File, r.Name)] = r
+	}
+	return results, nil
+}
+
+// saveBaseline writes b to file as indented JSON.
+func saveBaseline(file string, b *Baseline) error {
+	if b.Benchmarks == nil {
+		b.Benchmarks = []*Result{}
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0o644)
+}
+
+func Parse(args []string) *Options {
+	opts := Options{OptLevel: "2"}
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&opts.Run, "run", "", "only run the benchmarks whose name matches the regexp")
+	fs.DurationVar(&opts.BenchTime, "benchtime", 100*time.Millisecond, "time each sample should take")
+	fs.IntVar(&opts.Count, "count", 10, "number of samples of each benchmark")
+	fs.StringVar(&opts.Save, "save", "", "write the results to the file as JSON")
+	fs.StringVar(&opts.Compare, "compare", "", "compare the results with those saved in the file")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Minute, "time limit of each benchmark")
+	fs.StringVar(&opts.CC, "cc", "clang", "C compiler to use (clang or gcc)")
+	fs.Var((*cmdutil.StringList)(&opts.ImportPaths), "I", "directory to search for imported modules (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.Libs), "l", "library to link (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.Libs), "link-lib", "library to link (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.LibPaths), "L", "directory to search for libraries (repeatable)")
+	fs.Usage = Usage
+	rest, err := cmdutil.ParseGlued(args, &opts.Warnings, &opts.OptLevel)
+	if err != nil {
+		slog.Error("Invalid option.", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if err := fs.Parse(rest); err != nil {
+		slog.Error("Error parsing flags", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if _, err := regexp.Compile(opts.Run); err != nil {
+		slog.Error("Invalid -run pattern.", "pattern", opts.Run, "err", err)
+		os.Exit(1)
+	}
+	if opts.BenchTime <= 0 || opts.Timeout <= 0 {
+		slog.Error("The bench time and timeout must be positive.", "benchtime", opts.BenchTime, "timeout", opts.Timeout)
+		os.Exit(1)
+	}
+	if opts.Count < 1 {
+		slog.Error("The count must be at least 1.", "count", opts.Count)
+		os.Exit(1)
+	}
+	opts.Paths = fs.Args()
+	if len(opts.Paths) == 0 {
+		opts.Paths = []string{"."}
+	}
+	return &opts
+}
@@ -1,13 +1,18 @@
 package build
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"strings"
+	"os/signal"
+	"slices"
 
 	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/cli/cmdutil"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lint"
 )
 
 type Options struct {
@@ -17,6 +22,18 @@ type Options struct {
 	Output string
 	KeepIR bool
 	CC     string
+	AR     string
+	Emit   string
+
+	OptLevel          string
+	StackTrace        bool
+	DiagnosticsFormat string
+	Warnings          lint.Config
+	DumpAfter         string
+
+	ImportPaths []string
+	LibPaths    []string
+	Libs        []string
 }
 
 func Usage() {
@@ -26,27 +43,89 @@ Usage:
   pede build [options] <input.pede>
 
 Options:
-  -o <output>     Output binary name (default: input filename without extension)
+  -o <output>     Output binary name (default: input filename without extension,
+                  lib<name>.a for static libraries)
+  --emit <kind>   Output kind: exe, or staticlib for a static library of the exported
+                  functions with a C header next to it (default: exe)
+  -O<level>       Optimize at level 0, 1, 2, 3, s or z, as with cc -O (default: the
+                  default of the C compiler)
   --keep-ir       Keep the generated LLVM IR file (default: delete after linking)
+  --stack-trace   Print the pede call stack on runtime panics; --stack-trace=false
+                  leaves the bookkeeping out of release builds (default: true)
+  -I <dir>        Also look for imported modules in dir (repeatable)
+  -l <lib>        Link the library lib, e.g. -l m for libm (alias --link-lib, repeatable)
+  -L <dir>        Also look for libraries in dir (repeatable)
   --cc <compiler> Use specified C compiler (clang or gcc, default: clang)
+  --ar <archiver> Use specified archiver for static libraries (default: ar)
   --os <os>       Operating system target (default: current OS)
   --arch <arch>   Architecture target (default: current architecture)
   --log <level>   Set log level (DEBUG, INFO, WARN, ERROR; default: DEBUG)
+  --diagnostics-format <format>
+                  Write errors and warnings to stderr as text, json (an array of
+                  diagnostics) or sarif (a SARIF 2.1.0 log) (default: text)
+  -W<name>        Report the warning name, by name or code, e.g. -Wunused-variable
+                  or -WW0101; -Wall reports every warning (the default)
+  -Wno-<name>     Do not report the warning name; -Wno-all reports none
+  -Werror         Fail the build if any warning is reported
+  --dump-after <stage>
+                  Print the output of a stage to stdout for debugging, then go on with
+                  the build: preprocess (the preprocessed source), lex (the tokens), parse
+                  (the syntax tree), check (the syntax tree with the type of each
+                  expression) or codegen (the LLVM IR); logs then go to stderr
+
+Warnings: shadow, unused-variable, unread-variable, unreachable-code,
+constant-condition and self-assign. A // pede:ignore <name>... comment
+suppresses them on its line, or on the next line when it stands alone.
 
 Note: pede depends on clang by default to link the generated LLVM IR to a native executable.
 `)
 }
 
+// Run builds the input and exits with status 1 if the build fails.
 func Run(opts *Options) {
-	builderOpts := &builder.Options{
+	builderOpts := builder.Options{
 		OS:     opts.OS,
 		ARCH:   opts.ARCH,
 		Input:  opts.Input,
 		Output: opts.Output,
 		KeepIR: opts.KeepIR,
 		CC:     opts.CC,
+		AR:     opts.AR,
+		Emit:   opts.Emit,
+
+		OptLevel:   opts.OptLevel,
+		StackTrace: opts.StackTrace,
+		Warnings:   opts.Warnings,
+		DumpAfter:  builder.Stage(opts.DumpAfter),
+		Dump:       os.Stdout,
+
+		ImportPaths: opts.ImportPaths,
+		LibPaths:    opts.LibPaths,
+		Libs:        opts.Libs,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := builder.Compile(ctx, builderOpts)
+	var diags []*diag.Diagnostic
+	if res != nil {
+		diags = res.Warnings
+	}
+	if err != nil {
+		var buildErr *builder.Error
+		if errors.As(err, &buildErr) {
+			diags = append(diags, buildErr.Diagnostics()...)
+		} else {
+			diags = append(diags, &diag.Diagnostic{Severity: diag.Error, Message: err.Error()})
+		}
+	}
+	if werr := diag.Write(os.Stderr, opts.DiagnosticsFormat, diags, diag.ColorEnabled(os.Stderr)); werr != nil {
+		slog.Error("failed to write diagnostics", "err", werr)
+	}
+	if err != nil {
+		stop()
+		os.Exit(1)
 	}
-	builder.Build(builderOpts)
+	slog.Info("pede was built", "output", res.Output, "OS", opts.OS, "ARCH", opts.ARCH)
 }
 
 func Parse(args []string) *Options {
@@ -54,11 +133,26 @@ func Parse(args []string) *Options {
 	fs := flag.NewFlagSet("build", flag.ExitOnError)
 	fs.StringVar(&opts.Output, "o", "", "output binary name")
 	fs.BoolVar(&opts.KeepIR, "keep-ir", false, "keep the generated LLVM IR file")
+	fs.StringVar(&opts.DiagnosticsFormat, "diagnostics-format", diag.FormatText, "diagnostics format: text, json or sarif")
+	fs.BoolVar(&opts.StackTrace, "stack-trace", true, "print the pede call stack on runtime panics")
 	fs.StringVar(&opts.OS, "os", "", "target operating system (default: current OS)")
 	fs.StringVar(&opts.ARCH, "arch", "", "target architecture (default: current architecture)")
 	fs.StringVar(&opts.CC, "cc", "clang", "C compiler to use (clang or gcc)")
+	fs.StringVar(&opts.AR, "ar", "ar", "archiver used for static libraries")
+	fs.StringVar(&opts.Emit, "emit", builder.EmitExe, "output kind: exe or staticlib")
+	fs.StringVar(&opts.DumpAfter, "dump-after", "", "print the output of a stage: preprocess, lex, parse, check or codegen")
+	fs.Var((*cmdutil.StringList)(&opts.ImportPaths), "I", "directory to search for imported modules (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.Libs), "l", "library to link (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.Libs), "link-lib", "library to link (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.LibPaths), "L", "directory to search for libraries (repeatable)")
 	fs.Usage = Usage
-	err := fs.Parse(args)
+	rest, err := cmdutil.ParseGlued(args, &opts.Warnings, &opts.OptLevel)
+	if err != nil {
+		slog.Error("Invalid option.", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	err = fs.Parse(rest)
 	if err != nil {
 		slog.Error("Error parsing flags", "err", err)
 		Usage()
@@ -75,9 +169,23 @@ func Parse(args []string) *Options {
 		Usage()
 		os.Exit(1)
 	}
+	if opts.Emit != builder.EmitExe && opts.Emit != builder.EmitStaticLib {
+		slog.Error("Unknown output kind. Use --emit=exe or --emit=staticlib.", "emit", opts.Emit)
+		Usage()
+		os.Exit(1)
+	}
+	if opts.DumpAfter != "" && !slices.Contains(builder.DumpStages, builder.Stage(opts.DumpAfter)) {
+		slog.Error("Unknown stage to dump. Use preprocess, lex, parse, check or codegen.", "stage", opts.DumpAfter)
+		Usage()
+		os.Exit(1)
+	}
+	if opts.DiagnosticsFormat != diag.FormatText && opts.DiagnosticsFormat != diag.FormatJSON && opts.DiagnosticsFormat != diag.FormatSARIF {
+		slog.Error("Unknown diagnostics format. Use text, json or sarif.", "format", opts.DiagnosticsFormat)
+		Usage()
+		os.Exit(1)
+	}
 	if opts.Output == "" {
-		base := filepath.Base(opts.Input)
-		opts.Output = strings.TrimSuffix(base, filepath.Ext(base))
+		opts.Output = builder.OutputName(opts.Input, opts.Emit)
 		if opts.Output == "" {
 			slog.Error("Could not determine output file name. Use -o to specify output.")
 			Usage()
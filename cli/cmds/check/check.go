@@ -0,0 +1,160 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/cli/cmdutil"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lint"
+)
+
+type Options struct {
+	Paths             []string
+	ImportPaths       []string
+	DiagnosticsFormat string
+	Warnings          lint.Config
+}
+
+func Usage() {
+	slog.Info(`pede check - Check .pede files without building them
+
+Usage:
+  pede check [options] [path ...]
+
+Preprocesses, parses, type checks and lints the given files and the .pede files found in the given
+directories, the current directory by default, together with the modules they import. Files are
+checked in parallel and no code is generated, so neither clang nor the linker runs. Every error
+and warning found is reported, and the exit status is 1 if there were errors.
+
+Options:
+  -I <dir>        Also look for imported modules in dir (repeatable)
+  --diagnostics-format <format>
+                  Write errors and warnings to stderr as text, json (an array of
+                  diagnostics) or sarif (a SARIF 2.1.0 log) (default: text)
+  -W<name>        Report the warning name, by name or code; -Wall reports every
+                  warning (the default)
+  -Wno-<name>     Do not report the warning name; -Wno-all reports none
+  -Werror         Treat warnings as errors
+`)
+}
+
+// Run checks the files of opts and exits with status 1 if any of them has errors.
+func Run(opts *Options) {
+	files, err := cmdutil.Collect(opts.Paths)
+	if err != nil {
+		slog.Error("failed to read source", "err", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		slog.Error("No .pede files to check.", "paths", strings.Join(opts.Paths, " "))
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results := make([][]*diag.Diagnostic, len(files))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	for i, file := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = checkFile(ctx, opts, file)
+		}()
+	}
+	wg.Wait()
+
+	// A module imported by several checked files is reported once
+	var diags []*diag.Diagnostic
+	seen := make(map[string]bool)
+	failed := false
+	for _, result := range results {
+		for _, d := range result {
+			key := fmt.Sprintf("%s:%d:%d:%s:%s", d.Span.File, d.Span.Start.Line, d.Span.Start.Column, d.Code, d.Message)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			diags = append(diags, d)
+			if d.Severity == diag.Error {
+				failed = true
+			}
+		}
+	}
+	if werr := diag.Write(os.Stderr, opts.DiagnosticsFormat, diags, diag.ColorEnabled(os.Stderr)); werr != nil {
+		slog.Error("failed to write diagnostics", "err", werr)
+	}
+	if failed {
+		stop()
+		os.Exit(1)
+	}
+	slog.Debug("pede check passed", "files", len(files))
+}
+
+// checkFile checks the program whose main file is file and returns its diagnostics, with the files
+// of imported modules given relative to the current directory like file.
+func checkFile(ctx context.Context, opts *Options, file string) []*diag.Diagnostic {
+	diags, err := builder.Validate(ctx, builder.Options{
+		Input:       file,
+		ImportPaths: opts.ImportPaths,
+		Warnings:    opts.Warnings,
+	})
+	if err != nil {
+		var buildErr *builder.Error
+		if errors.As(err, &buildErr) {
+			diags = append(diags, buildErr.Diagnostics()...)
+		} else {
+			diags = append(diags, &diag.Diagnostic{Severity: diag.Error, Message: err.Error()})
+		}
+	}
+	for _, d := range diags {
+		switch {
+		case d.Span.File == "":
+			d.Span.File = file
+		case d.Span.File != file && !filepath.IsAbs(d.Span.File):
+			d.Span.File = filepath.Join(filepath.Dir(file), d.Span.File)
+		}
+	}
+	return diags
+}
+
+func Parse(args []string) *Options {
+	var opts Options
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Var((*cmdutil.StringList)(&opts.ImportPaths), "I", "directory to search for imported modules (repeatable)")
+	fs.StringVar(&opts.DiagnosticsFormat, "diagnostics-format", diag.FormatText, "diagnostics format: text, json or sarif")
+	fs.Usage = Usage
+	rest, err := cmdutil.ParseGlued(args, &opts.Warnings, nil)
+	if err != nil {
+		slog.Error("Invalid option.", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if err := fs.Parse(rest); err != nil {
+		slog.Error("Error parsing flags", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if opts.DiagnosticsFormat != diag.FormatText && opts.DiagnosticsFormat != diag.FormatJSON && opts.DiagnosticsFormat != diag.FormatSARIF {
+		slog.Error("Unknown diagnostics format. Use text, json or sarif.", "format", opts.DiagnosticsFormat)
+		Usage()
+		os.Exit(1)
+	}
+	opts.Paths = fs.Args()
+	if len(opts.Paths) == 0 {
+		opts.Paths = []string{"."}
+	}
+	return &opts
+}
@@ -0,0 +1,170 @@
+package dump
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/cli/cmdutil"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+type Options struct {
+	Cmd         string // tokens, ast or ir
+	Input       string
+	JSON        bool
+	ImportPaths []string
+	OS          string
+	ARCH        string
+	Emit        string
+	StackTrace  bool
+}
+
+func Usage() {
+	slog.Info(`pede tokens, ast, ir - Print the output of a compiler stage for debugging
+
+Usage:
+  pede tokens <input.pede>
+  pede ast [--json] <input.pede>
+  pede ir [options] <input.pede>
+
+tokens prints the tokens the lexer reads from the preprocessed source, one per line with its
+position, type and value. ast prints the syntax tree of the file as an indented tree, or as JSON
+with --json. ir prints the LLVM IR generated for the file and the modules it imports.
+
+Options of ir:
+  -I <dir>        Also look for imported modules in dir (repeatable)
+  --emit <kind>   Generate the IR of an exe, a staticlib, or a test or bench binary
+                  (default: exe)
+  --stack-trace   Keep track of the pede call stack for runtime panics (default: true)
+  --os <os>       Operating system target (default: current OS)
+  --arch <arch>   Architecture target (default: current architecture)
+
+The same output is printed during a build by pede build --dump-after=<stage>.
+`)
+}
+
+// Run prints the output of the stage of opts.Cmd and exits with status 1 if the input cannot be
+// compiled that far.
+func Run(opts *Options) {
+	var err error
+	switch opts.Cmd {
+	case "tokens":
+		err = tokens(opts)
+	case "ast":
+		err = tree(opts)
+	case "ir":
+		err = ir(opts)
+	}
+	if err == nil {
+		return
+	}
+	var diags []*diag.Diagnostic
+	var buildErr *builder.Error
+	if errors.As(err, &buildErr) {
+		diags = buildErr.Diagnostics()
+	} else {
+		diags = []*diag.Diagnostic{{Severity: diag.Error, Message: err.Error()}}
+	}
+	for _, d := range diags {
+		if d.Span.File == "" && d.Located() {
+			d.Span.File = opts.Input
+		}
+	}
+	if werr := diag.Render(os.Stderr, diags, diag.ColorEnabled(os.Stderr)); werr != nil {
+		slog.Error("failed to write diagnostics", "err", werr)
+	}
+	os.Exit(1)
+}
+
+// source returns the preprocessed source of the input.
+func source(opts *Options) (string, error) {
+	code, err := os.ReadFile(opts.Input)
+	if err != nil {
+		return "", &builder.Error{Stage: builder.StageRead, Err: err}
+	}
+	src, err := builder.Preprocess(string(code))
+	if err != nil {
+		return "", &builder.Error{Stage: builder.StagePreprocess, Err: err}
+	}
+	return src, nil
+}
+
+func tokens(opts *Options) error {
+	src, err := source(opts)
+	if err != nil {
+		return err
+	}
+	if err := builder.DumpTokens(os.Stdout, src); err != nil {
+		return &builder.Error{Stage: builder.StageLex, Err: err}
+	}
+	return nil
+}
+
+func tree(opts *Options) error {
+	src, err := source(opts)
+	if err != nil {
+		return err
+	}
+	program, err := builder.Parse(builder.Lex(src))
+	if err != nil {
+		return &builder.Error{Stage: builder.StageParse, Err: err}
+	}
+	return builder.DumpAST(os.Stdout, program, opts.JSON)
+}
+
+func ir(opts *Options) error {
+	root, err := builder.LoadModules(opts.Input, opts.ImportPaths)
+	if err != nil {
+		return err
+	}
+	library := opts.Emit == builder.EmitStaticLib
+	info, err := builder.Check(root, library)
+	if err != nil {
+		return &builder.Error{Stage: builder.StageCheck, Err: err}
+	}
+	cg, err := builder.Codegen(root, info, opts.OS, opts.ARCH, opts.Emit, opts.StackTrace)
+	if err != nil {
+		return &builder.Error{Stage: builder.StageCodegen, Err: err}
+	}
+	if _, err := cg.WriteTo(os.Stdout); err != nil {
+		return &builder.Error{Stage: builder.StageCodegen, Err: err}
+	}
+	return nil
+}
+
+// Parse parses the arguments of the command cmd: tokens, ast or ir.
+func Parse(cmd string, args []string) *Options {
+	opts := Options{Cmd: cmd}
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	switch cmd {
+	case "ast":
+		fs.BoolVar(&opts.JSON, "json", false, "print the tree as JSON")
+	case "ir":
+		fs.Var((*cmdutil.StringList)(&opts.ImportPaths), "I", "directory to search for imported modules (repeatable)")
+		fs.StringVar(&opts.Emit, "emit", builder.EmitExe, "output kind: exe, staticlib, test or bench")
+		fs.BoolVar(&opts.StackTrace, "stack-trace", true, "keep track of the pede call stack for runtime panics")
+		fs.StringVar(&opts.OS, "os", "", "target operating system (default: current OS)")
+		fs.StringVar(&opts.ARCH, "arch", "", "target architecture (default: current architecture)")
+	}
+	fs.Usage = Usage
+	if err := fs.Parse(args); err != nil {
+		slog.Error("Error parsing flags", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if fs.NArg() != 1 {
+		slog.Error("Usage: pede " + cmd + " [options] <input.pede>")
+		Usage()
+		os.Exit(1)
+	}
+	opts.Input = fs.Arg(0)
+	if opts.Emit != "" && opts.Emit != builder.EmitExe && opts.Emit != builder.EmitStaticLib && opts.Emit != builder.EmitTest && opts.Emit != builder.EmitBench {
+		slog.Error("Unknown output kind. Use --emit=exe, --emit=staticlib, --emit=test or --emit=bench.", "emit", opts.Emit)
+		Usage()
+		os.Exit(1)
+	}
+	return &opts
+}
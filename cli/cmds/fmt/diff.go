@@ -0,0 +1,117 @@
+package fmt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// diffOp is one line of a line diff: kept, deleted from the old text or inserted from the new one.
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns the changes from old to new in the unified format of diff -u, with both
+// sides named after file.
+func unifiedDiff(file string, old, new []byte) []byte {
+	ops := diffLines(splitLines(string(old)), splitLines(string(new)))
+	var b strings.Builder
+	b.WriteString("--- " + file + "\n+++ " + file + "\n")
+	// oldLine and newLine are the 1-based lines of ops[i] in either text
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+		// A hunk runs from some context before the change to the context after the last change
+		// that is closer than twice the context to the one before it
+		lo := max(i-diffContext, 0)
+		oldLine -= i - lo
+		newLine -= i - lo
+		hi := i
+		for k := i; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				hi = k
+			} else if k-hi > 2*diffContext {
+				break
+			}
+		}
+		hi = min(hi+diffContext+1, len(ops))
+		oldCount, newCount := 0, 0
+		for _, op := range ops[lo:hi] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		b.WriteString("@@ -" + hunkRange(oldLine, oldCount) + " +" + hunkRange(newLine, newCount) + " @@\n")
+		for _, op := range ops[lo:hi] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line + "\n")
+		}
+		oldLine += oldCount
+		newLine += newCount
+		i = hi
+	}
+	return []byte(b.String())
+}
+
+// hunkRange returns the start,count range of a hunk header; an empty range starts at the line
+// before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	return strconv.Itoa(start) + "," + strconv.Itoa(count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit from a to b, found through their longest common
+// subsequence of lines.
+func diffLines(a, b []string) []diffOp {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	return ops
+}
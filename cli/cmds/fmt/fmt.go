@@ -0,0 +1,142 @@
+package fmt
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/format"
+)
+
+type Options struct {
+	Write bool
+	Diff  bool
+	Check bool
+	Paths []string
+}
+
+func Usage() {
+	slog.Info(`pede fmt - Format .pede source files
+
+Usage:
+  pede fmt [options] [path ...]
+
+Formats the given files, and the .pede files found in the given directories, in the canonical
+style: blocks indented by four spaces, single spaces around binary operators and after commas, and
+at most one blank line in a row. Comments are kept. Without paths, standard input is formatted to
+standard output.
+
+Options:
+  -w              Rewrite the files that are not formatted in place
+  -d              Print a unified diff of the changes instead of the formatted source
+  --check         Only list the files that are not formatted, and exit with status 1 if
+                  there are any, for use in CI
+`)
+}
+
+// Run formats the files of opts and exits with status 1 if one of them cannot be parsed or, with
+// Check, is not formatted.
+func Run(opts *Options) {
+	if len(opts.Paths) == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			slog.Error("failed to read standard input", "err", err)
+			os.Exit(1)
+		}
+		if !formatFile(opts, "<stdin>", src, nil) {
+			os.Exit(1)
+		}
+		return
+	}
+	ok := true
+	for _, path := range opts.Paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Walk only picks up .pede files inside directories; files given by name are always formatted
+			if d.IsDir() || file != path && filepath.Ext(file) != ".pede" {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			src, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if !formatFile(opts, file, src, info) {
+				ok = false
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("failed to read source", "path", path, "err", err)
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// formatFile formats the source src of file as opts ask and reports whether that succeeded. info
+// describes the file, nil for standard input.
+func formatFile(opts *Options, file string, src []byte, info fs.FileInfo) bool {
+	out, err := format.Source(src)
+	if err != nil {
+		var d *diag.Diagnostic
+		if !errors.As(err, &d) {
+			d = &diag.Diagnostic{Severity: diag.Error, Code: diag.CodeSyntax, Message: err.Error()}
+		}
+		d.Span.File = file
+		if werr := diag.Render(os.Stderr, []*diag.Diagnostic{d}, diag.ColorEnabled(os.Stderr)); werr != nil {
+			slog.Error("failed to write diagnostics", "err", werr)
+		}
+		return false
+	}
+	changed := !bytes.Equal(src, out)
+	if opts.Check {
+		if changed {
+			os.Stdout.WriteString(file + "\n")
+		}
+		return !changed
+	}
+	if opts.Diff && changed {
+		os.Stdout.Write(unifiedDiff(file, src, out))
+	}
+	if opts.Write && info != nil {
+		if changed {
+			if err := os.WriteFile(file, out, info.Mode().Perm()); err != nil {
+				slog.Error("failed to write formatted source", "file", file, "err", err)
+				return false
+			}
+		}
+	} else if !opts.Diff {
+		os.Stdout.Write(out)
+	}
+	return true
+}
+
+func Parse(args []string) *Options {
+	var opts Options
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	flags.BoolVar(&opts.Write, "w", false, "rewrite files in place")
+	flags.BoolVar(&opts.Diff, "d", false, "print diffs")
+	flags.BoolVar(&opts.Check, "check", false, "list unformatted files and fail if there are any")
+	flags.Usage = Usage
+	if err := flags.Parse(args); err != nil {
+		slog.Error("Error parsing flags", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	opts.Paths = flags.Args()
+	return &opts
+}
@@ -0,0 +1,52 @@
+package lsp
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/engpetarmarinov/pede/cli/cmdutil"
+	"github.com/engpetarmarinov/pede/lsp"
+)
+
+type Options struct {
+	ImportPaths []string
+}
+
+func Usage() {
+	slog.Info(`pede lsp - Run the language server
+
+Usage:
+  pede lsp [options]
+
+Speaks the Language Server Protocol over standard input and output, for editors to show
+diagnostics, hover types, go-to-definition, references, rename and completion in .pede files.
+Logs are written to standard error.
+
+Options:
+  -I <dir>        Also look for imported modules in dir (repeatable)
+`)
+}
+
+// Run serves an editor over stdio until it exits, and exits with status 1 if it did not shut the
+// server down first.
+func Run(opts *Options) {
+	server := lsp.NewServer(os.Stdin, os.Stdout, opts.ImportPaths)
+	if err := server.Run(); err != nil {
+		slog.Error("Language server stopped", "err", err)
+		os.Exit(1)
+	}
+}
+
+func Parse(args []string) *Options {
+	var opts Options
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Var((*cmdutil.StringList)(&opts.ImportPaths), "I", "directory to search for imported modules (repeatable)")
+	fs.Usage = Usage
+	if err := fs.Parse(args); err != nil {
+		slog.Error("Error parsing flags", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	return &opts
+}
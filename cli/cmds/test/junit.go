@@ -0,0 +1,116 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The JUnit XML report has a testsuite per file, holding a testcase per test that ran. A file that
+// did not build gets a single testcase in error, named "[build failed]".
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitProblem is the failure or error of a test case: its first line as the message, and the
+// whole output.
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the results of suites to file as JUnit XML.
+func writeJUnit(file string, suites []*suite) error {
+	report := junitSuites{}
+	var total time.Duration
+	for _, s := range suites {
+		js := junitSuite{Name: s.file, Time: seconds(s.elapsed)}
+		if s.buildErr != "" {
+			js.Cases = append(js.Cases, junitCase{
+				Name:      "[build failed]",
+				Classname: s.file,
+				File:      s.file,
+				Time:      seconds(s.elapsed),
+				Error:     &junitProblem{Message: "build failed", Type: "build", Text: s.buildErr},
+			})
+			js.Tests, js.Errors = 1, 1
+		}
+		for _, r := range s.results {
+			jc := junitCase{
+				Name:      r.test.Name,
+				Classname: s.file,
+				File:      s.file,
+				Line:      r.test.Line,
+				Time:      seconds(r.elapsed),
+			}
+			switch r.status {
+			case "fail":
+				jc.Failure = &junitProblem{Message: message(r.output), Type: "assertion", Text: r.output}
+				js.Failures++
+			case "error":
+				jc.Error = &junitProblem{Message: message(r.output), Type: "error", Text: r.output}
+				js.Errors++
+			default:
+				jc.SystemOut = r.output
+			}
+			js.Tests++
+			js.Cases = append(js.Cases, jc)
+		}
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Errors += js.Errors
+		total += s.elapsed
+		report.Suites = append(report.Suites, js)
+	}
+	report.Time = seconds(total)
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append([]byte(xml.Header), append(out, '\n')...), 0o644)
+}
+
+// message returns the line of the output of a test that says why it did not pass: the failed
+// assertion or the panic, or else the last line.
+func message(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "assertion failed") || strings.HasPrefix(line, "panic: ") {
+			return line
+		}
+	}
+	return lines[len(lines)-1]
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,286 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/cli/cmdutil"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lint"
+	"github.com/engpetarmarinov/pede/rt"
+)
+
+type Options struct {
+	Paths   []string
+	Run     string
+	JUnit   string
+	Verbose bool
+	Timeout time.Duration
+	CC      string
+
+	Warnings lint.Config
+
+	ImportPaths []string
+	LibPaths    []string
+	Libs        []string
+}
+
+func Usage() {
+	slog.Info(`pede test - Run the test blocks of .pede files
+
+Usage:
+  pede test [options] [path ...]
+
+Builds every file given and every .pede file found in the directories given, the current
+directory by default, into a test binary of its test "name" { ... } blocks, and runs each test in
+a process of its own. A test fails when an assert or assert_eq fails, and is an error when it
+panics, exits with a non-zero code or times out. The exit status is 1 if any test did not pass or
+any file did not build.
+
+Options:
+  -run <regexp>   Only run the tests whose name matches regexp
+  -v              Also report the tests that pass, and their output
+  --junit <file>  Write the results to file as JUnit XML
+  --timeout <d>   Stop a test running longer than d, e.g. 30s (default: 1m)
+  -I <dir>        Also look for imported modules in dir (repeatable)
+  -l <lib>        Link the library lib, e.g. -l m for libm (alias --link-lib, repeatable)
+  -L <dir>        Also look for libraries in dir (repeatable)
+  --cc <compiler> Use specified C compiler (clang or gcc, default: clang)
+  -W<name>        Report the warning name, by name or code; -Wall reports every
+                  warning (the default)
+  -Wno-<name>     Do not report the warning name; -Wno-all reports none
+  -Werror         Treat warnings as errors
+`)
+}
+
+// result is the outcome of a test.
+type result struct {
+	test    *ast.TestDecl
+	status  string // "pass", "fail" or "error"
+	output  string // what the test wrote to stdout and stderr
+	elapsed time.Duration
+}
+
+// suite is the outcome of the tests of a file.
+type suite struct {
+	file     string
+	results  []*result
+	buildErr string // the errors of a file that did not build
+	elapsed  time.Duration
+}
+
+// passed reports whether every test of the suite passed.
+func (s *suite) passed() bool {
+	if s.buildErr != "" {
+		return false
+	}
+	for _, r := range s.results {
+		if r.status != "pass" {
+			return false
+		}
+	}
+	return true
+}
+
+// Run runs the tests of the files of opts and exits with status 1 if any of them did not pass.
+func Run(opts *Options) {
+	files, err := cmdutil.Collect(opts.Paths)
+	if err != nil {
+		slog.Error("failed to read source", "err", err)
+		os.Exit(1)
+	}
+	if len(files) == 0 {
+		slog.Error("No .pede files to test.", "paths", strings.Join(opts.Paths, " "))
+		os.Exit(1)
+	}
+	filter := regexp.MustCompile(opts.Run)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	dir, err := os.MkdirTemp("", "pede-test")
+	if err != nil {
+		slog.Error("failed to create a directory for test binaries", "err", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(dir)
+
+	var suites []*suite
+	passed := true
+	for i, file := range files {
+		s := testFile(ctx, opts, filter, file, filepath.Join(dir, fmt.Sprintf("test%d", i)))
+		if s == nil {
+			continue
+		}
+		suites = append(suites, s)
+		passed = passed && s.passed()
+	}
+	if len(suites) == 0 {
+		slog.Warn("No test blocks found.", "paths", strings.Join(opts.Paths, " "))
+	}
+	if opts.JUnit != "" {
+		if err := writeJUnit(opts.JUnit, suites); err != nil {
+			slog.Error("failed to write the JUnit report", "file", opts.JUnit, "err", err)
+			passed = false
+		}
+	}
+	if !passed {
+		// os.Exit skips the deferred calls
+		stop()
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+}
+
+// testFile builds file into the test binary output and runs those of its tests that match filter,
+// reporting them on stdout. It returns nil for a file without tests.
+func testFile(ctx context.Context, opts *Options, filter *regexp.Regexp, file, output string) *suite {
+	start := time.Now()
+	res, err := builder.Compile(ctx, builder.Options{
+		Input:       file,
+		Output:      output,
+		CC:          opts.CC,
+		Emit:        builder.EmitTest,
+		StackTrace:  true,
+		Warnings:    opts.Warnings,
+		ImportPaths: opts.ImportPaths,
+		LibPaths:    opts.LibPaths,
+		Libs:        opts.Libs,
+	})
+	var warnings, errs []*diag.Diagnostic
+	if res != nil {
+		warnings = res.Warnings
+	}
+	if err != nil {
+		var buildErr *builder.Error
+		if errors.As(err, &buildErr) {
+			errs = buildErr.Diagnostics()
+		} else {
+			errs = []*diag.Diagnostic{{Severity: diag.Error, Message: err.Error()}}
+		}
+	}
+	if werr := diag.Write(os.Stderr, diag.FormatText, append(warnings, errs...), diag.ColorEnabled(os.Stderr)); werr != nil {
+		slog.Error("failed to write diagnostics", "err", werr)
+	}
+	if err != nil {
+		var text bytes.Buffer
+		diag.Write(&text, diag.FormatText, errs, false)
+		fmt.Printf("FAIL\t%s\t[build failed]\n", file)
+		return &suite{file: file, buildErr: text.String(), elapsed: time.Since(start)}
+	}
+	if len(res.Tests) == 0 {
+		if opts.Verbose {
+			fmt.Printf("?   \t%s\t[no tests]\n", file)
+		}
+		return nil
+	}
+
+	s := &suite{file: file}
+	for _, test := range res.Tests {
+		if !filter.MatchString(test.Name) {
+			continue
+		}
+		if opts.Verbose {
+			fmt.Printf("=== RUN   %s\n", test.Name)
+		}
+		r := runTest(ctx, opts, res.Output, test)
+		s.results = append(s.results, r)
+		if r.status != "pass" || opts.Verbose {
+			fmt.Printf("--- %s: %s at %s:%d (%.2fs)\n", strings.ToUpper(r.status), test.Name, file, test.Line, r.elapsed.Seconds())
+			for _, line := range strings.Split(strings.TrimRight(r.output, "\n"), "\n") {
+				if line != "" {
+					fmt.Printf("    %s\n", line)
+				}
+			}
+		}
+	}
+	s.elapsed = time.Since(start)
+	switch {
+	case !s.passed():
+		fmt.Printf("FAIL\t%s\t%.2fs\n", file, s.elapsed.Seconds())
+	case len(s.results) == 0:
+		fmt.Printf("ok  \t%s\t%.2fs [no tests to run]\n", file, s.elapsed.Seconds())
+	default:
+		fmt.Printf("ok  \t%s\t%.2fs\n", file, s.elapsed.Seconds())
+	}
+	return s
+}
+
+// runTest runs test in a process of its own, by running the test binary bin with its name.
+func runTest(ctx context.Context, opts *Options, bin string, test *ast.TestDecl) *result {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, test.Name)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	start := time.Now()
+	err := cmd.Run()
+	r := &result{test: test, status: "pass", elapsed: time.Since(start)}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		r.status = "error"
+		fmt.Fprintf(&out, "test timed out after %s\n", opts.Timeout)
+	// A failed assertion fails the test; any other failure, such as a panic, is an error
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == rt.AssertExit:
+		r.status = "fail"
+	case err != nil:
+		r.status = "error"
+		if exitErr == nil || !strings.Contains(out.String(), "panic: ") {
+			fmt.Fprintf(&out, "test binary: %v\n", err)
+		}
+	}
+	r.output = out.String()
+	return r
+}
+
+func Parse(args []string) *Options {
+	var opts Options
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.StringVar(&opts.Run, "run", "", "only run the tests whose name matches the regexp")
+	fs.BoolVar(&opts.Verbose, "v", false, "also report the tests that pass")
+	fs.StringVar(&opts.JUnit, "junit", "", "write the results to the file as JUnit XML")
+	fs.DurationVar(&opts.Timeout, "timeout", time.Minute, "time limit of each test")
+	fs.StringVar(&opts.CC, "cc", "clang", "C compiler to use (clang or gcc)")
+	fs.Var((*cmdutil.StringList)(&opts.ImportPaths), "I", "directory to search for imported modules (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.Libs), "l", "library to link (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.Libs), "link-lib", "library to link (repeatable)")
+	fs.Var((*cmdutil.StringList)(&opts.LibPaths), "L", "directory to search for libraries (repeatable)")
+	fs.Usage = Usage
+	rest, err := cmdutil.ParseGlued(args, &opts.Warnings, nil)
+	if err != nil {
+		slog.Error("Invalid option.", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if err := fs.Parse(rest); err != nil {
+		slog.Error("Error parsing flags", "err", err)
+		Usage()
+		os.Exit(1)
+	}
+	if _, err := regexp.Compile(opts.Run); err != nil {
+		slog.Error("Invalid -run pattern.", "pattern", opts.Run, "err", err)
+		os.Exit(1)
+	}
+	if opts.Timeout <= 0 {
+		slog.Error("The timeout must be positive.", "timeout", opts.Timeout)
+		os.Exit(1)
+	}
+	opts.Paths = fs.Args()
+	if len(opts.Paths) == 0 {
+		opts.Paths = []string{"."}
+	}
+	return &opts
+}
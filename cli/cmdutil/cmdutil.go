@@ -0,0 +1,71 @@
+// Package cmdutil holds the flag and argument handling shared by the pede commands.
+package cmdutil
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/lint"
+)
+
+// StringList is a repeatable string flag.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *StringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// Collect returns the files named by paths and the .pede files inside the directories among them.
+func Collect(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Walk only picks up .pede files inside directories; files given by name are always included
+			if !d.IsDir() && (file == path || filepath.Ext(file) == ".pede") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// ParseGlued parses the -W options of args into warnings and, if optLevel is not nil, the -O
+// options into optLevel, and returns the other arguments. These options glue their value to the
+// flag name, which the flag package cannot parse.
+func ParseGlued(args []string, warnings *lint.Config, optLevel *string) ([]string, error) {
+	var rest []string
+	for _, arg := range args {
+		if level, ok := strings.CutPrefix(arg, "-O"); ok && optLevel != nil {
+			if !slices.Contains(builder.OptLevels, level) {
+				return nil, fmt.Errorf("unknown optimization level %s, use -O0, -O1, -O2, -O3, -Os or -Oz", arg)
+			}
+			*optLevel = level
+			continue
+		}
+		opt, ok := strings.CutPrefix(arg, "-W")
+		if !ok || opt == "" {
+			rest = append(rest, arg)
+			continue
+		}
+		if err := warnings.Set(opt); err != nil {
+			return nil, fmt.Errorf("invalid warning option %s: %w", arg, err)
+		}
+	}
+	return rest, nil
+}
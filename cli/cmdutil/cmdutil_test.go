@@ -0,0 +1,62 @@
+package cmdutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/lint"
+)
+
+func TestParseGlued(t *testing.T) {
+	var warnings lint.Config
+	var level string
+	rest, err := ParseGlued([]string{"-O2", "-Wno-shadow", "-I", "lib", "-W", "main.pede"}, &warnings, &level)
+	if err != nil {
+		t.Fatalf("ParseGlued() error = %v", err)
+	}
+	if want := []string{"-I", "lib", "-W", "main.pede"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("ParseGlued() = %q, want %q", rest, want)
+	}
+	if level != "2" {
+		t.Errorf("optimization level = %q, want 2", level)
+	}
+	if !warnings.Disabled["shadow"] {
+		t.Error("-Wno-shadow left the shadow warning enabled")
+	}
+	// Commands without optimization levels leave -O to the flag package
+	if rest, err := ParseGlued([]string{"-O2"}, &warnings, nil); err != nil || !reflect.DeepEqual(rest, []string{"-O2"}) {
+		t.Errorf("ParseGlued() without a level = %q, %v, want [-O2]", rest, err)
+	}
+	for _, arg := range []string{"-O7", "-Wbogus"} {
+		if _, err := ParseGlued([]string{arg}, &warnings, &level); err == nil {
+			t.Errorf("ParseGlued(%s) succeeded, want an error", arg)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.pede", "notes.txt", filepath.Join("sub", "b.pede")} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes := filepath.Join(dir, "notes.txt")
+	files, err := Collect([]string{dir, notes})
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "a.pede"), filepath.Join(dir, "sub", "b.pede"), notes}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Collect() = %q, want %q", files, want)
+	}
+	if _, err := Collect([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Collect() of a missing path succeeded, want an error")
+	}
+}
@@ -0,0 +1,240 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/sema"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A function value is a %pede_fn pair of a code pointer and an environment pointer. The code takes
+// the environment as a hidden first parameter, followed by the parameters of the signature. The
+// environment of a function literal is a heap allocated struct holding copies of the variables it
+// captures; declared functions used as values have no environment.
+
+// funcType returns the LLVM type of the code of function values with signature sig.
+func (cg *Codegen) funcType(sig *sema.Signature) (*types.FuncType, error) {
+	params := []types.Type{types.I8Ptr}
+	for _, p := range sig.Params {
+		t, err := cg.llvmType(p)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, t)
+	}
+	var result types.Type = types.Void
+	if sig.Result != nil {
+		t, err := cg.llvmType(sig.Result)
+		if err != nil {
+			return nil, err
+		}
+		result = t
+	}
+	return types.NewFunc(result, params...), nil
+}
+
+// callFuncValue emits a call of the function value fv, which has signature sig, made at node.
+func (cg *Codegen) callFuncValue(fv value.Value, sig *sema.Signature, node any, args ...value.Value) (value.Value, error) {
+	ft, err := cg.funcType(sig)
+	if err != nil {
+		return nil, cg.at(node, err)
+	}
+	cg.markLine(node)
+	code := cg.block.NewExtractValue(fv, 0)
+	env := cg.block.NewExtractValue(fv, 1)
+	callee := cg.block.NewBitCast(code, types.NewPointer(ft))
+	return cg.block.NewCall(callee, append([]value.Value{env}, args...)...), nil
+}
+
+// funcValue returns the function value of the declared function f: a wrapper taking the unused
+// environment pointer and calling f, emitted on first use.
+func (cg *Codegen) funcValue(f *sema.Func) value.Value {
+	wrapper, ok := cg.funcValues[f]
+	if !ok {
+		target := cg.funcs[f]
+		params := []*ir.Param{ir.NewParam("env", types.I8Ptr)}
+		args := make([]value.Value, len(target.Params))
+		for i, p := range target.Params {
+			param := ir.NewParam(p.Name(), p.Typ)
+			params = append(params, param)
+			args[i] = param
+		}
+		wrapper = cg.mod.NewFunc(target.Name()+".value", target.Sig.RetType, params...)
+		wrapper.Linkage = enum.LinkageInternal
+		entry := wrapper.NewBlock("entry")
+		call := entry.NewCall(target, args...)
+		if f.Result == nil {
+			entry.NewRet(nil)
+		} else {
+			entry.NewRet(call)
+		}
+		cg.funcValues[f] = wrapper
+	}
+	code := constant.NewBitCast(wrapper, types.I8Ptr)
+	return constant.NewStruct(cg.fnType.(*types.StructType), code, constant.NewNull(types.I8Ptr))
+}
+
+// genFuncLit emits the code of a function literal and returns a function value pairing it with a
+// new environment holding the current values of the variables it captures.
+func (cg *Codegen) genFuncLit(n *ast.FuncLit) (value.Value, error) {
+	cl := cg.info.Closures[n]
+	envFields := make([]types.Type, len(cl.Captures))
+	for i, v := range cl.Captures {
+		t, err := cg.llvmType(v.Type)
+		if err != nil {
+			return nil, cg.at(n, err)
+		}
+		envFields[i] = t
+	}
+	envType := types.NewStruct(envFields...)
+	code, err := cg.genClosureCode(n, cl, envType)
+	if err != nil {
+		return nil, err
+	}
+
+	env := value.Value(constant.NewNull(types.I8Ptr))
+	if len(cl.Captures) > 0 {
+		newFn := cg.runtimeFunc("pede_new", types.I8Ptr, types.I64)
+		env = cg.block.NewCall(newFn, sizeOf(envType))
+		envPtr := cg.block.NewBitCast(env, types.NewPointer(envType))
+		for i, v := range cl.Captures {
+			slot := cg.vars[v]
+			field := cg.block.NewGetElementPtr(envType, envPtr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			cg.block.NewStore(cg.block.NewLoad(slot.ElemType, slot), field)
+		}
+	}
+	fv := cg.block.NewInsertValue(constant.NewUndef(cg.fnType), constant.NewBitCast(code, types.I8Ptr), 0)
+	return cg.block.NewInsertValue(fv, env, 1), nil
+}
+
+// genClosureCode emits the function implementing the literal n. The captured variables are copied
+// from the environment into stack slots of their own, so the literal can read them like locals.
+func (cg *Codegen) genClosureCode(n *ast.FuncLit, cl *sema.Closure, envType *types.StructType) (*ir.Func, error) {
+	fn, entry, block, vars, tries, frame := cg.fn, cg.entry, cg.block, cg.vars, cg.tries, cg.frame
+	defer func() {
+		cg.fn, cg.entry, cg.block, cg.vars, cg.tries, cg.frame = fn, entry, block, vars, tries, frame
+	}()
+	sig := cl.Func.Signature()
+	ft, err := cg.funcType(sig)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	params := []*ir.Param{ir.NewParam("env", types.I8Ptr)}
+	for i, p := range cl.Params {
+		params = append(params, ir.NewParam(p.Name, ft.Params[i+1]))
+	}
+	name := fmt.Sprintf("%s.literal.%d", cl.Func.Module, cg.closureCount)
+	cg.closureCount++
+	cg.fn = cg.mod.NewFunc(name, ft.RetType, params...)
+	cg.fn.Linkage = enum.LinkageInternal
+	cg.entry = cg.fn.NewBlock("entry")
+	cg.block = cg.entry
+	cg.vars = make(map[*sema.Var]*ir.InstAlloca)
+	cg.tries = nil
+	cg.enterFrame(cg.fn.Name())
+
+	if len(cl.Captures) > 0 {
+		envPtr := cg.block.NewBitCast(params[0], types.NewPointer(envType))
+		for i, v := range cl.Captures {
+			field := cg.block.NewGetElementPtr(envType, envPtr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i)))
+			cg.assign(v, cg.block.NewLoad(envType.Fields[i], field))
+		}
+	}
+	for i, v := range cl.Params {
+		cg.assign(v, params[i+1])
+	}
+	stmts := n.Body.Stmts
+	if cl.Result != nil {
+		stmts = stmts[:len(stmts)-1]
+	}
+	for _, stmt := range stmts {
+		if err := cg.GenStmt(stmt); err != nil {
+			return nil, err
+		}
+	}
+	if cl.Result != nil {
+		val, err := cg.genReturnValue(cl.Result)
+		if err != nil {
+			return nil, err
+		}
+		cg.genRet(val)
+	} else if cg.block.Term == nil {
+		if sig.Result == nil {
+			cg.genRet(nil)
+		} else {
+			// sema guarantees that every path returns; this block is unreachable
+			cg.block.NewUnreachable()
+		}
+	}
+	return cg.fn, nil
+}
+
+// genHigherOrder emits the built-ins taking a function value: map, filter and reduce. Each calls
+// the function on the elements of the list in order.
+func (cg *Codegen) genHigherOrder(n *ast.Call) (value.Value, error) {
+	name := n.Func.(*ast.Variable).Name
+	listType := cg.info.TypeOf(n.Args[0]).(*sema.List)
+	elemType, err := cg.llvmType(listType.Elem)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	args, err := cg.genArgs(n.Args)
+	if err != nil {
+		return nil, err
+	}
+	list, f := args[0], args[len(args)-1]
+	sig := cg.info.TypeOf(n.Args[len(args)-1]).(*sema.Signature)
+	if name == "reduce" {
+		acc := cg.newAlloca(args[1].Type())
+		cg.block.NewStore(args[1], acc)
+		err := cg.genLoop(list, elemType, n, func(elem value.Value) error {
+			val, err := cg.callFuncValue(f, sig, n, cg.block.NewLoad(acc.ElemType, acc), elem)
+			if err != nil {
+				return err
+			}
+			cg.block.NewStore(val, acc)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return cg.block.NewLoad(acc.ElemType, acc), nil
+	}
+
+	resultElem, err := cg.llvmType(cg.info.TypeOf(n).(*sema.List).Elem)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	newList := cg.runtimeFunc("pede_list_new", cg.listType, types.I64, types.I64)
+	lenFn := cg.runtimeFunc("pede_list_len", types.Double, cg.listType)
+	capacity := cg.block.NewFPToSI(cg.block.NewCall(lenFn, list), types.I64)
+	result := cg.block.NewCall(newList, sizeOf(resultElem), capacity)
+	err = cg.genLoop(list, elemType, n, func(elem value.Value) error {
+		val, err := cg.callFuncValue(f, sig, n, elem)
+		if err != nil {
+			return err
+		}
+		if name == "map" {
+			cg.genAppend(result, val)
+			return nil
+		}
+		keepBlock := cg.newBlock("filter.keep")
+		nextBlock := cg.newBlock("filter.next")
+		cg.block.NewCondBr(val, keepBlock, nextBlock)
+		cg.block = keepBlock
+		cg.genAppend(result, elem)
+		cg.branchTo(nextBlock)
+		cg.block = nextBlock
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
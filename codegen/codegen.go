@@ -1,37 +1,73 @@
 package codegen
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
 	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/sema"
 
 	"github.com/llir/llvm/ir"
 	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
 	"github.com/llir/llvm/ir/types"
 	"github.com/llir/llvm/ir/value"
 )
 
 type Codegen struct {
-	mod           *ir.Module
-	block         *ir.Block
-	vars          map[string]*ir.InstAlloca
-	fmtStrGlobal  *ir.Global            // cache for float format string global
-	fmtStrSGlobal *ir.Global            // cache for string format string global
-	strGlobals    map[string]*ir.Global // cache for string literals
+	// StackTrace makes the generated code keep a shadow call stack, so that runtime panics print
+	// the pede functions active at the time. Release builds may turn it off.
+	StackTrace bool
+
+	mod            *ir.Module
+	info           *sema.Info // types computed by the checker
+	file           string     // path of the .pede source, reported by runtime errors
+	lines          []string   // source lines of file, quoted by compile errors
+	listType       types.Type // %pede_list*, the runtime list handle
+	mapType        types.Type // %pede_map*, the runtime map handle
+	fnType         types.Type // %pede_fn, a function value: its code and environment pointers
+	fn             *ir.Func   // function currently being generated
+	entry          *ir.Block  // entry block of fn, home of all allocas
+	block          *ir.Block
+	vars           map[*sema.Var]*ir.InstAlloca // stack slots of the variables resolved by the checker
+	structs        map[string]*types.StructType // LLVM types of the declared structs and enums, by LLVM type name
+	funcs          map[*sema.Func]*ir.Func      // LLVM functions of the declared functions
+	funcValues     map[*sema.Func]*ir.Func      // wrappers calling declared functions used as values
+	closureCount   int                          // counter used to give function literals unique names
+	tries          []tryTarget                  // enclosing try statements of the current function, innermost last
+	frame          *ir.InstAlloca               // shadow stack frame of the current function, nil without StackTrace
+	traceTopGlobal *ir.Global                   // @pede_trace_top, the innermost shadow stack frame
+	fmtStrGlobal   *ir.Global                   // cache for float format string global
+	fmtStrSGlobal  *ir.Global                   // cache for string format string global
+	strGlobals     map[string]*ir.Global        // cache for string literals
+	blockCount     int                          // counter used to give basic blocks unique names
 }
 
-// NewCodegen initializes a new Codegen instance with a module and entry block.
-func NewCodegen(os, arch string) *Codegen {
+// NewCodegen initializes a new Codegen instance with an empty module.
+// file is the source path reported by runtime errors and info holds the checked types.
+func NewCodegen(os, arch, file string, info *sema.Info) *Codegen {
 	mod := ir.NewModule()
 	mod.TargetTriple = getTargetTriple(os, arch)
-	mainFn := mod.NewFunc("main", types.Void)
-	entry := mainFn.NewBlock("entry")
+	listType := types.NewPointer(mod.NewTypeDef("pede_list", &types.StructType{Opaque: true}))
+	mapType := types.NewPointer(mod.NewTypeDef("pede_map", &types.StructType{Opaque: true}))
+	fnType := mod.NewTypeDef("pede_fn", types.NewStruct(types.I8Ptr, types.I8Ptr))
 	return &Codegen{
 		mod:        mod,
-		block:      entry,
-		vars:       make(map[string]*ir.InstAlloca),
+		info:       info,
+		file:       file,
+		listType:   listType,
+		mapType:    mapType,
+		fnType:     fnType,
+		vars:       make(map[*sema.Var]*ir.InstAlloca),
+		structs:    make(map[string]*types.StructType),
+		funcs:      make(map[*sema.Func]*ir.Func),
+		funcValues: make(map[*sema.Func]*ir.Func),
 		strGlobals: make(map[string]*ir.Global),
 	}
 }
@@ -44,22 +80,79 @@ func (cg *Codegen) WriteTo(w io.Writer) (n int64, err error) {
 	return n, nil
 }
 
+// errorf returns a compile error pointing at node in the file being generated.
+func (cg *Codegen) errorf(node any, format string, args ...any) error {
+	pos := ast.PosOf(node)
+	src := ""
+	if pos.Line >= 1 && pos.Line <= len(cg.lines) {
+		src = cg.lines[pos.Line-1]
+	}
+	span := diag.WordSpan(cg.file, diag.Pos{Line: pos.Line, Column: pos.Column}, src)
+	return diag.Errorf(diag.CodeCodegen, span, src, format, args...)
+}
+
+// at returns err pointing at node, unless it already points at source code.
+func (cg *Codegen) at(node any, err error) error {
+	var d *diag.Diagnostic
+	if err == nil || errors.As(err, &d) {
+		return err
+	}
+	return cg.errorf(node, "%v", err)
+}
+
 // GenStmt dispatches codegen for statements
-func (cg *Codegen) GenStmt(stmt ast.Stmt) {
+func (cg *Codegen) GenStmt(stmt ast.Stmt) error {
 	switch s := stmt.(type) {
 	case *ast.Assignment:
-		cg.GenAssign(s)
+		return cg.GenAssign(s)
+	case *ast.IndexAssign:
+		return cg.GenIndexAssign(s)
+	case *ast.FieldAssign:
+		return cg.GenFieldAssign(s)
+	case *ast.CompoundAssign:
+		return cg.GenCompoundAssign(s)
+	case *ast.TypeDecl, *ast.EnumDecl:
+		// Struct and enum types are emitted on first use by structType and enumType
+	case *ast.FuncDecl, *ast.ImportDecl:
+		// Functions are emitted up front by GenModule
+	case *ast.TestDecl, *ast.BenchDecl:
+		// Test and benchmark blocks are only emitted into their own binaries, by GenTests and GenBenches
+	case *ast.ConstDecl:
+		// Constants are folded into the expressions that use them
+	case *ast.ReturnStmt:
+		return cg.GenReturn(s)
+	case *ast.TryStmt:
+		return cg.GenTry(s)
+	case *ast.ExprStmt:
+		if m, ok := s.Expr.(*ast.Match); ok {
+			_, err := cg.genMatch(m, true)
+			return err
+		}
+		_, err := cg.genExpr(s.Expr)
+		return err
 	case *ast.PrintStmt:
-		cg.GenPrint(s)
+		return cg.GenPrint(s)
+	case *ast.Block:
+		return cg.GenBlock(s)
+	case *ast.IfStmt:
+		return cg.GenIf(s)
+	case *ast.WhileStmt:
+		return cg.GenWhile(s)
+	case *ast.ForStmt:
+		return cg.GenFor(s)
 	default:
-		panic("unsupported statement type")
+		return cg.errorf(stmt, "unsupported statement %T", stmt)
 	}
+	return nil
 }
 
 // GenPrint emits code for print(x)
-func (cg *Codegen) GenPrint(p *ast.PrintStmt) {
+func (cg *Codegen) GenPrint(p *ast.PrintStmt) error {
 	printf := cg.getOrDeclarePrintf()
-	val := cg.genExpr(p.Expr)
+	val, err := cg.genExpr(p.Expr)
+	if err != nil {
+		return err
+	}
 
 	switch val.Type().String() {
 	case types.Double.String():
@@ -69,7 +162,10 @@ func (cg *Codegen) GenPrint(p *ast.PrintStmt) {
 		arrayType := cg.fmtStrGlobal.Init.(*constant.CharArray).Typ
 		fmtPtr := cg.block.NewGetElementPtr(arrayType, cg.fmtStrGlobal, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
 		cg.block.NewCall(printf, fmtPtr, val)
-	case types.I8Ptr.String():
+	case types.I8Ptr.String(), types.I1.String():
+		if val.Type().Equal(types.I1) {
+			val = cg.block.NewSelect(val, cg.stringPtr("true"), cg.stringPtr("false"))
+		}
 		if cg.fmtStrSGlobal == nil {
 			cg.fmtStrSGlobal = cg.mod.NewGlobalDef(".fmtstr_s", constant.NewCharArrayFromString("%s\n\x00"))
 		}
@@ -77,60 +173,930 @@ func (cg *Codegen) GenPrint(p *ast.PrintStmt) {
 		fmtPtr := cg.block.NewGetElementPtr(arrayType, cg.fmtStrSGlobal, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
 		cg.block.NewCall(printf, fmtPtr, val)
 	default:
-		panic("unsupported print type: " + val.Type().String())
+		return cg.errorf(p.Expr, "cannot print a value of type %s", cg.info.TypeOf(p.Expr))
 	}
+	return nil
 }
 
-func (cg *Codegen) GenAssign(a *ast.Assignment) {
-	exprVal := cg.genExpr(a.Expr)
-	var alloca *ir.InstAlloca
-	switch exprVal.Type().String() {
-	case types.Double.String():
-		alloca = cg.block.NewAlloca(types.Double)
-		cg.block.NewStore(exprVal, alloca)
-	case types.I8Ptr.String():
-		alloca = cg.block.NewAlloca(types.I8Ptr)
-		cg.block.NewStore(exprVal, alloca)
+func (cg *Codegen) GenAssign(a *ast.Assignment) error {
+	val, err := cg.genExpr(a.Expr)
+	if err != nil {
+		return err
+	}
+	cg.assign(cg.info.Vars[a], val)
+	return nil
+}
+
+// assign stores val into the variable v, allocating a slot for it on first use
+func (cg *Codegen) assign(v *sema.Var, val value.Value) {
+	// sema keeps the type of a variable fixed, so reassignments update the existing slot
+	if alloca, ok := cg.vars[v]; ok {
+		cg.block.NewStore(val, alloca)
+		return
+	}
+	alloca := cg.newAlloca(val.Type())
+	cg.block.NewStore(val, alloca)
+	cg.vars[v] = alloca
+}
+
+// GenIndexAssign emits a bounds-checked store into a list element, or an insertion into a map. As
+// for GenCompoundAssign, the value is computed before the element is located.
+func (cg *Codegen) GenIndexAssign(a *ast.IndexAssign) error {
+	val, err := cg.genExpr(a.Expr)
+	if err != nil {
+		return err
+	}
+	ptr, err := cg.genElemPtr(a.Target, true)
+	if err != nil {
+		return err
+	}
+	cg.block.NewStore(val, ptr)
+	return nil
+}
+
+// GenCompoundAssign emits target op= expr. The value is computed before the target is located, so
+// that a value which grows the list or map being updated cannot leave a stale element pointer.
+func (cg *Codegen) GenCompoundAssign(a *ast.CompoundAssign) error {
+	val, err := cg.genExpr(a.Expr)
+	if err != nil {
+		return err
+	}
+	var ptr value.Value
+	switch t := a.Target.(type) {
+	case *ast.Variable:
+		ptr = cg.vars[cg.info.Vars[t]]
+	case *ast.Index:
+		ptr, err = cg.genElemPtr(t, false)
+	case *ast.Selector:
+		ptr, err = cg.genFieldPtr(t)
 	default:
-		panic("unsupported assignment type: " + exprVal.Type().String())
+		return cg.errorf(a, "cannot assign to %T", a.Target)
+	}
+	if err != nil {
+		return err
+	}
+	old := cg.block.NewLoad(types.Double, ptr)
+	result, err := cg.genArith(a.Op, old, val, a)
+	if err != nil {
+		return err
+	}
+	cg.block.NewStore(result, ptr)
+	return nil
+}
+
+// GenBlock emits code for every statement of a block
+func (cg *Codegen) GenBlock(b *ast.Block) error {
+	for _, stmt := range b.Stmts {
+		if err := cg.GenStmt(stmt); err != nil {
+			return err
+		}
 	}
-	cg.vars[a.Name] = alloca
+	return nil
 }
 
-func (cg *Codegen) genExpr(e ast.Expr) value.Value {
+// GenIf emits a conditional branch to the then and else blocks
+func (cg *Codegen) GenIf(s *ast.IfStmt) error {
+	cond, err := cg.genExpr(s.Cond)
+	if err != nil {
+		return err
+	}
+	thenBlock := cg.newBlock("if.then")
+	endBlock := cg.newBlock("if.end")
+	elseBlock := endBlock
+	if s.Else != nil {
+		elseBlock = cg.newBlock("if.else")
+	}
+	cg.block.NewCondBr(cond, thenBlock, elseBlock)
+
+	cg.block = thenBlock
+	if err := cg.GenBlock(s.Then); err != nil {
+		return err
+	}
+	cg.branchTo(endBlock)
+
+	if s.Else != nil {
+		cg.block = elseBlock
+		if err := cg.GenStmt(s.Else); err != nil {
+			return err
+		}
+		cg.branchTo(endBlock)
+	}
+	cg.block = endBlock
+	return nil
+}
+
+// GenWhile emits a loop that re-evaluates its condition before every iteration
+func (cg *Codegen) GenWhile(s *ast.WhileStmt) error {
+	condBlock := cg.newBlock("while.cond")
+	bodyBlock := cg.newBlock("while.body")
+	endBlock := cg.newBlock("while.end")
+	cg.branchTo(condBlock)
+
+	cg.block = condBlock
+	cond, err := cg.genExpr(s.Cond)
+	if err != nil {
+		return err
+	}
+	cg.block.NewCondBr(cond, bodyBlock, endBlock)
+
+	cg.block = bodyBlock
+	if err := cg.GenBlock(s.Body); err != nil {
+		return err
+	}
+	cg.branchTo(condBlock)
+
+	cg.block = endBlock
+	return nil
+}
+
+// GenFieldAssign emits a store into a struct field
+func (cg *Codegen) GenFieldAssign(a *ast.FieldAssign) error {
+	ptr, err := cg.genFieldPtr(a.Target)
+	if err != nil {
+		return err
+	}
+	val, err := cg.genExpr(a.Expr)
+	if err != nil {
+		return err
+	}
+	cg.block.NewStore(val, ptr)
+	return nil
+}
+
+// GenFor emits a loop over the elements of a list or the keys of a map
+func (cg *Codegen) GenFor(s *ast.ForStmt) error {
+	list, err := cg.genExpr(s.Iter)
+	if err != nil {
+		return err
+	}
+	var elemType types.Type
+	switch t := cg.info.TypeOf(s.Iter).(type) {
+	case *sema.List:
+		elemType, err = cg.llvmType(t.Elem)
+	case *sema.Map:
+		// Iterate over a snapshot of the keys, so the loop body may modify the map
+		elemType, err = cg.llvmType(t.Key)
+		list = cg.block.NewCall(cg.runtimeFunc("pede_map_keys", cg.listType, cg.mapType), list)
+	default:
+		return cg.errorf(s.Iter, "cannot iterate over a value of type %s", t)
+	}
+	if err != nil {
+		return cg.at(s.Iter, err)
+	}
+	return cg.genLoop(list, elemType, s, func(elem value.Value) error {
+		cg.assign(cg.info.Vars[s], elem)
+		return cg.GenBlock(s.Body)
+	})
+}
+
+// genLoop emits a loop calling body with every element of list. The length is read once before the
+// loop, so elements appended by body are not visited, just as maps iterate over a snapshot of their
+// keys. Out of bounds accesses are reported at the position of node.
+func (cg *Codegen) genLoop(list value.Value, elemType types.Type, node any, body func(elem value.Value) error) error {
+	idx := cg.newAlloca(types.Double)
+	cg.block.NewStore(constant.NewFloat(types.Double, 0), idx)
+	n := cg.block.NewCall(cg.runtimeFunc("pede_list_len", types.Double, cg.listType), list)
+
+	condBlock := cg.newBlock("for.cond")
+	bodyBlock := cg.newBlock("for.body")
+	endBlock := cg.newBlock("for.end")
+	cg.branchTo(condBlock)
+
+	cg.block = condBlock
+	i := cg.block.NewLoad(types.Double, idx)
+	cg.block.NewCondBr(cg.block.NewFCmp(enum.FPredOLT, i, n), bodyBlock, endBlock)
+
+	cg.block = bodyBlock
+	ptr := cg.listElemPtr(list, i, elemType, node)
+	if err := body(cg.block.NewLoad(elemType, ptr)); err != nil {
+		return err
+	}
+	if cg.block.Term == nil {
+		next := cg.block.NewFAdd(cg.block.NewLoad(types.Double, idx), constant.NewFloat(types.Double, 1))
+		cg.block.NewStore(next, idx)
+	}
+	cg.branchTo(condBlock)
+
+	cg.block = endBlock
+	return nil
+}
+
+func (cg *Codegen) genExpr(e ast.Expr) (value.Value, error) {
+	if v, ok := cg.info.Values[e]; ok {
+		return cg.constValue(v, e)
+	}
 	switch n := e.(type) {
 	case *ast.Number:
-		return constant.NewFloat(types.Double, n.Value)
+		return constant.NewFloat(types.Double, n.Value), nil
 	case *ast.String:
-		if g, ok := cg.strGlobals[n.Value]; ok {
-			// Reuse global if already created
-			arrayType := g.Init.(*constant.CharArray).Typ
-			return cg.block.NewGetElementPtr(arrayType, g, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
-		}
-		g := cg.mod.NewGlobalDef(".str."+fmt.Sprintf("%x", len(cg.strGlobals)), constant.NewCharArrayFromString(n.Value+"\x00"))
-		cg.strGlobals[n.Value] = g
-		arrayType := g.Init.(*constant.CharArray).Typ
-		return cg.block.NewGetElementPtr(arrayType, g, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+		return cg.stringPtr(n.Value), nil
+	case *ast.Bool:
+		return constant.NewBool(n.Value), nil
 	case *ast.Variable:
-		ptr := cg.vars[n.Name]
-		return cg.block.NewLoad(ptr.ElemType, ptr)
+		if f, ok := cg.info.FuncValues[n]; ok {
+			return cg.funcValue(f), nil
+		}
+		ptr := cg.vars[cg.info.Vars[n]]
+		return cg.block.NewLoad(ptr.ElemType, ptr), nil
+	case *ast.Unary:
+		operand, err := cg.genExpr(n.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case lexer.TokenMinus:
+			return cg.block.NewFNeg(operand), nil
+		case lexer.TokenBang:
+			return cg.block.NewXor(operand, constant.True), nil
+		default:
+			return nil, cg.errorf(n, "unsupported operator %s", n.Op)
+		}
 	case *ast.Binary:
-		lhs := cg.genExpr(n.Left)
-		rhs := cg.genExpr(n.Right)
+		if n.Op == lexer.TokenAnd || n.Op == lexer.TokenOr {
+			return cg.genLogical(n)
+		}
+		lhs, err := cg.genExpr(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := cg.genExpr(n.Right)
+		if err != nil {
+			return nil, err
+		}
 		switch n.Op {
-		case lexer.TokenPlus:
-			if lhs.Type().String() == types.Double.String() && rhs.Type().String() == types.Double.String() {
-				return cg.block.NewFAdd(lhs, rhs)
-			}
-			// Optionally, support string concatenation here
-			panic("string concatenation not supported yet")
-		case lexer.TokenStar:
-			return cg.block.NewFMul(lhs, rhs)
+		case lexer.TokenEqEq, lexer.TokenNotEq, lexer.TokenLess, lexer.TokenLessEq, lexer.TokenGreater, lexer.TokenGreaterEq:
+			return cg.genCompare(n.Op, lhs, rhs, n)
 		default:
-			panic("unsupported operator: " + n.Op)
+			return cg.genArith(n.Op, lhs, rhs, n)
+		}
+	case *ast.ListLit:
+		return cg.genListLit(n)
+	case *ast.MapLit:
+		return cg.genMapLit(n)
+	case *ast.Index:
+		ptr, err := cg.genElemPtr(n, false)
+		if err != nil {
+			return nil, err
+		}
+		t, err := cg.typeOf(n)
+		if err != nil {
+			return nil, err
+		}
+		return cg.block.NewLoad(t, ptr), nil
+	case *ast.Call:
+		return cg.genCall(n)
+	case *ast.StructLit:
+		return cg.genStructLit(n)
+	case *ast.Match:
+		return cg.genMatch(n, false)
+	case *ast.FuncLit:
+		return cg.genFuncLit(n)
+	case *ast.Propagate:
+		return cg.genPropagate(n)
+	case *ast.Selector:
+		if v, ok := cg.info.Variants[n]; ok {
+			return cg.genConstructor(v, nil, n)
+		}
+		if f, ok := cg.info.FuncValues[n]; ok {
+			return cg.funcValue(f), nil
+		}
+		ptr, err := cg.genFieldPtr(n)
+		if err != nil {
+			return nil, err
+		}
+		t, err := cg.typeOf(n)
+		if err != nil {
+			return nil, err
+		}
+		return cg.block.NewLoad(t, ptr), nil
+	default:
+		return nil, cg.errorf(e, "unsupported expression %T", e)
+	}
+}
+
+// constValue returns the LLVM constant for a value computed by the checker's constant folding of
+// the expression e. Strings live in globals shared by all uses of the same string.
+func (cg *Codegen) constValue(v any, e ast.Expr) (value.Value, error) {
+	switch v := v.(type) {
+	case float64:
+		return constant.NewFloat(types.Double, v), nil
+	case bool:
+		return constant.NewBool(v), nil
+	case string:
+		return cg.stringPtr(v), nil
+	}
+	return nil, cg.errorf(e, "unsupported constant %v", v)
+}
+
+// typeOf returns the LLVM representation of the type of the expression e
+func (cg *Codegen) typeOf(e ast.Expr) (types.Type, error) {
+	t, err := cg.llvmType(cg.info.TypeOf(e))
+	return t, cg.at(e, err)
+}
+
+// llvmType returns the LLVM representation of a pede type
+func (cg *Codegen) llvmType(t sema.Type) (types.Type, error) {
+	switch t := t.(type) {
+	case *sema.List:
+		return cg.listType, nil
+	case *sema.Map:
+		return cg.mapType, nil
+	case *sema.Struct:
+		st, err := cg.structType(t)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewPointer(st), nil
+	case *sema.Enum:
+		return types.NewPointer(cg.enumType(t)), nil
+	case *sema.Signature:
+		return cg.fnType, nil
+	case *sema.Result:
+		value, err := cg.llvmType(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewStruct(types.I8Ptr, value), nil
+	case *sema.Basic:
+		switch t {
+		case sema.Float:
+			return types.Double, nil
+		case sema.String:
+			return types.I8Ptr, nil
+		case sema.Bool:
+			return types.I1, nil
+		case sema.Ptr:
+			return types.I8Ptr, nil
+		}
+	}
+	return nil, fmt.Errorf("type %s has no LLVM representation", t)
+}
+
+// cType returns the LLVM type of a value of type t, written as te in the signature of an extern
+// function. The C integer types are passed as such and converted from and to float at the call.
+func (cg *Codegen) cType(te ast.TypeExpr, t sema.Type) (types.Type, error) {
+	if named, ok := te.(*ast.NamedType); ok && named.Module == "" {
+		switch named.Name {
+		case "int":
+			return types.I32, nil
+		case "long":
+			return types.I64, nil
+		}
+	}
+	return cg.llvmType(t)
+}
+
+// declareExtern declares the C function behind an extern declaration. Modules declaring the same
+// C function share one declaration.
+func (cg *Codegen) declareExtern(f *sema.Func) (*ir.Func, error) {
+	for _, fn := range cg.mod.Funcs {
+		if fn.Name() == f.Name {
+			return fn, nil
+		}
+	}
+	params := make([]*ir.Param, len(f.Params))
+	for i, p := range f.Params {
+		t, err := cg.cType(f.Decl.Params[i].Type, p.Type)
+		if err != nil {
+			return nil, cg.at(f.Decl, err)
+		}
+		params[i] = ir.NewParam(p.Name, t)
+	}
+	var result types.Type = types.Void
+	if f.Result != nil {
+		t, err := cg.cType(f.Decl.Result, f.Result)
+		if err != nil {
+			return nil, cg.at(f.Decl, err)
+		}
+		result = t
+	}
+	fn := cg.mod.NewFunc(f.Name, result, params...)
+	zeroExtBools(fn)
+	return fn, nil
+}
+
+// zeroExtBools marks the bool parameters and result of a function called from or calling C as
+// zero-extended, which is how C passes _Bool and how clang declares it.
+func zeroExtBools(fn *ir.Func) {
+	for _, p := range fn.Params {
+		if p.Typ.Equal(types.I1) {
+			p.Attrs = append(p.Attrs, enum.ParamAttrZeroExt)
+		}
+	}
+	if fn.Sig.RetType.Equal(types.I1) {
+		fn.ReturnAttrs = append(fn.ReturnAttrs, enum.ReturnAttrZeroExt)
+	}
+}
+
+// genExternCall emits a call n of a C function, converting floats to and from the C integer types.
+// Floats out of the range of the integer type panic.
+func (cg *Codegen) genExternCall(fn *ir.Func, n *ast.Call) (value.Value, error) {
+	vals := make([]value.Value, len(n.Args))
+	for i, arg := range n.Args {
+		val, err := cg.genExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+		if it, ok := fn.Params[i].Typ.(*types.IntType); ok && it.BitSize > 1 {
+			vals[i] = cg.genFloatToInt(vals[i], it, arg)
+		}
+	}
+	call := cg.block.NewCall(fn, vals...)
+	if it, ok := fn.Sig.RetType.(*types.IntType); ok && it.BitSize > 1 {
+		return cg.block.NewSIToFP(call, types.Double), nil
+	}
+	return call, nil
+}
+
+// structType returns the LLVM struct type of a declared struct, defining it on first use.
+// Struct values are heap allocated and handled through pointers to this type.
+func (cg *Codegen) structType(t *sema.Struct) (*types.StructType, error) {
+	return cg.namedStruct("struct."+qualify(t.Module, t.Name), nil, t.Fields)
+}
+
+// enumType returns the LLVM type shared by all values of an enum: a header holding the i32 tag.
+// Values are heap allocated as one of the variant types and handled through pointers to the header.
+func (cg *Codegen) enumType(t *sema.Enum) *types.StructType {
+	// The header has no fields to resolve, so it cannot fail
+	st, _ := cg.namedStruct("enum."+qualify(t.Module, t.Name), []types.Type{types.I32}, nil)
+	return st
+}
+
+// variantType returns the LLVM type of an enum variant: the tag followed by the payload fields.
+func (cg *Codegen) variantType(v *sema.Variant) (*types.StructType, error) {
+	return cg.namedStruct("enum."+qualify(v.Enum.Module, v.Enum.Name)+"."+v.Name, []types.Type{types.I32}, v.Fields)
+}
+
+// qualify prefixes the name of a symbol declared outside of the main module with its module path,
+// so that equally named declarations of different modules do not clash.
+func qualify(module, name string) string {
+	if module == "main" {
+		return name
+	}
+	return module + "." + name
+}
+
+// namedStruct returns the named LLVM struct type with the given leading fields followed by fields,
+// defining it on first use.
+func (cg *Codegen) namedStruct(name string, leading []types.Type, fields []*sema.Field) (*types.StructType, error) {
+	if st, ok := cg.structs[name]; ok {
+		return st, nil
+	}
+	st := &types.StructType{Fields: leading}
+	// Register before resolving the fields, which may refer back to the type
+	cg.structs[name] = st
+	cg.mod.NewTypeDef(name, st)
+	for _, f := range fields {
+		t, err := cg.llvmType(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %s of %s: %w", f.Name, name, err)
+		}
+		st.Fields = append(st.Fields, t)
+	}
+	return st, nil
+}
+
+// genConstructor allocates an enum value of variant v holding args, constructed at node
+func (cg *Codegen) genConstructor(v *sema.Variant, args []ast.Expr, node any) (value.Value, error) {
+	vt, err := cg.variantType(v)
+	if err != nil {
+		return nil, cg.at(node, err)
+	}
+	newFn := cg.runtimeFunc("pede_new", types.I8Ptr, types.I64)
+	ptr := cg.block.NewBitCast(cg.block.NewCall(newFn, sizeOf(vt)), types.NewPointer(vt))
+	tag := cg.block.NewGetElementPtr(vt, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+	cg.block.NewStore(constant.NewInt(types.I32, int64(v.Tag)), tag)
+	for i, arg := range args {
+		val, err := cg.genExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		field := cg.block.NewGetElementPtr(vt, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i+1)))
+		cg.block.NewStore(val, field)
+	}
+	return cg.block.NewBitCast(ptr, types.NewPointer(cg.enumType(v.Enum))), nil
+}
+
+// genMatch emits a switch on the tag of the subject with one block per arm. A match used as a
+// statement discards the arm values and returns nil.
+func (cg *Codegen) genMatch(m *ast.Match, asStmt bool) (value.Value, error) {
+	enum := cg.info.TypeOf(m.Subject).(*sema.Enum)
+	et := cg.enumType(enum)
+	subject, err := cg.genExpr(m.Subject)
+	if err != nil {
+		return nil, err
+	}
+	tagPtr := cg.block.NewGetElementPtr(et, subject, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+	tag := cg.block.NewLoad(types.I32, tagPtr)
+
+	var result *ir.InstAlloca
+	if !asStmt {
+		t, err := cg.typeOf(m)
+		if err != nil {
+			return nil, err
+		}
+		result = cg.newAlloca(t)
+	}
+	endBlock := cg.newBlock("match.end")
+	var defaultBlock *ir.Block
+	var cases []*ir.Case
+	switchBlock := cg.block
+	for _, arm := range m.Arms {
+		armBlock := cg.newBlock("match.arm")
+		if arm.Variant == "_" {
+			defaultBlock = armBlock
+		} else {
+			v := enum.Variant(arm.Variant)
+			cases = append(cases, ir.NewCase(constant.NewInt(types.I32, int64(v.Tag)), armBlock))
+			cg.block = armBlock
+			vt, err := cg.variantType(v)
+			if err != nil {
+				return nil, cg.at(arm, err)
+			}
+			payload := cg.block.NewBitCast(subject, types.NewPointer(vt))
+			for i, bound := range cg.info.Bindings[arm] {
+				if bound == nil {
+					continue
+				}
+				field := cg.block.NewGetElementPtr(vt, payload, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(i+1)))
+				cg.assign(bound, cg.block.NewLoad(vt.Fields[i+1], field))
+			}
+		}
+		cg.block = armBlock
+		if arm.Block != nil {
+			if err := cg.GenBlock(arm.Block); err != nil {
+				return nil, err
+			}
+		} else {
+			val, err := cg.genExpr(arm.Expr)
+			if err != nil {
+				return nil, err
+			}
+			if result != nil {
+				cg.block.NewStore(val, result)
+			}
 		}
+		cg.branchTo(endBlock)
+	}
+	if defaultBlock == nil {
+		// Exhaustiveness is checked by sema, so no other tag can reach the switch
+		defaultBlock = cg.newBlock("match.unreachable")
+		defaultBlock.NewUnreachable()
+	}
+	switchBlock.NewSwitch(tag, defaultBlock, cases...)
+
+	cg.block = endBlock
+	if result == nil {
+		return nil, nil
+	}
+	return cg.block.NewLoad(result.ElemType, result), nil
+}
+
+// genStructLit allocates a struct and initializes its fields
+func (cg *Codegen) genStructLit(n *ast.StructLit) (value.Value, error) {
+	t := cg.info.TypeOf(n).(*sema.Struct)
+	st, err := cg.structType(t)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	newFn := cg.runtimeFunc("pede_new", types.I8Ptr, types.I64)
+	ptr := cg.block.NewBitCast(cg.block.NewCall(newFn, sizeOf(st)), types.NewPointer(st))
+	for _, init := range n.Fields {
+		_, idx := t.Field(init.Name)
+		val, err := cg.genExpr(init.Value)
+		if err != nil {
+			return nil, err
+		}
+		field := cg.block.NewGetElementPtr(st, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(idx)))
+		cg.block.NewStore(val, field)
+	}
+	return ptr, nil
+}
+
+// genFieldPtr emits a pointer to the struct field addressed by n
+func (cg *Codegen) genFieldPtr(n *ast.Selector) (value.Value, error) {
+	t := cg.info.TypeOf(n.X).(*sema.Struct)
+	_, idx := t.Field(n.Name)
+	ptr, err := cg.genExpr(n.X)
+	if err != nil {
+		return nil, err
+	}
+	st, err := cg.structType(t)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	return cg.block.NewGetElementPtr(st, ptr, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, int64(idx))), nil
+}
+
+// sizeOf returns the store size of t as an i64 constant expression
+func sizeOf(t types.Type) constant.Constant {
+	end := constant.NewGetElementPtr(t, constant.NewNull(types.NewPointer(t)), constant.NewInt(types.I32, 1))
+	return constant.NewPtrToInt(end, types.I64)
+}
+
+// genListLit emits a new runtime list and appends every element to it
+func (cg *Codegen) genListLit(n *ast.ListLit) (value.Value, error) {
+	elemType, err := cg.llvmType(cg.info.TypeOf(n).(*sema.List).Elem)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	newList := cg.runtimeFunc("pede_list_new", cg.listType, types.I64, types.I64)
+	list := cg.block.NewCall(newList, sizeOf(elemType), constant.NewInt(types.I64, int64(len(n.Elems))))
+	for _, elem := range n.Elems {
+		val, err := cg.genExpr(elem)
+		if err != nil {
+			return nil, err
+		}
+		cg.genAppend(list, val)
+	}
+	return list, nil
+}
+
+// genAppend emits a copy of val onto the end of list
+func (cg *Codegen) genAppend(list, val value.Value) value.Value {
+	tmp := cg.newAlloca(val.Type())
+	cg.block.NewStore(val, tmp)
+	appendFn := cg.runtimeFunc("pede_list_append", cg.listType, cg.listType, types.I8Ptr)
+	return cg.block.NewCall(appendFn, list, cg.block.NewBitCast(tmp, types.I8Ptr))
+}
+
+// Key kinds understood by pede_map_new
+const (
+	mapKeyNumber = 0
+	mapKeyString = 1
+)
+
+// genMapLit emits a new runtime map and inserts every entry into it
+func (cg *Codegen) genMapLit(n *ast.MapLit) (value.Value, error) {
+	t := cg.info.TypeOf(n).(*sema.Map)
+	kind := mapKeyNumber
+	if t.Key == sema.String {
+		kind = mapKeyString
+	}
+	valType, err := cg.llvmType(t.Value)
+	if err != nil {
+		return nil, cg.at(n, err)
+	}
+	newMap := cg.runtimeFunc("pede_map_new", cg.mapType, types.I64, types.I64)
+	m := cg.block.NewCall(newMap, constant.NewInt(types.I64, int64(kind)), sizeOf(valType))
+	for _, entry := range n.Entries {
+		key, err := cg.genExpr(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		ptr := cg.mapSlot(m, key, valType)
+		val, err := cg.genExpr(entry.Value)
+		if err != nil {
+			return nil, err
+		}
+		cg.block.NewStore(val, ptr)
+	}
+	return m, nil
+}
+
+// keyPtr spills a map key to the stack and returns an i8* to it, as the runtime expects
+func (cg *Codegen) keyPtr(key value.Value) value.Value {
+	tmp := cg.newAlloca(key.Type())
+	cg.block.NewStore(key, tmp)
+	return cg.block.NewBitCast(tmp, types.I8Ptr)
+}
+
+// mapSlot returns a pointer to the value stored under key, inserting it if missing
+func (cg *Codegen) mapSlot(m, key value.Value, valType types.Type) value.Value {
+	slot := cg.runtimeFunc("pede_map_slot", types.I8Ptr, cg.mapType, types.I8Ptr)
+	ptr := cg.block.NewCall(slot, m, cg.keyPtr(key))
+	return cg.block.NewBitCast(ptr, types.NewPointer(valType))
+}
+
+// genElemPtr emits a pointer to the list element or map value addressed by n.
+// List accesses are bounds-checked; map reads abort on missing keys while writes insert them.
+func (cg *Codegen) genElemPtr(n *ast.Index, write bool) (value.Value, error) {
+	x, err := cg.genExpr(n.X)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := cg.genExpr(n.Index)
+	if err != nil {
+		return nil, err
+	}
+	elemType, err := cg.typeOf(n)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cg.info.TypeOf(n.X).(*sema.Map); !ok {
+		return cg.listElemPtr(x, idx, elemType, n), nil
+	}
+	if write {
+		return cg.mapSlot(x, idx, elemType), nil
+	}
+	get := cg.runtimeFunc("pede_map_get", types.I8Ptr, cg.mapType, types.I8Ptr, types.I8Ptr, types.I64, types.I64)
+	ptr := cg.block.NewCall(get, append([]value.Value{x, cg.keyPtr(idx)}, cg.srcPos(n)...)...)
+	return cg.block.NewBitCast(ptr, types.NewPointer(elemType)), nil
+}
+
+// listElemPtr calls the runtime bounds check, reporting the position of node on failure
+func (cg *Codegen) listElemPtr(list, idx value.Value, elemType types.Type, node any) value.Value {
+	at := cg.runtimeFunc("pede_list_at", types.I8Ptr, cg.listType, types.Double, types.I8Ptr, types.I64, types.I64)
+	ptr := cg.block.NewCall(at, append([]value.Value{list, idx}, cg.srcPos(node)...)...)
+	return cg.block.NewBitCast(ptr, types.NewPointer(elemType))
+}
+
+// genArgs emits the arguments of a call, in order
+func (cg *Codegen) genArgs(args []ast.Expr) ([]value.Value, error) {
+	vals := make([]value.Value, len(args))
+	for i, arg := range args {
+		val, err := cg.genExpr(arg)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// genCall emits a call of a built-in or declared function, or of an enum variant constructor
+func (cg *Codegen) genCall(n *ast.Call) (value.Value, error) {
+	if v, ok := cg.info.Variants[n]; ok {
+		return cg.genConstructor(v, n.Args, n)
+	}
+	if f, ok := cg.info.Calls[n]; ok {
+		if f.Decl.Extern {
+			return cg.genExternCall(cg.funcs[f], n)
+		}
+		args, err := cg.genArgs(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		cg.markLine(n)
+		return cg.block.NewCall(cg.funcs[f], args...), nil
+	}
+	if sig, ok := cg.info.TypeOf(n.Func).(*sema.Signature); ok {
+		callee, err := cg.genExpr(n.Func)
+		if err != nil {
+			return nil, err
+		}
+		args, err := cg.genArgs(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		return cg.callFuncValue(callee, sig, n, args...)
+	}
+	name := n.Func.(*ast.Variable).Name
+	switch name {
+	case "map", "filter", "reduce":
+		return cg.genHigherOrder(n)
+	case "exit":
+		return nil, cg.genExit(n)
+	}
+	args, err := cg.genArgs(n.Args)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "len":
+		if _, ok := cg.info.TypeOf(n.Args[0]).(*sema.Map); ok {
+			lenFn := cg.runtimeFunc("pede_map_len", types.Double, cg.mapType)
+			return cg.block.NewCall(lenFn, args[0]), nil
+		}
+		lenFn := cg.runtimeFunc("pede_list_len", types.Double, cg.listType)
+		return cg.block.NewCall(lenFn, args[0]), nil
+	case "has":
+		hasFn := cg.runtimeFunc("pede_map_has", types.I32, cg.mapType, types.I8Ptr)
+		found := cg.block.NewCall(hasFn, args[0], cg.keyPtr(args[1]))
+		return cg.block.NewICmp(enum.IPredNE, found, constant.NewInt(types.I32, 0)), nil
+	case "delete":
+		deleteFn := cg.runtimeFunc("pede_map_delete", cg.mapType, cg.mapType, types.I8Ptr)
+		return cg.block.NewCall(deleteFn, args[0], cg.keyPtr(args[1])), nil
+	case "keys":
+		keysFn := cg.runtimeFunc("pede_map_keys", cg.listType, cg.mapType)
+		return cg.block.NewCall(keysFn, args[0]), nil
+	case "append":
+		return cg.genAppend(args[0], args[1]), nil
+	case "assert":
+		cg.genAssert(n, args)
+		return nil, nil
+	case "assert_eq":
+		return nil, cg.genAssertEq(n, args)
+	case "error":
+		t, err := cg.typeOf(n)
+		if err != nil {
+			return nil, err
+		}
+		return cg.genError(t, args[0]), nil
 	default:
-		panic("unknown expression node")
+		return nil, cg.errorf(n, "unsupported function %s", name)
+	}
+}
+
+var (
+	floatPredicates = map[string]enum.FPred{
+		lexer.TokenEqEq:      enum.FPredOEQ,
+		lexer.TokenNotEq:     enum.FPredUNE,
+		lexer.TokenLess:      enum.FPredOLT,
+		lexer.TokenLessEq:    enum.FPredOLE,
+		lexer.TokenGreater:   enum.FPredOGT,
+		lexer.TokenGreaterEq: enum.FPredOGE,
+	}
+	intPredicates = map[string]enum.IPred{
+		lexer.TokenEqEq:  enum.IPredEQ,
+		lexer.TokenNotEq: enum.IPredNE,
+	}
+)
+
+// genArith emits an arithmetic operation on two floats. Division by zero panics at node.
+func (cg *Codegen) genArith(op string, lhs, rhs value.Value, node any) (value.Value, error) {
+	switch op {
+	case lexer.TokenPlus:
+		return cg.block.NewFAdd(lhs, rhs), nil
+	case lexer.TokenMinus:
+		return cg.block.NewFSub(lhs, rhs), nil
+	case lexer.TokenStar:
+		return cg.block.NewFMul(lhs, rhs), nil
+	case lexer.TokenSlash:
+		if _, ok := rhs.(constant.Constant); !ok {
+			cg.genPanicIf(cg.block.NewFCmp(enum.FPredOEQ, rhs, constant.NewFloat(types.Double, 0)), "division by zero", node)
+		}
+		return cg.block.NewFDiv(lhs, rhs), nil
+	}
+	return nil, cg.errorf(node, "unsupported operator %s", op)
+}
+
+// genCompare emits a comparison n of two values of the same type, yielding an i1
+func (cg *Codegen) genCompare(op string, lhs, rhs value.Value, n *ast.Binary) (value.Value, error) {
+	switch lhs.Type().String() {
+	case types.Double.String():
+		return cg.block.NewFCmp(floatPredicates[op], lhs, rhs), nil
+	case types.I1.String():
+		if pred, ok := intPredicates[op]; ok {
+			return cg.block.NewICmp(pred, lhs, rhs), nil
+		}
+	case types.I8Ptr.String():
+		if pred, ok := intPredicates[op]; ok {
+			cmp := cg.block.NewCall(cg.getOrDeclareStrcmp(), lhs, rhs)
+			return cg.block.NewICmp(pred, cmp, constant.NewInt(types.I32, 0)), nil
+		}
+	}
+	return nil, cg.errorf(n, "cannot compare values of type %s with %s", cg.info.TypeOf(n.Left), op)
+}
+
+// genLogical emits short-circuit evaluation of && and ||
+func (cg *Codegen) genLogical(n *ast.Binary) (value.Value, error) {
+	lhs, err := cg.genExpr(n.Left)
+	if err != nil {
+		return nil, err
+	}
+	lhsBlock := cg.block
+	rhsBlock := cg.newBlock("logic.rhs")
+	endBlock := cg.newBlock("logic.end")
+	shortCircuit := constant.False
+	if n.Op == lexer.TokenAnd {
+		cg.block.NewCondBr(lhs, rhsBlock, endBlock)
+	} else {
+		shortCircuit = constant.True
+		cg.block.NewCondBr(lhs, endBlock, rhsBlock)
+	}
+
+	cg.block = rhsBlock
+	rhs, err := cg.genExpr(n.Right)
+	if err != nil {
+		return nil, err
+	}
+	rhsEnd := cg.block
+	cg.block.NewBr(endBlock)
+
+	cg.block = endBlock
+	return cg.block.NewPhi(ir.NewIncoming(shortCircuit, lhsBlock), ir.NewIncoming(rhs, rhsEnd)), nil
+}
+
+// stringPtr returns an i8* to a global holding s, creating the global on first use
+func (cg *Codegen) stringPtr(s string) value.Value {
+	g, ok := cg.strGlobals[s]
+	if !ok {
+		g = cg.mod.NewGlobalDef(".str."+fmt.Sprintf("%x", len(cg.strGlobals)), constant.NewCharArrayFromString(s+"\x00"))
+		cg.strGlobals[s] = g
+	}
+	arrayType := g.Init.(*constant.CharArray).Typ
+	return cg.block.NewGetElementPtr(arrayType, g, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, 0))
+}
+
+// newAlloca allocates a stack slot in the entry block so that it dominates every use
+func (cg *Codegen) newAlloca(t types.Type) *ir.InstAlloca {
+	alloca := ir.NewAlloca(t)
+	cg.entry.Insts = append([]ir.Instruction{alloca}, cg.entry.Insts...)
+	return alloca
+}
+
+// newBlock appends a uniquely named basic block to the current function
+func (cg *Codegen) newBlock(name string) *ir.Block {
+	cg.blockCount++
+	return cg.fn.NewBlock(fmt.Sprintf("%s.%d", name, cg.blockCount))
+}
+
+// branchTo terminates the current block with a jump to target unless it is already terminated
+func (cg *Codegen) branchTo(target *ir.Block) {
+	if cg.block.Term == nil {
+		cg.block.NewBr(target)
 	}
 }
 
@@ -146,13 +1112,195 @@ func (cg *Codegen) getOrDeclarePrintf() *ir.Func {
 	return printf
 }
 
+// getOrDeclareStrcmp returns the libc strcmp declaration used for string equality
+func (cg *Codegen) getOrDeclareStrcmp() *ir.Func {
+	for _, fn := range cg.mod.Funcs {
+		if fn.Name() == "strcmp" {
+			return fn
+		}
+	}
+	return cg.mod.NewFunc("strcmp", types.I32, ir.NewParam("", types.I8Ptr), ir.NewParam("", types.I8Ptr))
+}
+
+// runtimeFunc returns the declaration of a function of the pede runtime, declaring it on first use
+func (cg *Codegen) runtimeFunc(name string, ret types.Type, params ...types.Type) *ir.Func {
+	for _, fn := range cg.mod.Funcs {
+		if fn.Name() == name {
+			return fn
+		}
+	}
+	irParams := make([]*ir.Param, len(params))
+	for i, t := range params {
+		irParams[i] = ir.NewParam("", t)
+	}
+	return cg.mod.NewFunc(name, ret, irParams...)
+}
+
+// Finish ends main, which exits with status 0 when the top-level statements complete.
 func (cg *Codegen) Finish() {
-	cg.block.NewRet(nil)
+	if cg.block != nil && cg.block.Term == nil {
+		cg.genRet(constant.NewInt(types.I32, 0))
+	}
+}
+
+// GenModule emits every declared function of the root module and of the modules it imports,
+// followed by the top-level statements of the root module as the body of main.
+func (cg *Codegen) GenModule(root *ast.Module) error {
+	if err := cg.genFuncs(root); err != nil {
+		return err
+	}
+	cg.fn = cg.mod.NewFunc("main", types.I32)
+	cg.entry = cg.fn.NewBlock("entry")
+	cg.block = cg.entry
+	cg.enterFrame(cg.fn.Name())
+	return cg.GenProgram(root.Program)
+}
+
+// GenLibrary emits every declared function of the root module and of the modules it imports,
+// without a main function. Exported functions are the entry points of the library.
+func (cg *Codegen) GenLibrary(root *ast.Module) error {
+	return cg.genFuncs(root)
+}
+
+// genFuncs declares every function, then emits the bodies of those written in pede.
+func (cg *Codegen) genFuncs(root *ast.Module) error {
+	modules := make(map[string]*ast.Module)
+	collectModules(root, modules)
+	cg.setModule(root)
+	for _, f := range cg.info.Funcs {
+		cg.setModule(modules[f.Module])
+		if f.Decl.Extern {
+			fn, err := cg.declareExtern(f)
+			if err != nil {
+				return err
+			}
+			cg.funcs[f] = fn
+			continue
+		}
+		params := make([]*ir.Param, len(f.Params))
+		for i, p := range f.Params {
+			t, err := cg.llvmType(p.Type)
+			if err != nil {
+				return cg.at(&f.Decl.Params[i], err)
+			}
+			params[i] = ir.NewParam(p.Name, t)
+		}
+		var result types.Type = types.Void
+		if f.Result != nil {
+			t, err := cg.llvmType(f.Result)
+			if err != nil {
+				return cg.at(f.Decl, err)
+			}
+			result = t
+		}
+		name := f.Module + "." + f.Name
+		if f.Decl.Export {
+			name = f.Name
+		}
+		fn := cg.mod.NewFunc(name, result, params...)
+		if f.Decl.Export {
+			zeroExtBools(fn)
+		} else {
+			fn.Linkage = enum.LinkageInternal
+		}
+		cg.funcs[f] = fn
+	}
+	for _, f := range cg.info.Funcs {
+		if f.Decl.Extern {
+			continue
+		}
+		cg.setModule(modules[f.Module])
+		if err := cg.genFunc(f); err != nil {
+			return err
+		}
+	}
+	cg.setModule(root)
+	return nil
+}
+
+// setModule makes mod the module whose source is reported by runtime and compile errors.
+func (cg *Codegen) setModule(mod *ast.Module) {
+	cg.file = mod.File
+	cg.lines = strings.Split(mod.Source, "\n")
+}
+
+// collectModules records mod and every module it imports, by module path.
+func collectModules(mod *ast.Module, modules map[string]*ast.Module) {
+	if _, ok := modules[mod.Path]; ok {
+		return
+	}
+	modules[mod.Path] = mod
+	for _, name := range slices.Sorted(maps.Keys(mod.Imports)) {
+		collectModules(mod.Imports[name], modules)
+	}
+}
+
+// genFunc emits the body of the declared function f. Parameters are copied into stack slots so
+// that they can be reassigned like any other variable.
+func (cg *Codegen) genFunc(f *sema.Func) error {
+	fn, entry, block, tries, frame := cg.fn, cg.entry, cg.block, cg.tries, cg.frame
+	defer func() {
+		cg.fn, cg.entry, cg.block, cg.tries, cg.frame = fn, entry, block, tries, frame
+	}()
+	cg.tries = nil
+	cg.fn = cg.funcs[f]
+	cg.entry = cg.fn.NewBlock("entry")
+	cg.block = cg.entry
+	cg.enterFrame(cg.fn.Name())
+	for i, p := range cg.fn.Params {
+		cg.assign(cg.info.Vars[&f.Decl.Params[i]], p)
+	}
+	if err := cg.GenBlock(f.Decl.Body); err != nil {
+		return err
+	}
+	if cg.block.Term == nil {
+		if f.Result == nil {
+			cg.genRet(nil)
+		} else {
+			// sema guarantees that every path returns; this block is unreachable
+			cg.block.NewUnreachable()
+		}
+	}
+	return nil
+}
+
+// GenReturn emits a return from the current function. Code following the return is generated
+// into a fresh block that nothing jumps to.
+func (cg *Codegen) GenReturn(s *ast.ReturnStmt) error {
+	if s.Value == nil {
+		cg.genRet(nil)
+	} else {
+		val, err := cg.genReturnValue(s.Value)
+		if err != nil {
+			return err
+		}
+		cg.genRet(val)
+	}
+	cg.block = cg.newBlock("return.after")
+	return nil
+}
+
+// genReturnValue emits the value e returned from the current function, turning a plain value
+// returned from a function that returns a result into a successful result.
+func (cg *Codegen) genReturnValue(e ast.Expr) (value.Value, error) {
+	val, err := cg.genExpr(e)
+	if err != nil {
+		return nil, err
+	}
+	resultType := cg.fn.Sig.RetType
+	if val.Type().Equal(resultType) {
+		return val, nil
+	}
+	ok := cg.block.NewInsertValue(constant.NewUndef(resultType), constant.NewNull(types.I8Ptr), 0)
+	return cg.block.NewInsertValue(ok, val, 1), nil
 }
 
 // GenProgram emits code for a program (list of statements)
-func (cg *Codegen) GenProgram(prog *ast.Program) {
+func (cg *Codegen) GenProgram(prog *ast.Program) error {
 	for _, stmt := range prog.Stmts {
-		cg.GenStmt(stmt)
+		if err := cg.GenStmt(stmt); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,206 @@
+package codegen_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/builder/buildertest"
+	"github.com/engpetarmarinov/pede/codegen"
+	"github.com/engpetarmarinov/pede/rt"
+)
+
+// The programs are built with the stack trace bookkeeping, as by pede build
+var opts = builder.Options{StackTrace: true}
+
+func TestPrograms(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"arithmetic", "x = 3 + 4 * 2\nx -= 1\nx *= 2\nprint(x)\nprint(-x / 4)\n", "20.000000\n-5.000000\n"},
+		{"booleans", "x = 11\nif x == 11 && !(x > 20) {\n    print(true)\n} else {\n    print(false)\n}\n", "true\n"},
+		{"while", "i = 0\nwhile i < 3 {\n    print(i)\n    i++\n}\n", "0.000000\n1.000000\n2.000000\n"},
+		{"lists", "xs = [1, 2, 3]\nxs[0] = 10\nappend(xs, 4)\nsum = 0\nfor x in xs {\n    sum += x\n}\nprint(len(xs))\nprint(sum)\n", "4.000000\n19.000000\n"},
+		{"maps", "m = {\"a\": 1}\nm[\"b\"] = 2\nm[\"a\"]++\ndelete(m, \"b\")\nfor k in m {\n    print(k)\n    print(m[k])\n}\nprint(has(m, \"b\"))\n", "a\n2.000000\nfalse\n"},
+		{"structs", "type P {\n    x: float\n}\np = P{x: 1}\nq = p\nq.x += 10\nprint(p.x)\n", "11.000000\n"},
+		{"enums", "enum S {\n    A(r)\n    B(w, h)\n}\nfor s in [S.A(2), S.B(2, 3)] {\n    print(match s {\n        A(r) => r * r\n        B(w, h) => w * h\n    })\n}\n", "4.000000\n6.000000\n"},
+		{"constants", "const N = 2 * 3\nlet x = N + 1\nprint(x)\n", "7.000000\n"},
+		{"closures", "fn add(n: float): fn(float): float {\n    return fn(x) { x + n }\n}\nxs = map([1, 2], add(10))\nprint(reduce(xs, 0, fn(acc, x) { acc + x }))\n", "23.000000\n"},
+		{"results", "fn f(x: float): !float {\n    if x < 0 {\n        return error(\"negative\")\n    }\n    return x\n}\ntry {\n    print(f(1)?)\n    print(f(-1)?)\n} catch e {\n    print(e)\n}\n", "1.000000\nnegative\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := buildertest.Run(t, tt.src, opts)
+			if out != tt.want || code != 0 {
+				t.Errorf("program printed %q and exited with %d, want %q", out, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexAssignComputesValueFirst(t *testing.T) {
+	// Growing the list reallocates its elements, which the store must not write to
+	src := "xs = [1]\nxs[0] = len(append(xs, 7))\nprint(xs[0])\n"
+	if out, code := buildertest.Run(t, src, opts); out != "2.000000\n" || code != 0 {
+		t.Errorf("program printed %q and exited with %d, want 2", out, code)
+	}
+}
+
+func TestMapAssignComputesValueFirst(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		// The key is only inserted once the value is known
+		{"no phantom key", "m = {1: 1}\nm[2] = len(keys(m))\nprint(m[2])\n", "1.000000\n"},
+		// Growing the map moves its values, which the store must not write to
+		{"grown map", "fn grow(m: {float: float}): float {\n    i = 0\n    while i < 100 {\n        m[i] = i\n        i++\n    }\n    return 5\n}\nm = {1: 1}\nm[1000] = grow(m)\nprint(m[1000])\n", "5.000000\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if out, code := buildertest.Run(t, tt.src, opts); out != tt.want || code != 0 {
+				t.Errorf("program printed %q and exited with %d, want %q", out, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestForVisitsElementsPresentAtStart(t *testing.T) {
+	src := "xs = [1, 2]\nfor x in xs {\n    append(xs, x * 10)\n}\nprint(len(xs))\nm = {1: 1}\nfor k in m {\n    m[k + 1] = 1\n}\nprint(len(m))\n"
+	if out, code := buildertest.Run(t, src, opts); out != "4.000000\n2.000000\n" || code != 0 {
+		t.Errorf("program printed %q and exited with %d, want 4 and 2", out, code)
+	}
+}
+
+func TestPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"index", "xs = [1]\nprint(xs[3])\n", "panic: index out of range [3] with length 1 at "},
+		{"negative index", "xs = [1]\ni = -1\nprint(xs[i])\n", "panic: index out of range [-1] with length 1 at "},
+		{"fractional index", "xs = [1]\ni = 0.5\nprint(xs[i])\n", "panic: index out of range [0.5] with length 1 at "},
+		{"huge index", "xs = [1]\ni = 100000000000000000000\nprint(xs[i])\n", "panic: index out of range [1e+20] with length 1 at "},
+		{"infinite index", "xs = [1]\ni = 100000000000000000000\nwhile i < i * 2 {\n    i *= i\n}\nprint(xs[i])\n", "panic: index out of range [inf] with length 1 at "},
+		{"NaN index", "xs = [1]\ni = 100000000000000000000\nwhile i < i * 2 {\n    i *= i\n}\nprint(xs[i - i])\n", "nan] with length 1 at "},
+		{"division", "x = 0\nprint(1 / x)\n", "panic: division by zero at "},
+		{"missing key", "m = {\"a\": 1}\nprint(m[\"b\"])\n", "panic: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := buildertest.Run(t, tt.src, opts)
+			if !strings.Contains(out, tt.want) || code != rt.PanicExit {
+				t.Errorf("program printed %q and exited with %d, want a panic %q with exit code 2", out, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPanicPositions(t *testing.T) {
+	// Columns count the indentation of the line as written
+	src := "x = 0\nif true {\n        print(1 / x) // boom\n}\n"
+	out, code := buildertest.Run(t, src, opts)
+	if !strings.Contains(out, "panic: division by zero at ") || !strings.Contains(out, "main.pede:3:17\n") || code != rt.PanicExit {
+		t.Errorf("program printed %q and exited with %d, want a division by zero at main.pede:3:17", out, code)
+	}
+}
+
+func TestExit(t *testing.T) {
+	// A function with a result may end by exiting instead of returning
+	out, code := buildertest.Run(t, "fn stop(): float {\n    exit(4)\n}\nprint(1)\nprint(stop())\nprint(2)\n", opts)
+	if out != "1.000000\n" || code != 4 {
+		t.Errorf("program printed %q and exited with %d, want 1 and exit code 4", out, code)
+	}
+}
+
+func TestTestBinary(t *testing.T) {
+	src := "test \"passes\" {\n    assert_eq(1 + 1, 2)\n}\ntest \"fails\" {\n    assert_eq(\"a\", \"b\")\n}\n"
+	if out, code := buildertest.Run(t, src, builder.Options{Emit: builder.EmitTest, StackTrace: true}, "passes"); code != 0 {
+		t.Errorf("test passes printed %q and exited with %d, want 0", out, code)
+	}
+	out, code := buildertest.Run(t, src, builder.Options{Emit: builder.EmitTest, StackTrace: true}, "fails")
+	if !strings.Contains(out, `assertion failed: got "a", want "b"`) || !strings.Contains(out, `test "fails" at `) || code != rt.AssertExit {
+		t.Errorf("test fails printed %q and exited with %d, want an assertion failure in test \"fails\" with exit code 3", out, code)
+	}
+}
+
+// genIR generates the IR of the program whose main file is file, for the output kind emit.
+func genIR(t *testing.T, file, emit string) string {
+	t.Helper()
+	root, err := builder.LoadModules(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := builder.Check(root, emit == builder.EmitStaticLib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cg, err := builder.Codegen(root, info, "", "", emit, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ir strings.Builder
+	if _, err := cg.WriteTo(&ir); err != nil {
+		t.Fatal(err)
+	}
+	return ir.String()
+}
+
+func TestBlockSymbols(t *testing.T) {
+	file := buildertest.WriteSource(t, "main.pede", "test \"adds one\" {\n    assert(true)\n}\nbench \"b\" {\n    assert(true)\n}\n")
+	for _, emit := range []string{builder.EmitTest, builder.EmitBench} {
+		// Names with spaces only assemble with an integrated assembler
+		if ir, want := genIR(t, file, emit), "define internal void @pede."+emit+".0()"; !strings.Contains(ir, want) {
+			t.Errorf("%s IR does not define %s:\n%s", emit, want, ir)
+		}
+	}
+}
+
+func TestIRIsDeterministic(t *testing.T) {
+	dir := buildertest.WriteFiles(t, map[string]string{
+		"main.pede": "import \"a\"\nimport \"b\"\nimport \"c\"\nprint(a.f() + b.f() + c.f())\n",
+		"a.pede":    "pub fn f(): float {\n    return 1\n}\n",
+		"b.pede":    "pub fn f(): float {\n    return 2\n}\n",
+		"c.pede":    "pub fn f(): float {\n    return 3\n}\n",
+	})
+	file := filepath.Join(dir, "main.pede")
+	want := genIR(t, file, builder.EmitExe)
+	// Imports sit in a map, whose order changes from one iteration to the next
+	for range 10 {
+		if got := genIR(t, file, builder.EmitExe); got != want {
+			t.Fatalf("two builds of the same program generated different IR:\n%s\n\n%s", want, got)
+		}
+	}
+}
+
+func TestHeaderOrder(t *testing.T) {
+	dir := buildertest.WriteFiles(t, map[string]string{
+		"lib.pede": "import \"b\"\nimport \"a\"\nexport fn lib_f(): float {\n    return a.f() + b.f()\n}\n",
+		"a.pede":   "pub fn f(): float {\n    return 1\n}\nexport fn a_f(): float {\n    return 1\n}\n",
+		"b.pede":   "pub fn f(): float {\n    return 2\n}\nexport fn b_f(x: float): float {\n    return x\n}\n",
+	})
+	root, err := builder.LoadModules(filepath.Join(dir, "lib.pede"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Imported modules come first, in name order, as they are checked
+	want := "double a_f(void);\ndouble b_f(double x);\ndouble lib_f(void);\n"
+	for range 10 {
+		info, err := builder.Check(root, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := codegen.GenHeader("lib", "lib.pede", info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(h, want) {
+			t.Fatalf("GenHeader() = %s, want the prototypes\n%s", h, want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/sema"
+)
+
+// GenHeader returns a C header with the prototypes of the exported functions of a program and
+// definitions of the types they use. name is the library name, which the include guard is built
+// from, and source is the .pede file the library was built from. The prototypes follow the order
+// of info.Funcs, so that the same program always gives the same header.
+func GenHeader(name, source string, info *sema.Info) (string, error) {
+	h := &header{seen: make(map[string]bool)}
+	var protos []string
+	for _, f := range info.Funcs {
+		if !f.Decl.Export {
+			continue
+		}
+		result := "void"
+		if f.Result != nil {
+			result = h.cType(f.Result)
+		}
+		params := make([]string, len(f.Params))
+		for i, p := range f.Params {
+			params[i] = cDecl(h.cType(p.Type), p.Name)
+		}
+		if len(params) == 0 {
+			params = []string{"void"}
+		}
+		if h.err != nil {
+			return "", fmt.Errorf("exported function %s: %w", f.Name, h.err)
+		}
+		protos = append(protos, cDecl(result, f.Name)+"("+strings.Join(params, ", ")+");")
+	}
+
+	guard := strings.ToUpper(cIdent(name)) + "_H"
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "/* Code generated by pede from %s. DO NOT EDIT. */\n\n", source)
+	fmt.Fprintf(&sb, "#ifndef %s\n#define %s\n\n#include <stdbool.h>\n\n", guard, guard)
+	sb.WriteString("#ifdef __cplusplus\nextern \"C\" {\n#endif\n\n")
+	if len(h.typedefs) > 0 {
+		for _, td := range h.typedefs {
+			sb.WriteString(td + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	for _, def := range h.defs {
+		sb.WriteString(def + "\n\n")
+	}
+	for _, proto := range protos {
+		sb.WriteString(proto + "\n")
+	}
+	sb.WriteString("\n#ifdef __cplusplus\n}\n#endif\n\n#endif /* " + guard + " */\n")
+	return sb.String(), nil
+}
+
+// header collects the C types used by the exported functions.
+type header struct {
+	seen     map[string]bool // C names of the struct types declared so far
+	typedefs []string        // forward declarations of every struct type used
+	defs     []string        // definitions of the pede structs, whose layout C may rely on
+	err      error           // first type without a C spelling
+}
+
+// cType returns the C spelling of a pede type, declaring the types it refers to on first use.
+// Structs, enums, lists and maps are passed by pointer, as pede handles them. A type without a C
+// spelling is recorded in h.err.
+func (h *header) cType(t sema.Type) string {
+	switch t := t.(type) {
+	case *sema.Basic:
+		switch t {
+		case sema.Float:
+			return "double"
+		case sema.String:
+			return "const char *"
+		case sema.Bool:
+			return "bool"
+		case sema.Ptr:
+			return "void *"
+		}
+	case *sema.List:
+		h.declare("pede_list")
+		return "pede_list *"
+	case *sema.Map:
+		h.declare("pede_map")
+		return "pede_map *"
+	case *sema.Signature:
+		// Function values are opaque to C, which can only pass them back to pede
+		if h.declare("pede_fn") {
+			h.defs = append(h.defs, "struct pede_fn {\n\tvoid *code;\n\tvoid *env;\n};")
+		}
+		return "pede_fn"
+	case *sema.Result:
+		// A result is passed by value as its error message, NULL on success, and its value
+		val := h.cType(t.Value)
+		name := "pede_result_" + cIdent(strings.TrimSpace(strings.TrimSuffix(val, "*")))
+		if h.declare(name) {
+			h.defs = append(h.defs, fmt.Sprintf("struct %s {\n\tconst char *err;\n\t%s;\n};", name, cDecl(val, "value")))
+		}
+		return name
+	case *sema.Enum:
+		name := cIdent(qualify(t.Module, t.Name))
+		h.declare(name)
+		return name + " *"
+	case *sema.Struct:
+		name := cIdent(qualify(t.Module, t.Name))
+		if !h.declare(name) {
+			return name + " *"
+		}
+		fields := make([]string, len(t.Fields))
+		for i, f := range t.Fields {
+			fields[i] = "\t" + cDecl(h.cType(f.Type), f.Name) + ";"
+		}
+		h.defs = append(h.defs, fmt.Sprintf("struct %s {\n%s\n};", name, strings.Join(fields, "\n")))
+		return name + " *"
+	}
+	if h.err == nil {
+		h.err = fmt.Errorf("type %s has no C representation", t)
+	}
+	return "void *"
+}
+
+// declare forward declares the struct type name and reports whether it was not declared yet.
+func (h *header) declare(name string) bool {
+	if h.seen[name] {
+		return false
+	}
+	h.seen[name] = true
+	h.typedefs = append(h.typedefs, fmt.Sprintf("typedef struct %s %s;", name, name))
+	return true
+}
+
+// cDecl declares name with the C type ctype, keeping pointer stars next to the name.
+func cDecl(ctype, name string) string {
+	if strings.HasSuffix(ctype, "*") {
+		return ctype + name
+	}
+	return ctype + " " + name
+}
+
+// cIdent turns a qualified pede name such as geometry/shapes.Point into a C identifier.
+func cIdent(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
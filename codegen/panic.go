@@ -0,0 +1,58 @@
+package codegen
+
+import (
+	"github.com/engpetarmarinov/pede/ast"
+
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// srcPos returns the file, line and column arguments the runtime reports for a panic at node.
+func (cg *Codegen) srcPos(node any) []value.Value {
+	pos := ast.PosOf(node)
+	return []value.Value{
+		cg.stringPtr(cg.file),
+		constant.NewInt(types.I64, int64(pos.Line)),
+		constant.NewInt(types.I64, int64(pos.Column)),
+	}
+}
+
+// genPanicIf emits a check that panics with msg at the position of node when failed is true.
+func (cg *Codegen) genPanicIf(failed value.Value, msg string, node any) {
+	panicBlock := cg.newBlock("panic")
+	okBlock := cg.newBlock("panic.ok")
+	cg.block.NewCondBr(failed, panicBlock, okBlock)
+
+	cg.block = panicBlock
+	panicFn := cg.runtimeFunc("pede_panic", types.Void, types.I8Ptr, types.I8Ptr, types.I64, types.I64)
+	cg.block.NewCall(panicFn, append([]value.Value{cg.stringPtr(msg)}, cg.srcPos(node)...)...)
+	cg.block.NewUnreachable()
+
+	cg.block = okBlock
+}
+
+// genFloatToInt converts v to the C integer type it, panicking at node if it is out of range.
+func (cg *Codegen) genFloatToInt(v value.Value, it *types.IntType, node any) value.Value {
+	convert := cg.runtimeFunc("pede_float_to_int", types.I64, types.Double, types.I32, types.I8Ptr, types.I64, types.I64)
+	args := append([]value.Value{v, constant.NewInt(types.I32, int64(it.BitSize))}, cg.srcPos(node)...)
+	var result value.Value = cg.block.NewCall(convert, args...)
+	if it.BitSize < 64 {
+		result = cg.block.NewTrunc(result, it)
+	}
+	return result
+}
+
+// genExit emits exit(code), which ends the program. Code following it is generated into a fresh
+// block that nothing jumps to.
+func (cg *Codegen) genExit(n *ast.Call) error {
+	code, err := cg.genExpr(n.Args[0])
+	if err != nil {
+		return err
+	}
+	exitFn := cg.runtimeFunc("pede_exit", types.Void, types.Double, types.I8Ptr, types.I64, types.I64)
+	cg.block.NewCall(exitFn, append([]value.Value{code}, cg.srcPos(n)...)...)
+	cg.block.NewUnreachable()
+	cg.block = cg.newBlock("exit.after")
+	return nil
+}
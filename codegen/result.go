@@ -0,0 +1,80 @@
+package codegen
+
+import (
+	"github.com/engpetarmarinov/pede/ast"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A result !T is a { i8*, T } pair of an error message and a value. The message is null for
+// successful results; errors leave the value zeroed. Errors are propagated with ordinary branches
+// and returns, so no unwinding support is needed from the runtime.
+
+// tryTarget is the catch block of an enclosing try statement, and the slot receiving the message
+// of the error propagated to it.
+type tryTarget struct {
+	catch *ir.Block
+	err   *ir.InstAlloca
+}
+
+// genError emits an error of the result type t carrying message.
+func (cg *Codegen) genError(t types.Type, message value.Value) value.Value {
+	return cg.block.NewInsertValue(constant.NewZeroInitializer(t), message, 0)
+}
+
+// genPropagate emits x?: the value of a successful result, or a jump to the innermost enclosing
+// catch block, or else a return of the error from the current function.
+func (cg *Codegen) genPropagate(n *ast.Propagate) (value.Value, error) {
+	result, err := cg.genExpr(n.X)
+	if err != nil {
+		return nil, err
+	}
+	message := cg.block.NewExtractValue(result, 0)
+	failed := cg.block.NewICmp(enum.IPredNE, message, constant.NewNull(types.I8Ptr))
+	errBlock := cg.newBlock("propagate.err")
+	okBlock := cg.newBlock("propagate.ok")
+	cg.block.NewCondBr(failed, errBlock, okBlock)
+
+	cg.block = errBlock
+	if len(cg.tries) > 0 {
+		try := cg.tries[len(cg.tries)-1]
+		cg.block.NewStore(message, try.err)
+		cg.block.NewBr(try.catch)
+	} else {
+		cg.genRet(cg.genError(cg.fn.Sig.RetType, message))
+	}
+
+	cg.block = okBlock
+	return cg.block.NewExtractValue(result, 1), nil
+}
+
+// GenTry emits the body of a try statement, whose propagated errors branch to the catch block.
+func (cg *Codegen) GenTry(s *ast.TryStmt) error {
+	catchBlock := cg.newBlock("try.catch")
+	endBlock := cg.newBlock("try.end")
+	errSlot := cg.newAlloca(types.I8Ptr)
+
+	cg.tries = append(cg.tries, tryTarget{catch: catchBlock, err: errSlot})
+	err := cg.GenBlock(s.Body)
+	cg.tries = cg.tries[:len(cg.tries)-1]
+	if err != nil {
+		return err
+	}
+	cg.branchTo(endBlock)
+
+	cg.block = catchBlock
+	if v := cg.info.Vars[s]; v != nil {
+		cg.assign(v, cg.block.NewLoad(types.I8Ptr, errSlot))
+	}
+	if err := cg.GenBlock(s.Catch); err != nil {
+		return err
+	}
+	cg.branchTo(endBlock)
+
+	cg.block = endBlock
+	return nil
+}
@@ -0,0 +1,133 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/sema"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// A test binary has a function for every test block of the root module, which main registers
+// with the runtime by name before handing over to pede_test_main. That runs the test named by the
+// first argument of the binary, so that every test runs in a process of its own. Benchmark
+// binaries are built the same way from the bench blocks, and run by pede_bench_main.
+
+// GenTests emits every declared function of the root module and of the modules it imports, and
+// the test blocks of the root module, with a main function running one of them.
+func (cg *Codegen) GenTests(root *ast.Module) error {
+	return cg.genRunner(root, "test", "pede_test_add", "pede_test_main")
+}
+
+// GenBenches emits every declared function of the root module and of the modules it imports, and
+// the bench blocks of the root module, with a main function running one of them.
+func (cg *Codegen) GenBenches(root *ast.Module) error {
+	return cg.genRunner(root, "bench", "pede_bench_add", "pede_bench_main")
+}
+
+// genRunner emits the declared functions and the test or bench blocks of root, as selected by
+// kind, and a main function that registers the blocks with the runtime function add and returns
+// what the runtime function run returns.
+func (cg *Codegen) genRunner(root *ast.Module, kind, add, run string) error {
+	if err := cg.genFuncs(root); err != nil {
+		return err
+	}
+	var names []string
+	var fns []*ir.Func
+	for _, stmt := range root.Program.Stmts {
+		var name string
+		var body *ast.Block
+		switch decl := stmt.(type) {
+		case *ast.TestDecl:
+			if kind == "test" {
+				name, body = decl.Name, decl.Body
+			}
+		case *ast.BenchDecl:
+			if kind == "bench" {
+				name, body = decl.Name, decl.Body
+			}
+		}
+		if body == nil {
+			continue
+		}
+		// The symbol is plain, as assemblers reject quoted names with spaces and quotes in them
+		fn, err := cg.genBlockFunc(fmt.Sprintf("pede.%s.%d", kind, len(fns)), fmt.Sprintf("%s %q", kind, name), body)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		fns = append(fns, fn)
+	}
+
+	argv := types.NewPointer(types.I8Ptr)
+	cg.fn = cg.mod.NewFunc("main", types.I32, ir.NewParam("argc", types.I32), ir.NewParam("argv", argv))
+	cg.entry = cg.fn.NewBlock("entry")
+	cg.block = cg.entry
+	blockFunc := types.NewPointer(types.NewFunc(types.Void))
+	addFn := cg.runtimeFunc(add, types.Void, types.I8Ptr, blockFunc)
+	for i, name := range names {
+		cg.block.NewCall(addFn, cg.stringPtr(name), fns[i])
+	}
+	runFn := cg.runtimeFunc(run, types.I32, types.I32, argv)
+	cg.block.NewRet(cg.block.NewCall(runFn, cg.fn.Params[0], cg.fn.Params[1]))
+	return nil
+}
+
+// genBlockFunc emits body, of a test or bench block, as the function symbol without parameters or
+// result, named name in stack traces.
+func (cg *Codegen) genBlockFunc(symbol, name string, body *ast.Block) (*ir.Func, error) {
+	fn, entry, block, tries, frame := cg.fn, cg.entry, cg.block, cg.tries, cg.frame
+	defer func() {
+		cg.fn, cg.entry, cg.block, cg.tries, cg.frame = fn, entry, block, tries, frame
+	}()
+	cg.tries = nil
+	cg.fn = cg.mod.NewFunc(symbol, types.Void)
+	cg.fn.Linkage = enum.LinkageInternal
+	cg.entry = cg.fn.NewBlock("entry")
+	cg.block = cg.entry
+	cg.enterFrame(name)
+	if err := cg.GenBlock(body); err != nil {
+		return nil, err
+	}
+	if cg.block.Term == nil {
+		cg.genRet(nil)
+	}
+	return cg.fn, nil
+}
+
+// genAssert emits assert(cond[, message]), which fails the running test at n when cond is false.
+func (cg *Codegen) genAssert(n *ast.Call, args []value.Value) {
+	var msg value.Value = constant.NewNull(types.I8Ptr)
+	if len(args) == 2 {
+		msg = args[1]
+	}
+	assert := cg.runtimeFunc("pede_assert", types.Void, types.I32, types.I8Ptr, types.I8Ptr, types.I64, types.I64)
+	cond := cg.block.NewZExt(args[0], types.I32)
+	cg.block.NewCall(assert, append([]value.Value{cond, msg}, cg.srcPos(n)...)...)
+}
+
+// genAssertEq emits assert_eq(got, want), which fails the running test at n when got differs
+// from want. The runtime compares the values, so that it can show both in the failure.
+func (cg *Codegen) genAssertEq(n *ast.Call, args []value.Value) error {
+	got, want := args[0], args[1]
+	var assert *ir.Func
+	switch t := cg.info.TypeOf(n.Args[0]); t {
+	case sema.Float:
+		assert = cg.runtimeFunc("pede_assert_eq_float", types.Void, types.Double, types.Double, types.I8Ptr, types.I64, types.I64)
+	case sema.String:
+		assert = cg.runtimeFunc("pede_assert_eq_string", types.Void, types.I8Ptr, types.I8Ptr, types.I8Ptr, types.I64, types.I64)
+	case sema.Bool:
+		assert = cg.runtimeFunc("pede_assert_eq_bool", types.Void, types.I32, types.I32, types.I8Ptr, types.I64, types.I64)
+		got = cg.block.NewZExt(got, types.I32)
+		want = cg.block.NewZExt(want, types.I32)
+	default:
+		return cg.errorf(n.Args[0], "cannot compare values of type %s in assert_eq", t)
+	}
+	cg.block.NewCall(assert, append([]value.Value{got, want}, cg.srcPos(n)...)...)
+	return nil
+}
@@ -0,0 +1,75 @@
+package codegen
+
+import (
+	"github.com/engpetarmarinov/pede/ast"
+
+	"github.com/llir/llvm/ir"
+	"github.com/llir/llvm/ir/constant"
+	"github.com/llir/llvm/ir/enum"
+	"github.com/llir/llvm/ir/types"
+	"github.com/llir/llvm/ir/value"
+)
+
+// With StackTrace set, every function written in pede keeps a %pede_frame on its stack while it
+// runs: the prologue links it into the shadow call stack headed by @pede_trace_top and every return
+// unlinks it. A frame records the function, its source file and the line of the call it is making,
+// which the runtime prints below the message of a panic.
+
+// frameType returns %pede_frame, declaring it on first use.
+func (cg *Codegen) frameType() *types.StructType {
+	if st, ok := cg.structs["pede_frame"]; ok {
+		return st
+	}
+	st := &types.StructType{}
+	cg.mod.NewTypeDef("pede_frame", st)
+	st.Fields = []types.Type{types.NewPointer(st), types.I8Ptr, types.I8Ptr, types.I64}
+	cg.structs["pede_frame"] = st
+	return st
+}
+
+// traceTop returns the runtime global holding the innermost frame, declaring it on first use.
+func (cg *Codegen) traceTop() *ir.Global {
+	if cg.traceTopGlobal == nil {
+		cg.traceTopGlobal = cg.mod.NewGlobal("pede_trace_top", types.NewPointer(cg.frameType()))
+		cg.traceTopGlobal.Linkage = enum.LinkageExternal
+	}
+	return cg.traceTopGlobal
+}
+
+// frameField returns a pointer to field i of the frame of the current function.
+func (cg *Codegen) frameField(i int64) value.Value {
+	return cg.block.NewGetElementPtr(cg.frameType(), cg.frame, constant.NewInt(types.I32, 0), constant.NewInt(types.I32, i))
+}
+
+// enterFrame pushes a frame for the current function, which has just been started, named name in
+// stack traces.
+func (cg *Codegen) enterFrame(name string) {
+	cg.frame = nil
+	if !cg.StackTrace {
+		return
+	}
+	top := cg.traceTop()
+	cg.frame = cg.newAlloca(cg.frameType())
+	cg.block.NewStore(cg.block.NewLoad(top.ContentType, top), cg.frameField(0))
+	cg.block.NewStore(cg.stringPtr(name), cg.frameField(1))
+	cg.block.NewStore(cg.stringPtr(cg.file), cg.frameField(2))
+	cg.block.NewStore(constant.NewInt(types.I64, 0), cg.frameField(3))
+	cg.block.NewStore(cg.frame, top)
+}
+
+// markLine records the line of node, a call about to be made, in the frame of the current function.
+func (cg *Codegen) markLine(node any) {
+	if cg.frame == nil {
+		return
+	}
+	cg.block.NewStore(constant.NewInt(types.I64, int64(ast.PosOf(node).Line)), cg.frameField(3))
+}
+
+// genRet pops the frame of the current function and returns val, or nothing when val is nil.
+func (cg *Codegen) genRet(val value.Value) {
+	if cg.frame != nil {
+		prev := cg.block.NewLoad(types.NewPointer(cg.frameType()), cg.frameField(0))
+		cg.block.NewStore(prev, cg.traceTop())
+	}
+	cg.block.NewRet(val)
+}
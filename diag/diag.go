@@ -0,0 +1,151 @@
+// Package diag describes the errors and warnings reported by the pede compiler and renders them
+// for terminals, as JSON and as SARIF.
+package diag
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Severity is how serious a diagnostic is.
+type Severity int
+
+const (
+	Error   Severity = iota // the program cannot be built
+	Warning                 // suspicious but valid code
+	Note                    // additional information
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Codes identify the kind of a diagnostic, grouped by the stage that reports it.
+const (
+	CodeOptions            = "E0001" // the options of a build are invalid
+	CodeRead               = "E0100" // a source file cannot be read
+	CodePreprocess         = "E0101" // the preprocessor rejected a source file
+	CodeUnterminatedString = "E0200" // a string literal runs to the end of the line
+	CodeUnknownChar        = "E0201" // a character that starts no token
+	CodeSyntax             = "E0300" // the parser found unexpected input
+	CodeModuleNotFound     = "E0400" // an imported module cannot be found
+	CodeDuplicateImport    = "E0401" // two imports bind the same name
+	CodeImportCycle        = "E0402" // modules import each other
+	CodeType               = "E0500" // a value has a type its use does not allow, or another checker error
+	CodeUndefined          = "E0501" // a name, field or variant is not declared
+	CodeRedeclared         = "E0502" // a name, field or variant is declared twice
+	CodeArgCount           = "E0503" // a call passes the wrong number of arguments
+	CodeMissingReturn      = "E0504" // a function can end without returning a value
+	CodeNotPublic          = "E0505" // a declaration of another module is not declared with pub
+	CodeAssign             = "E0506" // a constant, let variable or other fixed name is assigned
+	CodeMatch              = "E0507" // a match misses a variant or has an arm that never runs
+	CodeDivisionByZero     = "E0508" // a constant divisor is zero
+	CodeNotConstant        = "E0509" // the value of a constant is not known at compile time
+	CodeMisplaced          = "E0510" // a declaration or statement is not allowed where it appears
+	CodeCodegen            = "E0600" // the program could not be compiled to LLVM IR
+	CodeLink               = "E0700" // the C toolchain failed
+	CodeShadow             = "W0100" // a shadowed variable is used again after the block that hid it
+	CodeUnusedVariable     = "W0101" // a variable is never used after its declaration
+	CodeUnreadVariable     = "W0102" // a variable is assigned but its value is never read
+	CodeUnreachable        = "W0103" // a statement can never run
+	CodeConstantCondition  = "W0104" // an if or while condition is always true or always false
+	CodeSelfAssign         = "W0105" // a variable, field or element is assigned to itself
+)
+
+// Pos is a position in a source file. Line and Column are 1-based and count characters.
+type Pos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Span is the range of source code a diagnostic points at. End is exclusive; a span with a zero
+// Start covers no source, such as a failed link.
+type Span struct {
+	File  string `json:"file,omitempty"`
+	Start Pos    `json:"start"`
+	End   Pos    `json:"end"`
+}
+
+// Suggestion is a fix replacing the code in Span with Replacement.
+type Suggestion struct {
+	Message     string `json:"message"`
+	Span        Span   `json:"span"`
+	Replacement string `json:"replacement"`
+}
+
+// Diagnostic is an error or warning about a program. A *Diagnostic of severity Error is the error
+// value returned by the stages of the compiler.
+type Diagnostic struct {
+	Severity    Severity     `json:"severity"`
+	Code        string       `json:"code"`
+	Message     string       `json:"message"`
+	Span        Span         `json:"span"`
+	Source      string       `json:"-"` // text of the line Span starts on, shown under the message
+	Notes       []string     `json:"notes,omitempty"`
+	Suggestions []Suggestion `json:"suggestions,omitempty"`
+}
+
+// Errorf returns an error diagnostic with the given code pointing at span, whose first line is source.
+func Errorf(code string, span Span, source, format string, args ...any) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+		Source:   source,
+	}
+}
+
+// Error renders the diagnostic without colors.
+func (d *Diagnostic) Error() string {
+	var b strings.Builder
+	render(&b, d, false)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// List is an error made of several diagnostics, such as warnings promoted to errors.
+type List []*Diagnostic
+
+// Error renders every diagnostic of the list without colors.
+func (l List) Error() string {
+	msgs := make([]string, len(l))
+	for i, d := range l {
+		msgs[i] = d.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Located reports whether the diagnostic points at source code.
+func (d *Diagnostic) Located() bool {
+	return d.Span.Start.Line > 0
+}
+
+// WordSpan returns the span in file of the identifier or number starting at pos on the line source,
+// or of the single character there.
+func WordSpan(file string, pos Pos, source string) Span {
+	line := []rune(source)
+	end := pos.Column
+	for end-1 < len(line) && end >= 1 && isWordRune(line[end-1]) {
+		end++
+	}
+	if end == pos.Column {
+		end++
+	}
+	return Span{File: file, Start: pos, End: Pos{Line: pos.Line, Column: end}}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+}
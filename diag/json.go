@@ -0,0 +1,151 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Formats in which diagnostics can be written
+const (
+	FormatText  = "text"  // the terminal rendering of Render
+	FormatJSON  = "json"  // a JSON array of diagnostics
+	FormatSARIF = "sarif" // a SARIF 2.1.0 log with a single run
+)
+
+// Write writes the diagnostics in format, one of the Format constants; color only affects text.
+func Write(w io.Writer, format string, diags []*Diagnostic, color bool) error {
+	switch format {
+	case FormatJSON:
+		return WriteJSON(w, diags)
+	case FormatSARIF:
+		return WriteSARIF(w, diags)
+	default:
+		return Render(w, diags, color)
+	}
+}
+
+// WriteJSON writes the diagnostics as a JSON array, which is empty when there are none.
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	if diags == nil {
+		diags = []*Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+// The subset of the SARIF 2.1.0 object model written by WriteSARIF
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId,omitempty"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+		Fixes     []sarifFix      `json:"fixes,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysical `json:"physicalLocation"`
+	}
+	sarifPhysical struct {
+		ArtifactLocation sarifArtifact `json:"artifactLocation"`
+		Region           *sarifRegion  `json:"region,omitempty"`
+	}
+	sarifArtifact struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndLine     int `json:"endLine,omitempty"`
+		EndColumn   int `json:"endColumn,omitempty"`
+	}
+	sarifFix struct {
+		Description     sarifMessage          `json:"description"`
+		ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+	}
+	sarifArtifactChange struct {
+		ArtifactLocation sarifArtifact      `json:"artifactLocation"`
+		Replacements     []sarifReplacement `json:"replacements"`
+	}
+	sarifReplacement struct {
+		DeletedRegion   sarifRegion  `json:"deletedRegion"`
+		InsertedContent sarifMessage `json:"insertedContent"`
+	}
+)
+
+// WriteSARIF writes the diagnostics as a SARIF log, the format read by code scanning services.
+// Notes are appended to the message text.
+func WriteSARIF(w io.Writer, diags []*Diagnostic) error {
+	run := sarifRun{
+		Tool:    sarifTool{Driver: sarifDriver{Name: "pede", Rules: []sarifRule{}}},
+		Results: []sarifResult{},
+	}
+	for _, d := range diags {
+		if d.Code != "" && !slices.ContainsFunc(run.Tool.Driver.Rules, func(r sarifRule) bool { return r.ID == d.Code }) {
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: d.Code})
+		}
+		text := strings.Join(append([]string{d.Message}, d.Notes...), "\n")
+		result := sarifResult{RuleID: d.Code, Level: d.Severity.String(), Message: sarifMessage{Text: text}}
+		if d.Span.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysical{ArtifactLocation: sarifArtifact{URI: sarifURI(d.Span.File)}}}
+			if d.Located() {
+				loc.PhysicalLocation.Region = sarifSpan(d.Span)
+			}
+			result.Locations = []sarifLocation{loc}
+		}
+		for _, s := range d.Suggestions {
+			result.Fixes = append(result.Fixes, sarifFix{
+				Description: sarifMessage{Text: s.Message},
+				ArtifactChanges: []sarifArtifactChange{{
+					ArtifactLocation: sarifArtifact{URI: sarifURI(s.Span.File)},
+					Replacements: []sarifReplacement{{
+						DeletedRegion:   *sarifSpan(s.Span),
+						InsertedContent: sarifMessage{Text: s.Replacement},
+					}},
+				}},
+			})
+		}
+		run.Results = append(run.Results, result)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
+
+func sarifSpan(s Span) *sarifRegion {
+	return &sarifRegion{StartLine: s.Start.Line, StartColumn: s.Start.Column, EndLine: s.End.Line, EndColumn: s.End.Column}
+}
+
+// sarifURI returns the artifact URI of a source file, a relative reference for relative paths.
+func sarifURI(file string) string {
+	return filepath.ToSlash(file)
+}
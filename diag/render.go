@@ -0,0 +1,104 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ANSI escape sequences used by the terminal renderer
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[1;31m"
+	ansiYellow = "\x1b[1;33m"
+	ansiCyan   = "\x1b[1;36m"
+	ansiBlue   = "\x1b[1;34m"
+)
+
+// ColorEnabled reports whether diagnostics written to f should be colored: f is a terminal and
+// the NO_COLOR environment variable is not set.
+func ColorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Render writes the diagnostics for a terminal, in the style
+//
+//	error[E0501]: undefined variable "y"
+//	 --> main.pede:1:5
+//	  |
+//	1 | x = y
+//	  |     ^
+//
+// underlining the whole span, with ANSI colors if color is set.
+func Render(w io.Writer, diags []*Diagnostic, color bool) error {
+	var b strings.Builder
+	for _, d := range diags {
+		render(&b, d, color)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func render(b *strings.Builder, d *Diagnostic, color bool) {
+	paint := func(style, s string) string {
+		if !color {
+			return s
+		}
+		return style + s + ansiReset
+	}
+	sevColor := map[Severity]string{Error: ansiRed, Warning: ansiYellow, Note: ansiCyan}[d.Severity]
+	title := d.Severity.String()
+	if d.Code != "" {
+		title += "[" + d.Code + "]"
+	}
+	b.WriteString(paint(sevColor, title) + paint(ansiBold, ": "+d.Message) + "\n")
+
+	gutter := ""
+	if d.Located() {
+		lineNo := strconv.Itoa(d.Span.Start.Line)
+		gutter = strings.Repeat(" ", len(lineNo))
+		fmt.Fprintf(b, "%s%s %s:%d:%d\n", gutter, paint(ansiBlue, "-->"), d.Span.File, d.Span.Start.Line, d.Span.Start.Column)
+		if d.Source != "" {
+			bar := paint(ansiBlue, "|")
+			fmt.Fprintf(b, "%s %s\n", gutter, bar)
+			fmt.Fprintf(b, "%s %s %s\n", paint(ansiBlue, lineNo), bar, d.Source)
+			fmt.Fprintf(b, "%s %s %s\n", gutter, bar, paint(sevColor, underline(d.Span, d.Source)))
+		}
+	} else if d.Span.File != "" {
+		fmt.Fprintf(b, "%s %s\n", paint(ansiBlue, "-->"), d.Span.File)
+	}
+	for _, note := range d.Notes {
+		fmt.Fprintf(b, "%s %s %s\n", gutter, paint(ansiBlue, "="), paint(ansiBold, "note:")+" "+note)
+	}
+	for _, s := range d.Suggestions {
+		fmt.Fprintf(b, "%s %s %s %s: `%s`\n", gutter, paint(ansiBlue, "="), paint(ansiBold, "help:"), s.Message, s.Replacement)
+	}
+}
+
+// underline returns the markers placed under the span on its first line, source. Tabs before the
+// span are kept so that the markers line up with the code above them.
+func underline(span Span, source string) string {
+	line := []rune(source)
+	start := span.Start.Column - 1
+	end := len(line)
+	if span.End.Line == span.Start.Line && span.End.Column > span.Start.Column {
+		end = span.End.Column - 1
+	}
+	var b strings.Builder
+	for i := 0; i < start; i++ {
+		if i < len(line) && line[i] == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", max(end-start, 1)))
+	return b.String()
+}
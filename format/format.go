@@ -0,0 +1,133 @@
+// Package format prints pede source in its canonical style: blocks indented by four spaces, single
+// spaces around binary operators and after commas, and at most one blank line between statements.
+// Comments are kept where they were, and constructs written on one line stay on one line.
+package format
+
+import (
+	"math"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/parser"
+)
+
+// indentUnit indents each level of blocks
+const indentUnit = "    "
+
+// Source formats the pede source src. Syntax errors are returned as diagnostics without a file.
+func Source(src []byte) ([]byte, error) {
+	text := string(src)
+	if err := lexer.Check(text); err != nil {
+		return nil, err
+	}
+	program, err := parser.NewParser(lexer.NewLexer(text)).Parse()
+	if err != nil {
+		return nil, err
+	}
+	p := &printer{lines: strings.Split(text, "\n"), comments: program.Comments}
+	p.stmts(program.Stmts)
+	p.commentsBefore(math.MaxInt, len(program.Stmts) == 0)
+	return []byte(p.buf.String()), nil
+}
+
+// printer writes a syntax tree back as source. Comments are not part of the tree: they are printed
+// when the output reaches the source line they were on.
+type printer struct {
+	buf      strings.Builder
+	lines    []string      // lines of the source, to find the blank ones
+	comments []ast.Comment // comments not printed yet, in source order
+	indent   int           // current block depth
+	line     int           // last source line printed from
+	header   bool          // printing the header of an if, while, for or match
+}
+
+func (p *printer) write(s string) {
+	p.buf.WriteString(s)
+}
+
+func (p *printer) writeIndent() {
+	p.write(strings.Repeat(indentUnit, p.indent))
+}
+
+// at records that the node at pos is printed.
+func (p *printer) at(pos ast.Pos) {
+	p.line = max(p.line, pos.Line)
+}
+
+// newline ends the output line, after the comments that trail the source lines printed so far.
+func (p *printer) newline() {
+	for len(p.comments) > 0 && p.comments[0].Line <= p.line {
+		p.write(" " + p.comments[0].Text)
+		p.comments = p.comments[1:]
+	}
+	p.write("\n")
+}
+
+// leading starts an item of a block or list that begins on the source line: it prints the
+// comments on the lines before, and keeps a blank line where the source has one. There is none
+// before the first item.
+func (p *printer) leading(line int, first bool) {
+	if p.commentsBefore(line, first) {
+		first = false
+	}
+	if !first && p.blank(line-1) {
+		p.write("\n")
+	}
+	p.writeIndent()
+}
+
+// commentsBefore prints the comments before the source line on lines of their own, keeping the
+// blank lines before them but the first, and reports whether there were any.
+func (p *printer) commentsBefore(line int, first bool) bool {
+	printed := false
+	for len(p.comments) > 0 && p.comments[0].Line < line {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if !first && p.blank(c.Line-1) {
+			p.write("\n")
+		}
+		first = false
+		p.writeIndent()
+		p.write(c.Text)
+		p.write("\n")
+		p.at(c.Pos)
+		printed = true
+	}
+	return printed
+}
+
+// blank reports whether the source line n is empty.
+func (p *printer) blank(n int) bool {
+	return n >= 1 && n <= len(p.lines) && strings.TrimSpace(p.lines[n-1]) == ""
+}
+
+// hasComments reports whether a comment not printed yet lies before the position end.
+func (p *printer) hasComments(end ast.Pos) bool {
+	if len(p.comments) == 0 {
+		return false
+	}
+	c := p.comments[0].Pos
+	return c.Line < end.Line || c.Line == end.Line && c.Column < end.Column
+}
+
+// start returns the position of the first token of e, which for operators and suffixes lies in
+// their leftmost operand.
+func start(e ast.Expr) ast.Pos {
+	for {
+		switch n := e.(type) {
+		case *ast.Binary:
+			e = n.Left
+		case *ast.Call:
+			e = n.Func
+		case *ast.Index:
+			e = n.X
+		case *ast.Selector:
+			e = n.X
+		case *ast.Propagate:
+			e = n.X
+		default:
+			return ast.PosOf(e)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package format
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+var sourceTests = []struct {
+	name string
+	src  string
+	want string
+}{
+	{"spaces", "x=1\ny = x+2*3\nm = {\"a\": 1,  \"b\": 2}\n", "x = 1\ny = x + 2 * 3\nm = {\"a\": 1, \"b\": 2}\n"},
+	{"indent", "if x > 0 {\n  print(x)\n}\n", "if x > 0 {\n    print(x)\n}\n"},
+	{"blank lines", "print(1)   \n\n\n\nprint(2)\nfor k in keys(m) {\n\n\n    print(k)\n}\n", "print(1)\n\nprint(2)\nfor k in keys(m) {\n    print(k)\n}\n"},
+	{"trailing comments", "x=1   // trailing\ny = x+2 // two\n", "x = 1 // trailing\ny = x + 2 // two\n"},
+	{"comment after brace", "fn f(a: float): float { // after brace\n  return a\n}\n", "fn f(a: float): float { // after brace\n    return a\n}\n"},
+	{"comment after condition", "if x > 0 { // positive\n    print(x)\n}\n", "if x > 0 { // positive\n    print(x)\n}\n"},
+	{"header and footer comments", "// header\n\nx = 1\n// footer\n", "// header\n\nx = 1\n// footer\n"},
+	{
+		"comments in a list",
+		"xs = [ // numbers\n    1, // one\n    2,\n    // before three\n    3,\n]\n",
+		"xs = [ // numbers\n    1, // one\n    2,\n    // before three\n    3,\n]\n",
+	},
+	{"comments in a map", "m = {\n    \"a\": 1, // a\n    \"b\": 2,\n}\n", "m = {\n    \"a\": 1, // a\n    \"b\": 2,\n}\n"},
+	{
+		"comments in match arms",
+		"print(match e {\n    A => 1 // one\n    // lead\n    B(x) => x\n})\n",
+		"print(match e {\n    A => 1 // one\n    // lead\n    B(x) => x\n})\n",
+	},
+	{"comments in an enum", "enum E {\n    A // first\n    // lead\n    B(x: float)\n}\n", "enum E {\n    A // first\n    // lead\n    B(x: float)\n}\n"},
+	{"comments in a type", "type P {\n    x: float // the x\n    y: float\n}\n", "type P {\n    x: float // the x\n    y: float\n}\n"},
+	{"parentheses of a right operand", "x = 1 - (2 - 3)\nr = a - (b + c)\nt = a + (b + c)\n", "x = 1 - (2 - 3)\nr = a - (b + c)\nt = a + (b + c)\n"},
+	{"parentheses of a left operand", "y = (1 - 2) - 3\ns = (a + b) + c\n", "y = 1 - 2 - 3\ns = a + b + c\n"},
+	{"parentheses by precedence", "w = (a + b) * c\nv = a * (b + c)\nu = a + (b * c)\n", "w = (a + b) * c\nv = a * (b + c)\nu = a + b * c\n"},
+	{"parentheses of an operand of a unary operator", "z = -(a + b)\nq = !(a && b)\nn = -(a)\n", "z = -(a + b)\nq = !(a && b)\nn = -a\n"},
+}
+
+func TestSource(t *testing.T) {
+	for _, tt := range sourceTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Source([]byte(tt.src))
+			if err != nil {
+				t.Fatalf("Source() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Source() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceIsIdempotent(t *testing.T) {
+	srcs := make(map[string]string)
+	for _, tt := range sourceTests {
+		srcs[tt.name] = tt.src
+	}
+	files, err := filepath.Glob(filepath.Join("..", "examples", "*.pede"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if filepath.Base(file) == "boom.pede" {
+			continue // does not lex, on purpose
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs[filepath.Base(file)] = string(src)
+	}
+	for name, src := range srcs {
+		t.Run(name, func(t *testing.T) {
+			once, err := Source([]byte(src))
+			if err != nil {
+				t.Fatalf("Source() error = %v", err)
+			}
+			twice, err := Source(once)
+			if err != nil {
+				t.Fatalf("Source() of its own output error = %v", err)
+			}
+			if string(twice) != string(once) {
+				t.Errorf("Source() is not idempotent:\n%s\nformats again to\n%s", once, twice)
+			}
+		})
+	}
+}
+
+func TestSourceRejects(t *testing.T) {
+	tests := []struct {
+		src  string
+		code string
+	}{
+		{"x = @\n", diag.CodeUnknownChar},
+		{"x = \"a\n", diag.CodeUnterminatedString},
+		{"x = (1\n", diag.CodeSyntax},
+	}
+	for _, tt := range tests {
+		_, err := Source([]byte(tt.src))
+		var d *diag.Diagnostic
+		if !errors.As(err, &d) || d.Code != tt.code {
+			t.Errorf("Source(%q) error = %v, want a %s diagnostic", tt.src, err, tt.code)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/parser"
+)
+
+// Precedences of the expressions that are no binary operators, above every binary operator
+const (
+	precUnary   = 100 // -x and !x
+	precPostfix = 101 // x.f, x[i], x(args) and x?
+)
+
+// stmts prints the statements of a block or program, one per line.
+func (p *printer) stmts(stmts []ast.Stmt) {
+	for i, s := range stmts {
+		p.leading(ast.PosOf(s).Line, i == 0)
+		p.stmt(s)
+		p.newline()
+	}
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	p.at(ast.PosOf(stmt))
+	switch s := stmt.(type) {
+	case *ast.Assignment:
+		if s.Let {
+			p.write("let ")
+		}
+		p.binding(s.Name, s.Type, s.Expr)
+	case *ast.PrintStmt:
+		p.write("print(")
+		p.expr(s.Expr, 0)
+		p.write(")")
+	case *ast.IndexAssign:
+		p.expr(s.Target, 0)
+		p.write(" = ")
+		p.expr(s.Expr, 0)
+	case *ast.FieldAssign:
+		p.expr(s.Target, 0)
+		p.write(" = ")
+		p.expr(s.Expr, 0)
+	case *ast.CompoundAssign:
+		p.expr(s.Target, 0)
+		if s.IncDec {
+			p.write(s.Op + s.Op)
+			break
+		}
+		p.write(" " + s.Op + "= ")
+		p.expr(s.Expr, 0)
+	case *ast.ReturnStmt:
+		p.write("return")
+		if s.Value != nil {
+			p.write(" ")
+			p.expr(s.Value, 0)
+		}
+	case *ast.ExprStmt:
+		p.expr(s.Expr, 0)
+	case *ast.IfStmt:
+		p.ifStmt(s)
+	case *ast.WhileStmt:
+		p.write("while ")
+		p.headerExpr(s.Cond)
+		p.write(" ")
+		p.block(s.Body)
+	case *ast.ForStmt:
+		p.write("for " + s.Var + " in ")
+		p.headerExpr(s.Iter)
+		p.write(" ")
+		p.block(s.Body)
+	case *ast.TryStmt:
+		p.write("try ")
+		p.block(s.Body)
+		p.write(" catch ")
+		if s.Var != "" {
+			p.write(s.Var + " ")
+		}
+		p.block(s.Catch)
+	case *ast.ImportDecl:
+		p.write("import ")
+		if s.Name != s.Path[strings.LastIndex(s.Path, "/")+1:] {
+			p.write(s.Name + " ")
+		}
+		p.write(`"` + s.Path + `"`)
+	case *ast.ConstDecl:
+		if s.Pub {
+			p.write("pub ")
+		}
+		p.write("const ")
+		p.binding(s.Name, s.Type, s.Value)
+	case *ast.FuncDecl:
+		p.funcDecl(s)
+	case *ast.TypeDecl:
+		if s.Pub {
+			p.write("pub ")
+		}
+		p.write("type " + s.Name + " ")
+		p.fieldList(s.Fields, ast.PosOf(s).Line)
+	case *ast.EnumDecl:
+		p.enumDecl(s)
+	case *ast.TestDecl:
+		p.write(`test "` + s.Name + `" `)
+		p.block(s.Body)
+	case *ast.BenchDecl:
+		p.write(`bench "` + s.Name + `" `)
+		p.block(s.Body)
+	}
+}
+
+// binding prints `name [: Type] = expr`.
+func (p *printer) binding(name string, typ ast.TypeExpr, expr ast.Expr) {
+	p.write(name)
+	if typ != nil {
+		p.write(": " + Type(typ))
+	}
+	p.write(" = ")
+	p.expr(expr, 0)
+}
+
+func (p *printer) ifStmt(s *ast.IfStmt) {
+	p.write("if ")
+	p.headerExpr(s.Cond)
+	p.write(" ")
+	p.block(s.Then)
+	switch e := s.Else.(type) {
+	case *ast.IfStmt:
+		p.write(" else ")
+		p.at(e.Pos)
+		p.ifStmt(e)
+	case *ast.Block:
+		p.write(" else ")
+		p.block(e)
+	}
+}
+
+func (p *printer) funcDecl(s *ast.FuncDecl) {
+	if s.Pub {
+		p.write("pub ")
+	}
+	if s.Export {
+		p.write("export ")
+	}
+	if s.Extern {
+		p.write("extern ")
+	}
+	p.write("fn " + s.Name)
+	p.params(s.Params)
+	if s.Result != nil {
+		p.write(": " + Type(s.Result))
+	}
+	if s.Body != nil {
+		p.write(" ")
+		p.block(s.Body)
+	}
+}
+
+// params prints a parenthesized parameter list, whose types may be left out.
+func (p *printer) params(params []ast.Field) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Name)
+		if param.Type != nil {
+			p.write(": " + Type(param.Type))
+		}
+	}
+	p.write(")")
+}
+
+// fieldList prints the fields of a struct type, on one line if the source has them all on the
+// line of the declaration.
+func (p *printer) fieldList(fields []ast.Field, line int) {
+	if len(fields) == 0 {
+		p.write("{}")
+		return
+	}
+	if fields[len(fields)-1].Line == line {
+		p.write("{ ")
+		for i, f := range fields {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(f.Name + ": " + Type(f.Type))
+		}
+		p.write(" }")
+		return
+	}
+	p.write("{")
+	p.newline()
+	p.indent++
+	for i, f := range fields {
+		p.leading(f.Line, i == 0)
+		p.at(f.Pos)
+		p.write(f.Name + ": " + Type(f.Type))
+		p.newline()
+	}
+	p.indent--
+	p.writeIndent()
+	p.write("}")
+}
+
+func (p *printer) enumDecl(s *ast.EnumDecl) {
+	if s.Pub {
+		p.write("pub ")
+	}
+	p.write("enum " + s.Name + " ")
+	if len(s.Variants) == 0 {
+		p.write("{}")
+		return
+	}
+	if s.Variants[len(s.Variants)-1].Line == s.Line {
+		p.write("{ ")
+		for i, v := range s.Variants {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.write(variantString(v))
+		}
+		p.write(" }")
+		return
+	}
+	p.write("{")
+	p.newline()
+	p.indent++
+	for i, v := range s.Variants {
+		p.leading(v.Line, i == 0)
+		p.at(v.Pos)
+		p.write(variantString(v))
+		p.newline()
+	}
+	p.indent--
+	p.writeIndent()
+	p.write("}")
+}
+
+// variantString returns an enum variant with its fields: Name(field, field: Type)
+func variantString(v ast.Variant) string {
+	if len(v.Fields) == 0 {
+		return v.Name
+	}
+	fields := make([]string, len(v.Fields))
+	for i, f := range v.Fields {
+		fields[i] = f.Name
+		if f.Type != nil {
+			fields[i] += ": " + Type(f.Type)
+		}
+	}
+	return v.Name + "(" + strings.Join(fields, ", ") + ")"
+}
+
+// block prints a block, which stays on one line if the source has it on one line with at most one
+// statement and no comments.
+func (p *printer) block(b *ast.Block) {
+	defer p.setHeader(false)()
+	p.at(b.Pos)
+	if b.End.Line == b.Line && len(b.Stmts) <= 1 && !p.hasComments(b.End) || len(b.Stmts) == 0 && !p.hasComments(b.End) {
+		if len(b.Stmts) == 0 {
+			p.write("{}")
+		} else {
+			p.write("{ ")
+			p.stmt(b.Stmts[0])
+			p.write(" }")
+		}
+		p.at(b.End)
+		return
+	}
+	p.write("{")
+	p.newline()
+	p.indent++
+	p.stmts(b.Stmts)
+	p.commentsBefore(b.End.Line, len(b.Stmts) == 0)
+	p.indent--
+	p.writeIndent()
+	p.write("}")
+	p.at(b.End)
+}
+
+// setHeader sets whether an if, while, for or match header is printed, where struct literals
+// need parentheses, and returns a func restoring the previous setting.
+func (p *printer) setHeader(header bool) func() {
+	old := p.header
+	p.header = header
+	return func() { p.header = old }
+}
+
+// headerExpr prints the expression in the header of an if, while, for or match.
+func (p *printer) headerExpr(e ast.Expr) {
+	defer p.setHeader(true)()
+	p.expr(e, 0)
+}
+
+// expr prints e, in parentheses if its precedence is lower than prec.
+func (p *printer) expr(e ast.Expr, prec int) {
+	p.at(start(e))
+	switch n := e.(type) {
+	case *ast.Number:
+		p.write(strconv.FormatFloat(n.Value, 'f', -1, 64))
+	case *ast.String:
+		p.write(`"` + n.Value + `"`)
+	case *ast.Bool:
+		p.write(strconv.FormatBool(n.Value))
+	case *ast.Variable:
+		p.write(n.Name)
+	case *ast.Binary:
+		level := parser.Precedence(n.Op)
+		if level < prec {
+			p.parens(e)
+			return
+		}
+		// Operators associate to the left, so a right operand of the same level needs parentheses
+		p.expr(n.Left, level)
+		p.at(n.Pos)
+		p.write(" " + n.Op + " ")
+		p.expr(n.Right, level+1)
+	case *ast.Unary:
+		if precUnary < prec {
+			p.parens(e)
+			return
+		}
+		p.write(n.Op)
+		// Two minus signs in a row would read as the -- operator
+		if inner, ok := n.Operand.(*ast.Unary); ok && inner.Op == n.Op && n.Op == "-" {
+			p.parens(inner)
+			return
+		}
+		p.expr(n.Operand, precUnary)
+	case *ast.Selector:
+		p.expr(n.X, precPostfix)
+		p.write("." + n.Name)
+	case *ast.Index:
+		p.expr(n.X, precPostfix)
+		p.write("[")
+		restore := p.setHeader(false)
+		p.expr(n.Index, 0)
+		restore()
+		p.write("]")
+	case *ast.Call:
+		p.expr(n.Func, precPostfix)
+		p.list("(", ")", len(n.Args), start(n).Line, func(i int) ast.Pos { return start(n.Args[i]) }, func(i int) {
+			p.expr(n.Args[i], 0)
+		})
+	case *ast.Propagate:
+		p.expr(n.X, precPostfix)
+		p.write("?")
+	case *ast.ListLit:
+		p.list("[", "]", len(n.Elems), n.Line, func(i int) ast.Pos { return start(n.Elems[i]) }, func(i int) {
+			p.expr(n.Elems[i], 0)
+		})
+	case *ast.MapLit:
+		p.list("{", "}", len(n.Entries), n.Line, func(i int) ast.Pos { return start(n.Entries[i].Key) }, func(i int) {
+			p.expr(n.Entries[i].Key, 0)
+			p.write(": ")
+			p.expr(n.Entries[i].Value, 0)
+		})
+	case *ast.StructLit:
+		if p.header {
+			// `Name {` would open the body of the statement
+			p.parens(e)
+			return
+		}
+		if n.Module != "" {
+			p.write(n.Module + ".")
+		}
+		p.write(n.Type)
+		p.list("{", "}", len(n.Fields), n.Line, func(i int) ast.Pos { return n.Fields[i].Pos }, func(i int) {
+			p.write(n.Fields[i].Name + ": ")
+			p.expr(n.Fields[i].Value, 0)
+		})
+	case *ast.FuncLit:
+		p.write("fn")
+		p.params(n.Params)
+		if n.Result != nil {
+			p.write(": " + Type(n.Result))
+		}
+		p.write(" ")
+		p.block(n.Body)
+	case *ast.Match:
+		p.match(n)
+	}
+}
+
+// parens prints e in parentheses, where struct literals are allowed again.
+func (p *printer) parens(e ast.Expr) {
+	defer p.setHeader(false)()
+	p.write("(")
+	p.expr(e, 0)
+	p.write(")")
+}
+
+// list prints n comma-separated items between the brackets open and close. The list is printed
+// one item per line, with a trailing comma, if the source starts its first item on a later line
+// than the opening bracket on line; otherwise it stays on one line.
+func (p *printer) list(open, close string, n, line int, pos func(i int) ast.Pos, item func(i int)) {
+	defer p.setHeader(false)()
+	p.write(open)
+	if n == 0 || pos(0).Line == line {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				p.write(", ")
+			}
+			item(i)
+		}
+		p.write(close)
+		return
+	}
+	p.newline()
+	p.indent++
+	for i := 0; i < n; i++ {
+		p.leading(pos(i).Line, i == 0)
+		item(i)
+		p.write(",")
+		p.newline()
+	}
+	p.indent--
+	p.writeIndent()
+	p.write(close)
+}
+
+// match prints a match, one arm per line unless the source has all of it on one line.
+func (p *printer) match(m *ast.Match) {
+	p.write("match ")
+	p.headerExpr(m.Subject)
+	p.write(" ")
+	defer p.setHeader(false)()
+	if len(m.Arms) == 0 {
+		p.write("{}")
+		p.at(m.End)
+		return
+	}
+	if m.End.Line == m.Line && !p.hasComments(m.End) {
+		p.write("{ ")
+		for i, arm := range m.Arms {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.arm(arm)
+		}
+		p.write(" }")
+		p.at(m.End)
+		return
+	}
+	p.write("{")
+	p.newline()
+	p.indent++
+	for i, arm := range m.Arms {
+		p.leading(arm.Line, i == 0)
+		p.arm(arm)
+		p.newline()
+	}
+	p.commentsBefore(m.End.Line, false)
+	p.indent--
+	p.writeIndent()
+	p.write("}")
+	p.at(m.End)
+}
+
+// arm prints a match arm: Variant(a, b) => body
+func (p *printer) arm(arm *ast.MatchArm) {
+	p.at(arm.Pos)
+	p.write(arm.Variant)
+	if len(arm.Bindings) > 0 {
+		p.write("(" + strings.Join(arm.Bindings, ", ") + ")")
+	}
+	p.write(" => ")
+	if arm.Block != nil {
+		p.block(arm.Block)
+		return
+	}
+	p.expr(arm.Expr, 0)
+}
+
+// Type returns a type expression as written in the source.
+func Type(t ast.TypeExpr) string {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		if t.Module != "" {
+			return t.Module + "." + t.Name
+		}
+		return t.Name
+	case *ast.ListType:
+		return "[" + Type(t.Elem) + "]"
+	case *ast.MapType:
+		return "{" + Type(t.Key) + ": " + Type(t.Value) + "}"
+	case *ast.ResultType:
+		return "!" + Type(t.Value)
+	case *ast.FuncType:
+		params := make([]string, len(t.Params))
+		for i, param := range t.Params {
+			params[i] = Type(param)
+		}
+		s := "fn(" + strings.Join(params, ", ") + ")"
+		if t.Result != nil {
+			s += ": " + Type(t.Result)
+		}
+		return s
+	}
+	return ""
+}
@@ -1,49 +1,146 @@
 package lexer
 
 import (
-	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"unicode"
+
+	"github.com/engpetarmarinov/pede/diag"
 )
 
 type TokenType string
 
 const (
-	TokenEOF     = "EOF"
-	TokenIdent   = "IDENT"
-	TokenNumber  = "NUMBER"
-	TokenPlus    = "+"
-	TokenStar    = "*"
-	TokenEqual   = "="
-	TokenPrint   = "PRINT"
-	TokenString  = "STRING"
-	TokenUnknown = "UNKNOWN"
-	TokenComment = "//"
-	TokenLParen  = "LPAREN"
-	TokenRParen  = "RPAREN"
-	TokenNewline = "NEWLINE"
+	TokenEOF       = "EOF"
+	TokenIdent     = "IDENT"
+	TokenNumber    = "NUMBER"
+	TokenPlus      = "+"
+	TokenMinus     = "-"
+	TokenStar      = "*"
+	TokenSlash     = "/"
+	TokenEqual     = "="
+	TokenEqEq      = "=="
+	TokenNotEq     = "!="
+	TokenLess      = "<"
+	TokenLessEq    = "<="
+	TokenGreater   = ">"
+	TokenGreaterEq = ">="
+	TokenAnd       = "&&"
+	TokenOr        = "||"
+	TokenBang      = "!"
+	TokenPrint     = "PRINT"
+	TokenTrue      = "TRUE"
+	TokenFalse     = "FALSE"
+	TokenIf        = "IF"
+	TokenElse      = "ELSE"
+	TokenWhile     = "WHILE"
+	TokenFor       = "FOR"
+	TokenIn        = "IN"
+	TokenTypeDecl  = "TYPE"
+	TokenEnum      = "ENUM"
+	TokenMatch     = "MATCH"
+	TokenFn        = "FN"
+	TokenReturn    = "RETURN"
+	TokenImport    = "IMPORT"
+	TokenPub       = "PUB"
+	TokenExtern    = "EXTERN"
+	TokenExport    = "EXPORT"
+	TokenConst     = "CONST"
+	TokenLet       = "LET"
+	TokenTry       = "TRY"
+	TokenCatch     = "CATCH"
+	TokenTest      = "TEST"
+	TokenBench     = "BENCH"
+	TokenString    = "STRING"
+	TokenUnknown   = "UNKNOWN"
+	TokenComment   = "//"
+	TokenLParen    = "LPAREN"
+	TokenRParen    = "RPAREN"
+	TokenLBrace    = "LBRACE"
+	TokenRBrace    = "RBRACE"
+	TokenLBracket  = "LBRACKET"
+	TokenRBracket  = "RBRACKET"
+	TokenComma     = ","
+	TokenColon     = ":"
+	TokenDot       = "."
+	TokenArrow     = "=>"
+	TokenPlusEq    = "+="
+	TokenMinusEq   = "-="
+	TokenStarEq    = "*="
+	TokenSlashEq   = "/="
+	TokenInc       = "++"
+	TokenDec       = "--"
+	TokenQuestion  = "?"
+	TokenNewline   = "NEWLINE"
 )
 
+// keywords maps reserved words to their token types.
+var keywords = map[string]TokenType{
+	"print":  TokenPrint,
+	"true":   TokenTrue,
+	"false":  TokenFalse,
+	"if":     TokenIf,
+	"else":   TokenElse,
+	"while":  TokenWhile,
+	"for":    TokenFor,
+	"in":     TokenIn,
+	"type":   TokenTypeDecl,
+	"enum":   TokenEnum,
+	"match":  TokenMatch,
+	"fn":     TokenFn,
+	"return": TokenReturn,
+	"import": TokenImport,
+	"pub":    TokenPub,
+	"extern": TokenExtern,
+	"export": TokenExport,
+	"const":  TokenConst,
+	"let":    TokenLet,
+	"try":    TokenTry,
+	"catch":  TokenCatch,
+	"test":   TokenTest,
+	"bench":  TokenBench,
+}
+
+// Keywords returns the reserved words in alphabetical order.
+func Keywords() []string {
+	return slices.Sorted(maps.Keys(keywords))
+}
+
+// operators lists the operator tokens, longest first so that "==" wins over "=".
+var operators = []TokenType{
+	TokenArrow, TokenEqEq, TokenNotEq, TokenLessEq, TokenGreaterEq, TokenAnd, TokenOr,
+	TokenPlusEq, TokenMinusEq, TokenStarEq, TokenSlashEq, TokenInc, TokenDec,
+	TokenPlus, TokenMinus, TokenStar, TokenSlash, TokenEqual, TokenLess, TokenGreater, TokenBang,
+	TokenComma, TokenColon, TokenDot, TokenQuestion,
+}
+
 type Token struct {
 	Type  TokenType
 	Value string
+	Line  int // 1-based line of the first character of the token
+	Col   int // 1-based column of the first character of the token
+	End   int // 1-based column just past the last character of the token
 }
 
-type Error struct {
-	Message    string
-	Line       int
-	Column     int
-	LineSource string
-}
-
-func (e *Error) Error() string {
-	pointer := ""
-	for i := 0; i < e.Column-1; i++ {
-		pointer += " "
+// String describes the token for error messages, such as end of line, name x or '+'.
+func (t Token) String() string {
+	switch t.Type {
+	case TokenEOF:
+		return "end of file"
+	case TokenNewline:
+		return "end of line"
+	case TokenIdent:
+		return "name " + t.Value
+	case TokenNumber:
+		return "number " + t.Value
+	case TokenString:
+		return "string " + strconv.Quote(t.Value)
+	case TokenComment:
+		return "comment"
 	}
-	pointer += "^"
-	return fmt.Sprintf(`%s at Line %d, column %d: 
-%s
-%s`, e.Message, e.Line, e.Column, e.LineSource, pointer)
+	return "'" + t.Value + "'"
 }
 
 type Lexer struct {
@@ -58,6 +155,18 @@ func NewLexer(input string) *Lexer {
 	return &Lexer{input: []rune(input), Line: 1, Col: 1, lineStart: 0}
 }
 
+// Check reads every token of source and returns the first lexical error, so that lexical errors
+// can be reported before parsing starts.
+func Check(source string) error {
+	lx := NewLexer(source)
+	for {
+		tok, err := lx.Next()
+		if err != nil || tok.Type == TokenEOF {
+			return err
+		}
+	}
+}
+
 func (l *Lexer) CurrentLineSource() string {
 	start := l.lineStart
 	end := l.pos
@@ -67,16 +176,26 @@ func (l *Lexer) CurrentLineSource() string {
 	return string(l.input[start:end])
 }
 
+// LineSource returns the source text of the given 1-based line, or "" if it is out of range.
+func (l *Lexer) LineSource(line int) string {
+	lines := strings.Split(string(l.input), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return lines[line-1]
+}
+
 // Next returns the next token, or an error if an unknown or invalid token is encountered.
 func (l *Lexer) Next() (Token, error) {
 	for l.pos < len(l.input) {
 		ch := l.input[l.pos]
 		if ch == '\n' {
+			tok := Token{Type: TokenNewline, Value: "\n", Line: l.Line, Col: l.Col, End: l.Col + 1}
 			l.Line++
 			l.Col = 1
 			l.lineStart = l.pos + 1
 			l.pos++
-			return Token{Type: TokenNewline, Value: "\n"}, nil
+			return tok, nil
 		}
 		if unicode.IsSpace(ch) {
 			l.Col++
@@ -85,6 +204,14 @@ func (l *Lexer) Next() (Token, error) {
 		}
 		break
 	}
+	startLine, startCol := l.Line, l.Col
+	tok, err := l.scan()
+	tok.Line, tok.Col, tok.End = startLine, startCol, l.Col
+	return tok, err
+}
+
+// scan reads the token starting at the current position, which is never whitespace.
+func (l *Lexer) scan() (Token, error) {
 	if l.pos >= len(l.input) {
 		return Token{Type: TokenEOF}, nil
 	}
@@ -99,30 +226,27 @@ func (l *Lexer) Next() (Token, error) {
 			l.pos++
 			l.Col++
 		}
+		// A fractional part needs a digit after the dot
+		if l.pos+1 < len(l.input) && l.input[l.pos] == '.' && unicode.IsDigit(l.input[l.pos+1]) {
+			l.pos++
+			l.Col++
+			for l.pos < len(l.input) && unicode.IsDigit(l.input[l.pos]) {
+				l.pos++
+				l.Col++
+			}
+		}
 		return Token{Type: TokenNumber, Value: string(l.input[start:l.pos])}, nil
-	case unicode.IsLetter(ch):
+	case unicode.IsLetter(ch) || ch == '_':
 		start := l.pos
-		for l.pos < len(l.input) && unicode.IsLetter(l.input[l.pos]) {
+		for l.pos < len(l.input) && isIdentRune(l.input[l.pos]) {
 			l.pos++
 			l.Col++
 		}
 		word := string(l.input[start:l.pos])
-		if word == "print" {
-			return Token{Type: TokenPrint, Value: word}, nil
+		if tt, ok := keywords[word]; ok {
+			return Token{Type: tt, Value: word}, nil
 		}
 		return Token{Type: TokenIdent, Value: word}, nil
-	case ch == '+':
-		l.pos++
-		l.Col++
-		return Token{Type: TokenPlus, Value: "+"}, nil
-	case ch == '*':
-		l.pos++
-		l.Col++
-		return Token{Type: TokenStar, Value: "*"}, nil
-	case ch == '=':
-		l.pos++
-		l.Col++
-		return Token{Type: TokenEqual, Value: "="}, nil
 	case ch == '"':
 		l.pos++ // skip opening quote
 		l.Col++
@@ -135,17 +259,19 @@ func (l *Lexer) Next() (Token, error) {
 			l.Col++
 		}
 		if l.pos >= len(l.input) || l.input[l.pos] != '"' {
-			return Token{}, &Error{
-				Message:    "unterminated string",
-				Line:       l.Line,
-				Column:     startCol,
-				LineSource: l.CurrentLineSource(),
-			}
+			return Token{}, l.errorf(diag.CodeUnterminatedString, startCol, l.Col, "unterminated string")
 		}
 		str := string(l.input[start:l.pos])
 		l.pos++ // skip closing quote
 		l.Col++
 		return Token{Type: TokenString, Value: str}, nil
+	case ch == '/' && l.pos+1 < len(l.input) && l.input[l.pos+1] == '/':
+		start := l.pos
+		for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+			l.pos++
+			l.Col++
+		}
+		return Token{Type: TokenComment, Value: strings.TrimRightFunc(string(l.input[start:l.pos]), unicode.IsSpace)}, nil
 	case ch == '(': // support left paren
 		l.pos++
 		l.Col++
@@ -154,14 +280,59 @@ func (l *Lexer) Next() (Token, error) {
 		l.pos++
 		l.Col++
 		return Token{Type: TokenRParen, Value: ")"}, nil
-	default:
-		unknownChar := l.input[l.pos]
-		err := &Error{
-			Message:    fmt.Sprintf("unknown character '%c'", unknownChar),
-			Line:       l.Line,
-			Column:     startCol,
-			LineSource: l.CurrentLineSource(),
+	case ch == '{':
+		l.pos++
+		l.Col++
+		return Token{Type: TokenLBrace, Value: "{"}, nil
+	case ch == '}':
+		l.pos++
+		l.Col++
+		return Token{Type: TokenRBrace, Value: "}"}, nil
+	case ch == '[':
+		l.pos++
+		l.Col++
+		return Token{Type: TokenLBracket, Value: "["}, nil
+	case ch == ']':
+		l.pos++
+		l.Col++
+		return Token{Type: TokenRBracket, Value: "]"}, nil
+	}
+
+	for _, op := range operators {
+		if l.hasPrefix(string(op)) {
+			n := len([]rune(string(op)))
+			l.pos += n
+			l.Col += n
+			return Token{Type: op, Value: string(op)}, nil
+		}
+	}
+
+	unknownChar := l.input[l.pos]
+	return Token{}, l.errorf(diag.CodeUnknownChar, startCol, startCol+1, "unknown character '%c'", unknownChar)
+}
+
+// errorf returns an error diagnostic spanning the columns [start, end) of the current line. The
+// file is filled in by the caller, which knows it.
+func (l *Lexer) errorf(code string, start, end int, format string, args ...any) error {
+	span := diag.Span{Start: diag.Pos{Line: l.Line, Column: start}, End: diag.Pos{Line: l.Line, Column: end}}
+	return diag.Errorf(code, span, l.CurrentLineSource(), format, args...)
+}
+
+// isIdentRune reports whether r may appear in an identifier after its first character.
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// hasPrefix reports whether the input at the current position starts with s.
+func (l *Lexer) hasPrefix(s string) bool {
+	rs := []rune(s)
+	if l.pos+len(rs) > len(l.input) {
+		return false
+	}
+	for i, r := range rs {
+		if l.input[l.pos+i] != r {
+			return false
 		}
-		return Token{}, err
 	}
+	return true
 }
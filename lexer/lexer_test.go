@@ -0,0 +1,39 @@
+package lexer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+func TestCheck(t *testing.T) {
+	if err := Check("x = 1 // one\nprint(\"a\")\n"); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	err := Check("x = 1\ny = @\n")
+	var d *diag.Diagnostic
+	if !errors.As(err, &d) || d.Span.Start.Line != 2 || d.Span.Start.Column != 5 {
+		t.Errorf("Check() error = %v, want an unknown character at 2:5", err)
+	}
+}
+
+func TestTokenString(t *testing.T) {
+	tests := []struct {
+		tok  Token
+		want string
+	}{
+		{Token{Type: TokenEOF}, "end of file"},
+		{Token{Type: TokenNewline, Value: "\n"}, "end of line"},
+		{Token{Type: TokenIdent, Value: "x"}, "name x"},
+		{Token{Type: TokenNumber, Value: "1.5"}, "number 1.5"},
+		{Token{Type: TokenString, Value: "a b"}, `string "a b"`},
+		{Token{Type: TokenFn, Value: "fn"}, "'fn'"},
+		{Token{Type: TokenRParen, Value: ")"}, "')'"},
+	}
+	for _, tt := range tests {
+		if got := tt.tok.String(); got != tt.want {
+			t.Errorf("%#v.String() = %q, want %q", tt.tok, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package lint
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/sema"
+)
+
+// Check runs the lint checks over root and the modules it imports, which info describes, and
+// returns the warnings found, imported modules first.
+func Check(root *ast.Module, info *sema.Info) []*diag.Diagnostic {
+	var warnings []*diag.Diagnostic
+	for _, mod := range modules(root) {
+		l := &linter{mod: mod, lines: strings.Split(mod.Source, "\n"), info: info}
+		l.checkVars()
+		ast.Inspect(mod.Program, l.visit)
+		slices.SortStableFunc(l.warnings, func(a, b *diag.Diagnostic) int {
+			return cmp.Or(cmp.Compare(a.Span.Start.Line, b.Span.Start.Line), cmp.Compare(a.Span.Start.Column, b.Span.Start.Column))
+		})
+		warnings = append(warnings, l.warnings...)
+	}
+	return warnings
+}
+
+// linter checks one module.
+type linter struct {
+	mod      *ast.Module
+	lines    []string // lines of the preprocessed source
+	info     *sema.Info
+	warnings []*diag.Diagnostic
+}
+
+// warnf reports a warning spanning the word at pos.
+func (l *linter) warnf(pos ast.Pos, code, format string, args ...any) *diag.Diagnostic {
+	src := l.line(pos.Line)
+	span := diag.WordSpan(l.mod.File, diag.Pos{Line: pos.Line, Column: pos.Column}, src)
+	return l.report(code, span, src, format, args...)
+}
+
+// warnLinef reports a warning spanning the rest of the line from pos.
+func (l *linter) warnLinef(pos ast.Pos, code, format string, args ...any) *diag.Diagnostic {
+	src := l.line(pos.Line)
+	span := diag.Span{
+		File:  l.mod.File,
+		Start: diag.Pos{Line: pos.Line, Column: pos.Column},
+		End:   diag.Pos{Line: pos.Line, Column: utf8.RuneCountInString(src) + 1},
+	}
+	return l.report(code, span, src, format, args...)
+}
+
+func (l *linter) report(code string, span diag.Span, src, format string, args ...any) *diag.Diagnostic {
+	d := diag.Errorf(code, span, src, format, args...)
+	d.Severity = diag.Warning
+	l.warnings = append(l.warnings, d)
+	return d
+}
+
+func (l *linter) line(n int) string {
+	if n >= 1 && n <= len(l.lines) {
+		return l.lines[n-1]
+	}
+	return ""
+}
+
+// visit runs the checks of single nodes; it is called by ast.Inspect for every node of the module.
+func (l *linter) visit(n any) bool {
+	switch n := n.(type) {
+	case *ast.Program:
+		l.checkUnreachable(n.Stmts)
+	case *ast.Block:
+		l.checkUnreachable(n.Stmts)
+	case *ast.IfStmt:
+		l.checkCondition(n.Cond, "if", false)
+	case *ast.WhileStmt:
+		l.checkCondition(n.Cond, "while", true)
+	case *ast.Assignment:
+		if v := l.info.Vars[n]; v != nil && v.Pos != n.Pos {
+			if x, ok := n.Expr.(*ast.Variable); ok && l.info.Vars[x] == v {
+				l.warnf(n.Pos, diag.CodeSelfAssign, "%s is assigned to itself", n.Name)
+			}
+		}
+	case *ast.FieldAssign:
+		if l.same(n.Target, n.Expr) {
+			l.warnf(n.Pos, diag.CodeSelfAssign, "field %s is assigned to itself", n.Target.Name)
+		}
+	case *ast.IndexAssign:
+		if l.same(n.Target, n.Expr) {
+			l.warnf(n.Pos, diag.CodeSelfAssign, "element is assigned to itself")
+		}
+	}
+	return true
+}
+
+// checkVars reports the variables declared by assignments that are never read. Variables whose
+// name starts with an underscore are exempt, as are parameters and the variables of for loops,
+// catch clauses and match arms. Compound assignments such as x += 1 are no reads.
+func (l *linter) checkVars() {
+	var decls []*ast.Assignment
+	reads := make(map[*sema.Var]int)
+	last := make(map[*sema.Var]ast.Stmt)     // the last assignment or compound assignment of a variable
+	compound := make(map[*ast.Variable]bool) // targets of compound assignments, which are no reads
+	ast.Inspect(l.mod.Program, func(n any) bool {
+		switch n := n.(type) {
+		case *ast.Assignment:
+			v := l.info.Vars[n]
+			if v == nil {
+				break
+			}
+			if v.Pos == n.Pos {
+				decls = append(decls, n)
+			} else {
+				last[v] = n
+			}
+		case *ast.CompoundAssign:
+			if x, ok := n.Target.(*ast.Variable); ok {
+				compound[x] = true
+				if v := l.info.Vars[x]; v != nil {
+					last[v] = n
+				}
+			}
+		case *ast.Variable:
+			if v := l.info.Vars[n]; v != nil && !compound[n] {
+				reads[v]++
+			}
+		}
+		return true
+	})
+	for _, decl := range decls {
+		v := l.info.Vars[decl]
+		if reads[v] > 0 || strings.HasPrefix(v.Name, "_") {
+			continue
+		}
+		switch s := last[v].(type) {
+		case nil:
+			w := l.warnf(decl.Pos, diag.CodeUnusedVariable, "variable %s is never used", v.Name)
+			w.Notes = append(w.Notes, fmt.Sprintf("to keep it anyway, name it _%s", v.Name))
+		case *ast.CompoundAssign:
+			// x += 1 reads x only to compute its next value, which nothing reads
+			w := l.warnf(decl.Pos, diag.CodeUnreadVariable, "variable %s is updated but never read", v.Name)
+			w.Notes = append(w.Notes, fmt.Sprintf("it is last updated at line %d", s.Pos.Line))
+		case *ast.Assignment:
+			w := l.warnf(decl.Pos, diag.CodeUnreadVariable, "variable %s is assigned but never read", v.Name)
+			w.Notes = append(w.Notes, fmt.Sprintf("it is assigned again at line %d", s.Pos.Line))
+		}
+	}
+}
+
+// checkUnreachable reports the first statement of stmts that follows one always leaving the block,
+// such as a return. Declarations, which do not run, are skipped.
+func (l *linter) checkUnreachable(stmts []ast.Stmt) {
+	for i, stmt := range stmts {
+		if !l.info.Terminates(stmt) {
+			continue
+		}
+		for _, next := range stmts[i+1:] {
+			switch next.(type) {
+			case *ast.FuncDecl, *ast.TypeDecl, *ast.EnumDecl, *ast.ConstDecl, *ast.ImportDecl, *ast.TestDecl, *ast.BenchDecl:
+				continue
+			}
+			pos := ast.PosOf(next)
+			w := l.warnLinef(pos, diag.CodeUnreachable, "unreachable code")
+			w.Notes = append(w.Notes, fmt.Sprintf("the block is left at line %d", ast.PosOf(stmt).Line))
+			return
+		}
+		return
+	}
+}
+
+// checkCondition reports an if or while condition made only of literals, which the checker found
+// to be constant. Conditions that refer to named constants configure the program and are allowed,
+// as are always true conditions of loops, which run until a return when loop is set.
+func (l *linter) checkCondition(cond ast.Expr, keyword string, loop bool) {
+	v, ok := l.info.Values[cond]
+	if !ok || loop && v == true {
+		return
+	}
+	named := false
+	ast.Inspect(cond, func(n any) bool {
+		switch n.(type) {
+		case *ast.Variable, *ast.Selector:
+			named = true
+		}
+		return !named
+	})
+	if named {
+		return
+	}
+	// The condition runs from its leftmost operand to the brace opening the body
+	start := cond
+	for {
+		b, ok := start.(*ast.Binary)
+		if !ok {
+			break
+		}
+		start = b.Left
+	}
+	pos := ast.PosOf(start)
+	src := l.line(pos.Line)
+	// Sources keep their trailing comments
+	code := src
+	if i := strings.Index(code, "//"); i >= 0 {
+		code = code[:i]
+	}
+	code = strings.TrimRight(strings.TrimSuffix(strings.TrimRight(code, " \t"), "{"), " \t")
+	end := utf8.RuneCountInString(code) + 1
+	span := diag.Span{
+		File:  l.mod.File,
+		Start: diag.Pos{Line: pos.Line, Column: pos.Column},
+		End:   diag.Pos{Line: pos.Line, Column: end},
+	}
+	l.report(diag.CodeConstantCondition, span, src, "%s condition is always %v", keyword, v)
+}
+
+// same reports whether a and b denote the same variable, field or element, comparing only
+// expressions without side effects.
+func (l *linter) same(a, b ast.Expr) bool {
+	switch a := a.(type) {
+	case *ast.Variable:
+		b, ok := b.(*ast.Variable)
+		return ok && l.info.Vars[a] != nil && l.info.Vars[a] == l.info.Vars[b]
+	case *ast.Selector:
+		b, ok := b.(*ast.Selector)
+		return ok && a.Name == b.Name && l.same(a.X, b.X)
+	case *ast.Index:
+		b, ok := b.(*ast.Index)
+		return ok && l.same(a.X, b.X) && l.same(a.Index, b.Index)
+	case *ast.Number:
+		b, ok := b.(*ast.Number)
+		return ok && a.Value == b.Value
+	case *ast.String:
+		b, ok := b.(*ast.String)
+		return ok && a.Value == b.Value
+	case *ast.Bool:
+		b, ok := b.(*ast.Bool)
+		return ok && a.Value == b.Value
+	}
+	return false
+}
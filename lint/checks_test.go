@@ -0,0 +1,73 @@
+package lint
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/parser"
+	"github.com/engpetarmarinov/pede/sema"
+)
+
+// lint parses, type checks and lints src as the main module of a program and returns the warnings
+// found, each as its code, message and notes.
+func lint(t *testing.T, src string) [][]string {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src)).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	mod := &ast.Module{Path: "main", File: "main.pede", Source: src, Program: prog, Imports: map[string]*ast.Module{}}
+	info, err := sema.NewChecker().Check(mod)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	var warnings [][]string
+	for _, w := range Check(mod, info) {
+		warnings = append(warnings, append([]string{w.Code, w.Message}, w.Notes...))
+	}
+	return warnings
+}
+
+func TestCheckVars(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want [][]string
+	}{
+		{"read", "x = 1\nprint(x)\n", nil},
+		{"unused", "x = 1\n", [][]string{{"W0101", "variable x is never used", "to keep it anyway, name it _x"}}},
+		{"underscore", "_x = 1\n", nil},
+		{"assigned again", "x = 1\nx = 2\n", [][]string{{"W0102", "variable x is assigned but never read", "it is assigned again at line 2"}}},
+		{"updated", "x = 1\nx += 2\nx++\n", [][]string{{"W0102", "variable x is updated but never read", "it is last updated at line 3"}}},
+		{"updated and read", "x = 1\nx += 2\nprint(x)\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lint(t, tt.src); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want [][]string
+	}{
+		{"after return", "fn f(): float {\n    return 1\n    print(2)\n}\n", [][]string{{"W0103", "unreachable code", "the block is left at line 2"}}},
+		{"after exit", "fn f(): float {\n    exit(1)\n    print(2)\n}\n", [][]string{{"W0103", "unreachable code", "the block is left at line 2"}}},
+		{"after if", "fn f(x: float): float {\n    if x > 0 {\n        return 1\n    } else {\n        exit(1)\n    }\n    print(2)\n}\n", [][]string{{"W0103", "unreachable code", "the block is left at line 2"}}},
+		{"reachable", "fn f(x: float): float {\n    if x > 0 {\n        return 1\n    }\n    return 2\n}\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lint(t, tt.src); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Package lint finds suspicious but valid code in type checked programs, and decides which of the
+// warnings found by the checker and the linter are reported.
+package lint
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// Warning is a kind of warning, identified by a stable name and diagnostic code.
+type Warning struct {
+	Name string // used by -W options and pede:ignore comments, like the code
+	Code string
+	Doc  string
+}
+
+// Warnings lists every kind of warning, all of which are reported by default.
+var Warnings = []Warning{
+	{"shadow", diag.CodeShadow, "a shadowed variable is used again after the block that hid it"},
+	{"unused-variable", diag.CodeUnusedVariable, "a variable is never used after its declaration"},
+	{"unread-variable", diag.CodeUnreadVariable, "a variable is assigned but its value is never read"},
+	{"unreachable-code", diag.CodeUnreachable, "a statement follows a return or exit() in its block"},
+	{"constant-condition", diag.CodeConstantCondition, "an if or while condition is always true or always false"},
+	{"self-assign", diag.CodeSelfAssign, "a variable, field or element is assigned to itself"},
+}
+
+// Lookup returns the warning with the given name or code.
+func Lookup(id string) (Warning, bool) {
+	for _, w := range Warnings {
+		if w.Name == id || w.Code == id {
+			return w, true
+		}
+	}
+	return Warning{}, false
+}
+
+// Config selects the warnings that are reported. The zero Config reports every warning.
+type Config struct {
+	Disabled map[string]bool // names of the warnings that are not reported
+	Werror   bool            // whether the reported warnings are errors
+}
+
+// Set applies the option -W<opt>: <name> enables the warning name, no-<name> disables it, all and
+// no-all enable or disable every warning, and error turns the reported warnings into errors.
+func (c *Config) Set(opt string) error {
+	if opt == "error" {
+		c.Werror = true
+		return nil
+	}
+	name, disable := opt, false
+	if len(opt) > 3 && opt[:3] == "no-" {
+		name, disable = opt[3:], true
+	}
+	if c.Disabled == nil {
+		c.Disabled = make(map[string]bool)
+	}
+	if name == "all" {
+		for _, w := range Warnings {
+			c.Disabled[w.Name] = disable
+		}
+		return nil
+	}
+	w, ok := Lookup(name)
+	if !ok {
+		return fmt.Errorf("unknown warning %q", name)
+	}
+	c.Disabled[w.Name] = disable
+	return nil
+}
+
+// Apply returns the warnings of diags that c enables and that no pede:ignore comment of root or
+// the modules it imports suppresses. With Werror they are turned into errors.
+func (c *Config) Apply(root *ast.Module, diags []*diag.Diagnostic) []*diag.Diagnostic {
+	ignores := make(map[string]map[int][]string)
+	for _, mod := range modules(root) {
+		ignores[mod.File] = mod.Ignores
+	}
+	var out []*diag.Diagnostic
+	for _, d := range diags {
+		w, ok := Lookup(d.Code)
+		if ok && c.Disabled[w.Name] || ignored(ignores[d.Span.File], d, w) {
+			continue
+		}
+		if c.Werror {
+			d.Severity = diag.Error
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// ignored reports whether a pede:ignore comment suppresses d, a warning of kind w.
+func ignored(ignores map[int][]string, d *diag.Diagnostic, w Warning) bool {
+	ids, ok := ignores[d.Span.Start.Line]
+	if !ok {
+		return false
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	for _, id := range ids {
+		if id == d.Code || w.Name != "" && id == w.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// modules returns root and the modules it imports, directly or not, imported modules first.
+func modules(root *ast.Module) []*ast.Module {
+	var mods []*ast.Module
+	seen := make(map[*ast.Module]bool)
+	var visit func(mod *ast.Module)
+	visit = func(mod *ast.Module) {
+		if seen[mod] {
+			return
+		}
+		seen[mod] = true
+		for _, name := range slices.Sorted(maps.Keys(mod.Imports)) {
+			visit(mod.Imports[name])
+		}
+		mods = append(mods, mod)
+	}
+	visit(root)
+	return mods
+}
@@ -14,6 +14,7 @@ import (
 // [time][LEVEL] message | key=value
 type customSLoggerHandler struct {
 	level slog.Level
+	out   io.Writer // receives every record if set; otherwise errors go to stderr and the rest to stdout
 }
 
 func (h *customSLoggerHandler) Enabled(_ context.Context, lvl slog.Level) bool {
@@ -21,11 +22,12 @@ func (h *customSLoggerHandler) Enabled(_ context.Context, lvl slog.Level) bool {
 }
 
 func (h *customSLoggerHandler) Handle(_ context.Context, r slog.Record) error {
-	var w io.Writer
-	if r.Level >= slog.LevelError {
-		w = os.Stderr
-	} else {
+	w := h.out
+	if w == nil {
 		w = os.Stdout
+		if r.Level >= slog.LevelError {
+			w = os.Stderr
+		}
 	}
 	ts := r.Time.Format("2006-01-02 15:04:05")
 	msg := r.Message
@@ -74,6 +76,12 @@ func (h *customSLoggerHandler) WithGroup(name string) slog.Handler {
 }
 
 func Setup(level string) {
+	SetupTo(level, nil)
+}
+
+// SetupTo is Setup writing every record to out, such as stderr for commands whose stdout is a
+// protocol stream. A nil out splits the records between stdout and stderr.
+func SetupTo(level string, out io.Writer) {
 	var lvl slog.Level
 	switch strings.ToUpper(level) {
 	case "DEBUG":
@@ -87,6 +95,6 @@ func Setup(level string) {
 	default:
 		lvl = slog.LevelDebug
 	}
-	h := &customSLoggerHandler{level: lvl}
+	h := &customSLoggerHandler{level: lvl, out: out}
 	slog.SetDefault(slog.New(h))
 }
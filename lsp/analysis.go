@@ -0,0 +1,416 @@
+package lsp
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lint"
+	"github.com/engpetarmarinov/pede/sema"
+)
+
+// symbol is what an identifier refers to: a *sema.Var, a declaration (*ast.FuncDecl,
+// *ast.TypeDecl, *ast.EnumDecl, *ast.ConstDecl or *ast.ImportDecl), a fieldSym or a variantSym.
+type symbol any
+
+// fieldSym is a field of a struct type.
+type fieldSym struct {
+	decl *ast.TypeDecl
+	name string
+}
+
+// variantSym is a variant of an enum.
+type variantSym struct {
+	decl *ast.EnumDecl
+	name string
+}
+
+// occurrence is an identifier of the source that refers to a symbol, or declares it if decl is set.
+type occurrence struct {
+	file string // absolute path of the source file
+	line int    // 1-based
+	col  int    // 1-based, in characters
+	len  int    // in characters
+	sym  symbol
+	decl bool
+}
+
+// analysis is what the server knows about a program rooted at an open document: its modules, the
+// types the checker computed and every identifier that refers to a symbol.
+type analysis struct {
+	root    *ast.Module
+	modules []*ast.Module // root first
+	info    *sema.Info
+	files   map[*ast.Module]string            // absolute path of each module
+	lines   map[string][]string               // source lines of each module, by absolute path
+	scopes  map[*ast.Module]map[string]symbol // top-level declarations of each module, by name
+	byPath  map[string]*ast.Module            // modules by module path
+	imports map[*ast.ImportDecl]*ast.Module   // module bound by each import
+	occs    []occurrence
+}
+
+// analyze loads the program whose main file is at the absolute path file, checks and lints it, and
+// returns what it found and the diagnostics of every module. The analysis is nil if the program
+// cannot be parsed; after a type error it covers what the checker got to.
+func analyze(file string, opts builder.LoadOptions) (*analysis, []*diag.Diagnostic) {
+	root, err := builder.LoadModulesWith(file, opts)
+	if err != nil {
+		return nil, diagnostics(err)
+	}
+	info, err := builder.Check(root, false)
+	var diags []*diag.Diagnostic
+	if err != nil {
+		diags = diagnostics(&builder.Error{Stage: builder.StageCheck, Err: err})
+	} else {
+		var all lint.Config
+		diags = all.Apply(root, append(info.Warnings, lint.Check(root, info)...))
+	}
+	a := &analysis{
+		root:    root,
+		info:    info,
+		files:   make(map[*ast.Module]string),
+		lines:   make(map[string][]string),
+		scopes:  make(map[*ast.Module]map[string]symbol),
+		byPath:  make(map[string]*ast.Module),
+		imports: make(map[*ast.ImportDecl]*ast.Module),
+	}
+	a.collect(root, filepath.Dir(file))
+	for _, mod := range a.modules {
+		ix := &indexer{a: a, mod: mod, file: a.files[mod], lines: a.lines[a.files[mod]]}
+		ast.Inspect(mod.Program, ix.visit)
+	}
+	return a, diags
+}
+
+// diagnostics returns the diagnostics of an error of the builder.
+func diagnostics(err error) []*diag.Diagnostic {
+	var berr *builder.Error
+	if errors.As(err, &berr) {
+		return berr.Diagnostics()
+	}
+	return []*diag.Diagnostic{{Severity: diag.Error, Code: diag.CodeType, Message: err.Error()}}
+}
+
+// collect records mod and the modules it imports, whose files are relative to dir, with their
+// top-level declarations.
+func (a *analysis) collect(mod *ast.Module, dir string) {
+	if _, seen := a.files[mod]; seen {
+		return
+	}
+	file := mod.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	a.modules = append(a.modules, mod)
+	a.files[mod] = file
+	a.lines[file] = strings.Split(mod.Source, "\n")
+	a.byPath[mod.Path] = mod
+	scope := make(map[string]symbol)
+	for _, stmt := range mod.Program.Stmts {
+		switch n := stmt.(type) {
+		case *ast.FuncDecl:
+			scope[n.Name] = n
+		case *ast.TypeDecl:
+			scope[n.Name] = n
+		case *ast.EnumDecl:
+			scope[n.Name] = n
+		case *ast.ConstDecl:
+			scope[n.Name] = n
+		case *ast.ImportDecl:
+			scope[n.Name] = n
+			a.imports[n] = mod.Imports[n.Name]
+		}
+	}
+	a.scopes[mod] = scope
+	for _, dep := range mod.Imports {
+		a.collect(dep, dir)
+	}
+}
+
+// structDecl returns the declaration of a struct type, or nil if it is not in the program.
+func (a *analysis) structDecl(st *sema.Struct) *ast.TypeDecl {
+	decl, _ := a.scopes[a.byPath[st.Module]][st.Name].(*ast.TypeDecl)
+	return decl
+}
+
+// enumDecl returns the declaration of an enum type, or nil if it is not in the program.
+func (a *analysis) enumDecl(e *sema.Enum) *ast.EnumDecl {
+	decl, _ := a.scopes[a.byPath[e.Module]][e.Name].(*ast.EnumDecl)
+	return decl
+}
+
+// occurrenceAt returns the identifier of file that contains or ends at the 1-based line and column.
+func (a *analysis) occurrenceAt(file string, line, col int) *occurrence {
+	for i := range a.occs {
+		o := &a.occs[i]
+		if o.file == file && o.line == line && o.col <= col && col <= o.col+o.len {
+			return o
+		}
+	}
+	return nil
+}
+
+// declaration returns where sym is declared, or nil if that is not written in the program.
+func (a *analysis) declaration(sym symbol) *occurrence {
+	for i := range a.occs {
+		if a.occs[i].decl && a.occs[i].sym == sym {
+			return &a.occs[i]
+		}
+	}
+	return nil
+}
+
+// references returns every occurrence of sym, its declaration included if decl is set.
+func (a *analysis) references(sym symbol, decl bool) []occurrence {
+	var refs []occurrence
+	for _, o := range a.occs {
+		if o.sym == sym && (decl || !o.decl) {
+			refs = append(refs, o)
+		}
+	}
+	return refs
+}
+
+// indexer records the occurrences of one module.
+type indexer struct {
+	a     *analysis
+	mod   *ast.Module
+	file  string
+	lines []string
+}
+
+func (ix *indexer) line(n int) []rune {
+	if n >= 1 && n <= len(ix.lines) {
+		return []rune(ix.lines[n-1])
+	}
+	return nil
+}
+
+// add records that the identifier name at pos refers to sym. Positions that do not hold the name,
+// such as those of synthesized nodes, are skipped.
+func (ix *indexer) add(pos ast.Pos, name string, sym symbol, decl bool) {
+	if sym == nil || !isWordAt(ix.line(pos.Line), pos.Column, name) {
+		return
+	}
+	ix.a.occs = append(ix.a.occs, occurrence{
+		file: ix.file,
+		line: pos.Line,
+		col:  pos.Column,
+		len:  len([]rune(name)),
+		sym:  sym,
+		decl: decl,
+	})
+}
+
+// addAfter records the first identifier name at or after pos on its line, for nodes whose
+// position is that of a keyword or operator before their name.
+func (ix *indexer) addAfter(pos ast.Pos, name string, sym symbol, decl bool) int {
+	line := ix.line(pos.Line)
+	for col := max(pos.Column, 1); col <= len(line); col++ {
+		if isWordAt(line, col, name) {
+			ix.add(ast.Pos{Line: pos.Line, Column: col}, name, sym, decl)
+			return col + len([]rune(name))
+		}
+	}
+	return pos.Column
+}
+
+// isWordAt reports whether the whole word name starts at the 1-based column col of line.
+func isWordAt(line []rune, col int, name string) bool {
+	word := []rune(name)
+	i := col - 1
+	if i < 0 || i+len(word) > len(line) || string(line[i:i+len(word)]) != name {
+		return false
+	}
+	return (i == 0 || !isIdentRune(line[i-1])) && (i+len(word) == len(line) || !isIdentRune(line[i+len(word)]))
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// visit records the occurrences in node n; it is called by ast.Inspect for every node of the module.
+func (ix *indexer) visit(n any) bool {
+	info := ix.a.info
+	switch n := n.(type) {
+	case *ast.ImportDecl:
+		// Only a name written before the path declares the import
+		line := ix.line(n.Line)
+		if end := strings.IndexRune(string(line), '"'); end >= 0 {
+			quote := len([]rune(string(line)[:end])) + 1
+			for col := n.Column; col < quote; col++ {
+				if isWordAt(line, col, n.Name) {
+					ix.add(ast.Pos{Line: n.Line, Column: col}, n.Name, n, true)
+					break
+				}
+			}
+		}
+	case *ast.FuncDecl:
+		ix.addAfter(n.Pos, n.Name, n, true)
+		ix.params(n.Params, n.Result)
+	case *ast.FuncLit:
+		ix.params(n.Params, n.Result)
+	case *ast.TypeDecl:
+		ix.addAfter(n.Pos, n.Name, n, true)
+		for _, f := range n.Fields {
+			ix.add(f.Pos, f.Name, fieldSym{n, f.Name}, true)
+			ix.typeExpr(f.Type)
+		}
+	case *ast.EnumDecl:
+		ix.addAfter(n.Pos, n.Name, n, true)
+		for _, v := range n.Variants {
+			ix.add(v.Pos, v.Name, variantSym{n, v.Name}, true)
+			for _, f := range v.Fields {
+				ix.typeExpr(f.Type)
+			}
+		}
+	case *ast.ConstDecl:
+		ix.addAfter(n.Pos, n.Name, n, true)
+		ix.typeExpr(n.Type)
+	case *ast.Assignment:
+		if v := info.Vars[n]; v != nil {
+			ix.addAfter(n.Pos, n.Name, v, v.Pos == n.Pos)
+		}
+		ix.typeExpr(n.Type)
+	case *ast.ForStmt:
+		if v := info.Vars[n]; v != nil {
+			ix.addAfter(n.Pos, n.Var, v, true)
+		}
+	case *ast.TryStmt:
+		// The variable follows catch, after the closing brace of the body
+		if v := info.Vars[n]; v != nil && n.Body != nil {
+			ix.addAfter(n.Body.End, n.Var, v, true)
+		}
+	case *ast.Match:
+		var decl *ast.EnumDecl
+		if e, ok := info.Types[n.Subject].(*sema.Enum); ok {
+			decl = ix.a.enumDecl(e)
+		}
+		for _, arm := range n.Arms {
+			if decl != nil && arm.Variant != "_" {
+				ix.add(arm.Pos, arm.Variant, variantSym{decl, arm.Variant}, false)
+			}
+			vars := info.Bindings[arm]
+			col := arm.Column + len([]rune(arm.Variant))
+			for i, name := range arm.Bindings {
+				var v symbol
+				if i < len(vars) && vars[i] != nil {
+					v = vars[i]
+				}
+				col = ix.addAfter(ast.Pos{Line: arm.Line, Column: col}, name, v, true)
+			}
+		}
+	case *ast.StructLit:
+		var decl symbol
+		pos := n.Pos
+		if n.Module != "" {
+			imp := ix.a.scopes[ix.mod][n.Module]
+			ix.add(pos, n.Module, imp, false)
+			if imp, ok := imp.(*ast.ImportDecl); ok {
+				decl = ix.a.scopes[ix.a.imports[imp]][n.Type]
+			}
+			pos.Column += len([]rune(n.Module))
+		} else {
+			decl = ix.a.scopes[ix.mod][n.Type]
+		}
+		ix.addAfter(pos, n.Type, decl, false)
+		if decl, ok := decl.(*ast.TypeDecl); ok {
+			for _, f := range n.Fields {
+				ix.add(f.Pos, f.Name, fieldSym{decl, f.Name}, false)
+			}
+		}
+	case *ast.Variable:
+		ix.add(n.Pos, n.Name, ix.resolve(n), false)
+	case *ast.Selector:
+		ix.addAfter(n.Pos, n.Name, ix.resolve(n), false)
+	}
+	return true
+}
+
+// params records the parameters and types of a function declaration or literal.
+func (ix *indexer) params(params []ast.Field, result ast.TypeExpr) {
+	for i := range params {
+		p := &params[i]
+		if v := ix.a.info.Vars[p]; v != nil {
+			ix.add(p.Pos, p.Name, v, true)
+		}
+		ix.typeExpr(p.Type)
+	}
+	ix.typeExpr(result)
+}
+
+// typeExpr records the declared types a type expression refers to.
+func (ix *indexer) typeExpr(t ast.TypeExpr) {
+	switch t := t.(type) {
+	case *ast.NamedType:
+		if t.Module == "" {
+			switch decl := ix.a.scopes[ix.mod][t.Name].(type) {
+			case *ast.TypeDecl, *ast.EnumDecl:
+				ix.add(t.Pos, t.Name, decl, false)
+			}
+			return
+		}
+		imp, ok := ix.a.scopes[ix.mod][t.Module].(*ast.ImportDecl)
+		if !ok {
+			return
+		}
+		ix.add(t.Pos, t.Module, imp, false)
+		pos := ast.Pos{Line: t.Line, Column: t.Column + len([]rune(t.Module))}
+		ix.addAfter(pos, t.Name, ix.a.scopes[ix.a.imports[imp]][t.Name], false)
+	case *ast.ListType:
+		ix.typeExpr(t.Elem)
+	case *ast.MapType:
+		ix.typeExpr(t.Key)
+		ix.typeExpr(t.Value)
+	case *ast.ResultType:
+		ix.typeExpr(t.Value)
+	case *ast.FuncType:
+		for _, p := range t.Params {
+			ix.typeExpr(p)
+		}
+		ix.typeExpr(t.Result)
+	}
+}
+
+// resolve returns the symbol a variable or selector refers to, or nil if it is a builtin or was
+// not checked.
+func (ix *indexer) resolve(e ast.Expr) symbol {
+	info := ix.a.info
+	switch n := e.(type) {
+	case *ast.Variable:
+		if v := info.Vars[n]; v != nil {
+			return v
+		}
+		if f := info.FuncValues[n]; f != nil && f.Decl != nil {
+			return f.Decl
+		}
+		return ix.a.scopes[ix.mod][n.Name]
+	case *ast.Selector:
+		if v := info.Variants[n]; v != nil {
+			if decl := ix.a.enumDecl(v.Enum); decl != nil {
+				return variantSym{decl, v.Name}
+			}
+		}
+		switch x := ix.resolve(n.X).(type) {
+		case *ast.ImportDecl:
+			if sym, ok := ix.a.scopes[ix.a.imports[x]][n.Name]; ok {
+				if _, imp := sym.(*ast.ImportDecl); !imp {
+					return sym
+				}
+			}
+			return nil
+		case *ast.EnumDecl:
+			return variantSym{x, n.Name}
+		}
+		if st, ok := info.Types[n.X].(*sema.Struct); ok {
+			if decl := ix.a.structDecl(st); decl != nil {
+				return fieldSym{decl, n.Name}
+			}
+		}
+	}
+	return nil
+}
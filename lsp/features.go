@@ -0,0 +1,499 @@
+package lsp
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/format"
+	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/sema"
+)
+
+// lookup returns the analysis of the document at p and the identifier at its position, nil if
+// there is none.
+func (s *Server) lookup(p TextDocumentPositionParams) (*analysis, *occurrence, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil || doc.analysis == nil {
+		return nil, nil, err
+	}
+	a := doc.analysis
+	line := p.Position.Line + 1
+	col := column(lineAt(a.lines[doc.path], line), p.Position.Character)
+	return a, a.occurrenceAt(doc.path, line, col), nil
+}
+
+// location returns where an occurrence is.
+func (s *Server) location(a *analysis, o occurrence) Location {
+	line := lineAt(a.lines[o.file], o.line)
+	return Location{
+		URI: s.uri(o.file),
+		Range: Range{
+			Start: Position{Line: o.line - 1, Character: character(line, o.col)},
+			End:   Position{Line: o.line - 1, Character: character(line, o.col+o.len)},
+		},
+	}
+}
+
+func (s *Server) hover(p TextDocumentPositionParams) (*Hover, error) {
+	a, o, err := s.lookup(p)
+	if o == nil {
+		return nil, err
+	}
+	text := "```pede\n" + a.describe(o.sym) + "\n```"
+	switch sym := o.sym.(type) {
+	case fieldSym:
+		text += "\n\nfield of `" + sym.decl.Name + "`"
+	case *ast.ImportDecl:
+		if mod := a.imports[sym]; mod != nil {
+			text += "\n\n" + mod.File
+		}
+	}
+	r := s.location(a, *o).Range
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: text}, Range: &r}, nil
+}
+
+func (s *Server) definition(p TextDocumentPositionParams) ([]Location, error) {
+	a, o, err := s.lookup(p)
+	if o == nil {
+		return nil, err
+	}
+	// An import is defined by the module it binds
+	if imp, ok := o.sym.(*ast.ImportDecl); ok && a.imports[imp] != nil {
+		return []Location{{URI: s.uri(a.files[a.imports[imp]])}}, nil
+	}
+	decl := a.declaration(o.sym)
+	if decl == nil {
+		return nil, nil
+	}
+	return []Location{s.location(a, *decl)}, nil
+}
+
+func (s *Server) references(p ReferenceParams) ([]Location, error) {
+	a, o, err := s.lookup(p.TextDocumentPositionParams)
+	if o == nil {
+		return nil, err
+	}
+	return s.allReferences(a, o.sym, p.Context.IncludeDeclaration), nil
+}
+
+// allReferences returns where the symbol sym of analysis a occurs in the programs of every open
+// document, so that the uses in the files importing its module are found too. The symbol is
+// identified in the other programs by the position of its declaration.
+func (s *Server) allReferences(a *analysis, sym symbol, decl bool) []Location {
+	var locs []Location
+	seen := make(map[Location]bool)
+	add := func(a *analysis, sym symbol) {
+		for _, ref := range a.references(sym, decl) {
+			if loc := s.location(a, ref); !seen[loc] {
+				seen[loc] = true
+				locs = append(locs, loc)
+			}
+		}
+	}
+	add(a, sym)
+	d := a.declaration(sym)
+	if d == nil {
+		return locs
+	}
+	for _, path := range slices.Sorted(maps.Keys(s.docs)) {
+		b := s.docs[path].analysis
+		if b == nil || b == a {
+			continue
+		}
+		if o := b.occurrenceAt(d.file, d.line, d.col); o != nil && o.decl {
+			add(b, o.sym)
+		}
+	}
+	return locs
+}
+
+func (s *Server) rename(p RenameParams) (*WorkspaceEdit, error) {
+	a, o, err := s.lookup(p.TextDocumentPositionParams)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, &rpcError{Code: codeRequestFailed, Message: "no symbol to rename here"}
+	}
+	if _, ok := o.sym.(*ast.ImportDecl); ok {
+		return nil, &rpcError{Code: codeRequestFailed, Message: "renaming imports is not supported"}
+	}
+	if !validName(p.NewName) {
+		return nil, &rpcError{Code: codeRequestFailed, Message: p.NewName + " is not a valid name"}
+	}
+	edit := &WorkspaceEdit{Changes: make(map[string][]TextEdit)}
+	for _, loc := range s.allReferences(a, o.sym, true) {
+		edit.Changes[loc.URI] = append(edit.Changes[loc.URI], TextEdit{Range: loc.Range, NewText: p.NewName})
+	}
+	return edit, nil
+}
+
+// validName reports whether name can name a symbol: an identifier that is neither a keyword nor
+// a builtin.
+func validName(name string) bool {
+	if name == "" || slices.Contains(lexer.Keywords(), name) || slices.Contains(sema.Builtins(), name) {
+		return false
+	}
+	for i, r := range name {
+		if !isIdentRune(r) || i == 0 && r >= '0' && r <= '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// describe returns the declaration of a symbol in pede syntax.
+func (a *analysis) describe(sym symbol) string {
+	switch sym := sym.(type) {
+	case *sema.Var:
+		text := sym.Name + ": " + typeName(sym.Type)
+		if sym.Let {
+			text = "let " + text
+		}
+		return text
+	case *ast.FuncDecl:
+		return signature(sym)
+	case *ast.TypeDecl:
+		var b strings.Builder
+		if sym.Pub {
+			b.WriteString("pub ")
+		}
+		b.WriteString("type " + sym.Name + " {\n")
+		for _, f := range sym.Fields {
+			b.WriteString("    " + f.Name + ": " + format.Type(f.Type) + "\n")
+		}
+		b.WriteString("}")
+		return b.String()
+	case *ast.EnumDecl:
+		var b strings.Builder
+		if sym.Pub {
+			b.WriteString("pub ")
+		}
+		b.WriteString("enum " + sym.Name + " {\n")
+		for _, v := range sym.Variants {
+			b.WriteString("    " + variantString(v) + "\n")
+		}
+		b.WriteString("}")
+		return b.String()
+	case *ast.ConstDecl:
+		text := "const " + sym.Name
+		if t := a.info.TypeOf(sym.Value); t != nil {
+			text += ": " + t.String()
+		}
+		if v, ok := a.info.Values[sym.Value]; ok {
+			text += " = " + valueString(v)
+		}
+		if sym.Pub {
+			text = "pub " + text
+		}
+		return text
+	case *ast.ImportDecl:
+		return "import " + sym.Name + " \"" + sym.Path + "\""
+	case fieldSym:
+		for _, f := range sym.decl.Fields {
+			if f.Name == sym.name {
+				return f.Name + ": " + format.Type(f.Type)
+			}
+		}
+	case variantSym:
+		for _, v := range sym.decl.Variants {
+			if v.Name == sym.name {
+				return sym.decl.Name + "." + variantString(v)
+			}
+		}
+	}
+	return ""
+}
+
+// typeName returns the name of a type, "?" if it is unknown.
+func typeName(t sema.Type) string {
+	if t == nil {
+		return "?"
+	}
+	return t.String()
+}
+
+// signature returns the header of a function declaration.
+func signature(d *ast.FuncDecl) string {
+	var b strings.Builder
+	if d.Pub {
+		b.WriteString("pub ")
+	}
+	if d.Export {
+		b.WriteString("export ")
+	}
+	if d.Extern {
+		b.WriteString("extern ")
+	}
+	b.WriteString("fn " + d.Name + "(")
+	for i, p := range d.Params {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(p.Name + ": " + format.Type(p.Type))
+	}
+	b.WriteString(")")
+	if d.Result != nil {
+		b.WriteString(": " + format.Type(d.Result))
+	}
+	return b.String()
+}
+
+// variantString returns a variant as declared: Name or Name(field, field: Type, ...)
+func variantString(v ast.Variant) string {
+	if len(v.Fields) == 0 {
+		return v.Name
+	}
+	fields := make([]string, len(v.Fields))
+	for i, f := range v.Fields {
+		fields[i] = f.Name
+		if f.Type != nil {
+			fields[i] += ": " + format.Type(f.Type)
+		}
+	}
+	return v.Name + "(" + strings.Join(fields, ", ") + ")"
+}
+
+// valueString returns a constant value as a literal.
+func valueString(v any) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return "\"" + v + "\""
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func (s *Server) completion(p TextDocumentPositionParams) ([]CompletionItem, error) {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	a := doc.analysis
+	if a == nil {
+		return keywordItems(), nil
+	}
+	line := p.Position.Line + 1
+	text := []rune(lineAt(strings.Split(doc.text, "\n"), line))
+	before := text[:min(column(string(text), p.Position.Character)-1, len(text))]
+	// Skip the word being written, then read the selectors before it: a.b.
+	i := len(before)
+	for i > 0 && isIdentRune(before[i-1]) {
+		i--
+	}
+	var chain []string
+	for i > 0 && before[i-1] == '.' {
+		j := i - 1
+		k := j
+		for k > 0 && isIdentRune(before[k-1]) {
+			k--
+		}
+		if k == j {
+			break
+		}
+		chain = append([]string{string(before[k:j])}, chain...)
+		i = k
+	}
+	if len(chain) > 0 {
+		return a.members(doc.path, line, chain), nil
+	}
+	return a.visible(doc.path, line), nil
+}
+
+// members returns the completions after the selectors of chain written on line of file.
+func (a *analysis) members(file string, line int, chain []string) []CompletionItem {
+	sym := a.named(file, line, chain[0])
+	for _, name := range chain[1:] {
+		sym = a.member(sym, name)
+	}
+	var items []CompletionItem
+	switch sym := sym.(type) {
+	case *ast.ImportDecl:
+		mod := a.imports[sym]
+		if mod == nil {
+			return nil
+		}
+		for _, stmt := range mod.Program.Stmts {
+			if item, ok := a.declItem(stmt, true); ok {
+				items = append(items, item)
+			}
+		}
+	case *ast.EnumDecl:
+		for _, v := range sym.Variants {
+			items = append(items, CompletionItem{Label: v.Name, Kind: kindEnumMember, Detail: sym.Name + "." + variantString(v)})
+		}
+	default:
+		if decl := a.structOf(sym); decl != nil {
+			for _, f := range decl.Fields {
+				items = append(items, CompletionItem{Label: f.Name, Kind: kindField, Detail: format.Type(f.Type)})
+			}
+		}
+	}
+	return items
+}
+
+// named returns the symbol name refers to on line of file: the variable of that name declared or
+// used last before, or else a top-level declaration of the module.
+func (a *analysis) named(file string, line int, name string) symbol {
+	var sym symbol
+	for _, o := range a.occs {
+		if v, ok := o.sym.(*sema.Var); ok && o.file == file && o.line <= line && v.Name == name {
+			sym = v
+		}
+	}
+	if sym != nil {
+		return sym
+	}
+	for mod, f := range a.files {
+		if f == file {
+			return a.scopes[mod][name]
+		}
+	}
+	return nil
+}
+
+// member returns the symbol selected by name from sym, nil if there is none.
+func (a *analysis) member(sym symbol, name string) symbol {
+	if imp, ok := sym.(*ast.ImportDecl); ok {
+		return a.scopes[a.imports[imp]][name]
+	}
+	if decl := a.structOf(sym); decl != nil {
+		return fieldSym{decl, name}
+	}
+	return nil
+}
+
+// structOf returns the declaration of the struct type of a variable or field, nil if it has none.
+func (a *analysis) structOf(sym symbol) *ast.TypeDecl {
+	switch sym := sym.(type) {
+	case *sema.Var:
+		if st, ok := sym.Type.(*sema.Struct); ok {
+			return a.structDecl(st)
+		}
+	case fieldSym:
+		mod := a.moduleOf(sym.decl)
+		for _, f := range sym.decl.Fields {
+			t, ok := f.Type.(*ast.NamedType)
+			if f.Name != sym.name || !ok || mod == nil {
+				continue
+			}
+			if t.Module != "" {
+				imp, _ := a.scopes[mod][t.Module].(*ast.ImportDecl)
+				mod = a.imports[imp]
+			}
+			decl, _ := a.scopes[mod][t.Name].(*ast.TypeDecl)
+			return decl
+		}
+	}
+	return nil
+}
+
+// moduleOf returns the module declaring a top-level symbol.
+func (a *analysis) moduleOf(decl symbol) *ast.Module {
+	for _, mod := range a.modules {
+		for _, sym := range a.scopes[mod] {
+			if sym == decl {
+				return mod
+			}
+		}
+	}
+	return nil
+}
+
+// visible returns the completions at the start of a word on line of file: keywords, builtins, the
+// top-level declarations of the module and the variables of the enclosing function declared
+// before the line.
+func (a *analysis) visible(file string, line int) []CompletionItem {
+	items := keywordItems()
+	for _, name := range sema.Builtins() {
+		items = append(items, CompletionItem{Label: name, Kind: kindFunction, Detail: "builtin"})
+	}
+	var mod *ast.Module
+	for m, f := range a.files {
+		if f == file {
+			mod = m
+		}
+	}
+	if mod == nil {
+		return items
+	}
+	// Variables are local to the function, test or benchmark around line, or to the top level
+	first, inFunc := 1, false
+	var funcs [][2]int
+	for _, stmt := range mod.Program.Stmts {
+		if item, ok := a.declItem(stmt, false); ok {
+			items = append(items, item)
+		}
+		var body *ast.Block
+		switch n := stmt.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.TestDecl:
+			body = n.Body
+		case *ast.BenchDecl:
+			body = n.Body
+		}
+		if start := ast.PosOf(stmt).Line; body != nil {
+			funcs = append(funcs, [2]int{start, body.End.Line})
+			if start <= line && line <= body.End.Line {
+				first, inFunc = start, true
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for i := len(a.occs) - 1; i >= 0; i-- {
+		o := a.occs[i]
+		v, ok := o.sym.(*sema.Var)
+		if !ok || !o.decl || o.file != file || o.line < first || o.line > line || seen[v.Name] {
+			continue
+		}
+		if !inFunc && slices.ContainsFunc(funcs, func(fn [2]int) bool { return fn[0] <= o.line && o.line <= fn[1] }) {
+			continue
+		}
+		seen[v.Name] = true
+		items = append(items, CompletionItem{Label: v.Name, Kind: kindVariable, Detail: typeName(v.Type)})
+	}
+	return items
+}
+
+// declItem returns the completion of a top-level declaration; pub limits them to those visible to
+// importing modules.
+func (a *analysis) declItem(stmt ast.Stmt, pub bool) (CompletionItem, bool) {
+	switch n := stmt.(type) {
+	case *ast.FuncDecl:
+		if !pub || n.Pub {
+			return CompletionItem{Label: n.Name, Kind: kindFunction, Detail: signature(n)}, true
+		}
+	case *ast.TypeDecl:
+		if !pub || n.Pub {
+			return CompletionItem{Label: n.Name, Kind: kindStruct, Detail: "type"}, true
+		}
+	case *ast.EnumDecl:
+		if !pub || n.Pub {
+			return CompletionItem{Label: n.Name, Kind: kindEnum, Detail: "enum"}, true
+		}
+	case *ast.ConstDecl:
+		if !pub || n.Pub {
+			return CompletionItem{Label: n.Name, Kind: kindConstant, Detail: a.describe(n)}, true
+		}
+	case *ast.ImportDecl:
+		if !pub {
+			return CompletionItem{Label: n.Name, Kind: kindModule, Detail: n.Path}, true
+		}
+	}
+	return CompletionItem{}, false
+}
+
+func keywordItems() []CompletionItem {
+	var items []CompletionItem
+	for _, kw := range lexer.Keywords() {
+		items = append(items, CompletionItem{Label: kw, Kind: kindKeyword})
+	}
+	return items
+}
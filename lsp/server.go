@@ -0,0 +1,409 @@
+// Package lsp implements a language server for pede: it speaks the Language Server Protocol over
+// a stream such as stdio and gives editors diagnostics, hover types, go-to-definition, references,
+// rename and completion, reusing the lexer, parser and checker of the compiler.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/engpetarmarinov/pede/builder"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// errExitWithoutShutdown is returned by Run when the client exits without asking to shut down.
+var errExitWithoutShutdown = errors.New("exit without shutdown")
+
+// Server is a language server for the pede files a client opens.
+type Server struct {
+	conn        *conn
+	importPaths []string
+	cache       builder.ParseCache   // parsed modules, so that only changed files are parsed again
+	docs        map[string]*document // open documents, by absolute path
+	published   map[string]bool      // URIs that have diagnostics shown, to clear them
+	shutdown    bool                 // the client asked to shut down
+}
+
+// document is a file open in the client, whose text may differ from the one on disk.
+type document struct {
+	uri     string
+	path    string
+	version int
+	text    string
+	// analysis is of the program whose main file is the document. It is kept from the last
+	// version that parsed, so that features keep working while a line is being written.
+	analysis *analysis
+}
+
+// NewServer creates a server that reads messages from in and writes them to out. Imported modules
+// are searched next to the importing file, then in importPaths.
+func NewServer(in io.Reader, out io.Writer, importPaths []string) *Server {
+	return &Server{
+		conn:        newConn(in, out),
+		importPaths: importPaths,
+		docs:        make(map[string]*document),
+		published:   make(map[string]bool),
+	}
+}
+
+// Run serves the client until it sends exit or closes the input. It returns an error if the
+// client did not shut the server down first.
+func (s *Server) Run() error {
+	for {
+		msg, err := s.conn.read()
+		if errors.Is(err, io.EOF) {
+			if !s.shutdown {
+				return errors.New("input closed without shutdown")
+			}
+			return nil
+		}
+		var rerr *rpcError
+		if errors.As(err, &rerr) {
+			if werr := s.conn.reply(nil, nil, rerr); werr != nil {
+				return werr
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errExitWithoutShutdown
+			}
+			return nil
+		}
+		result, err := s.dispatch(msg)
+		if msg.ID == nil {
+			if err != nil {
+				slog.Error("Notification failed", "method", msg.Method, "err", err)
+			}
+			continue
+		}
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch handles a request or notification and returns the result of requests.
+func (s *Server) dispatch(msg *message) (any, error) {
+	slog.Debug("LSP message", "method", msg.Method)
+	if s.shutdown {
+		return nil, &rpcError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+	switch msg.Method {
+	case "initialize":
+		return s.initialize(), nil
+	case "initialized", "$/cancelRequest", "$/setTrace", "workspace/didChangeConfiguration":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.didOpen(p)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.didChange(p)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.didClose(p)
+	case "textDocument/didSave":
+		// Files that are not open may have changed on disk too
+		s.refresh()
+		return nil, nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p)
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.definition(p)
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.references(p)
+	case "textDocument/rename":
+		var p RenameParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.rename(p)
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		if err := decode(msg.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.completion(p)
+	}
+	if msg.ID == nil {
+		// Notifications the server does not know are ignored
+		return nil, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: "method not supported: " + msg.Method}
+}
+
+func decode(params json.RawMessage, v any) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) initialize() any {
+	return map[string]any{
+		"capabilities": map[string]any{
+			"textDocumentSync": map[string]any{
+				"openClose": true,
+				"change":    2, // incremental
+				"save":      true,
+			},
+			"hoverProvider":      true,
+			"definitionProvider": true,
+			"referencesProvider": true,
+			"renameProvider":     true,
+			"completionProvider": map[string]any{"triggerCharacters": []string{"."}},
+		},
+		"serverInfo": map[string]any{"name": "pede"},
+	}
+}
+
+func (s *Server) didOpen(p DidOpenTextDocumentParams) error {
+	path, err := uriToPath(p.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	s.docs[path] = &document{
+		uri:     p.TextDocument.URI,
+		path:    path,
+		version: p.TextDocument.Version,
+		text:    p.TextDocument.Text,
+	}
+	s.refresh()
+	return nil
+}
+
+func (s *Server) didChange(p DidChangeTextDocumentParams) error {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	for _, change := range p.ContentChanges {
+		if change.Range == nil {
+			doc.text = change.Text
+			continue
+		}
+		start := offset(doc.text, change.Range.Start)
+		end := max(offset(doc.text, change.Range.End), start)
+		doc.text = doc.text[:start] + change.Text + doc.text[end:]
+	}
+	doc.version = p.TextDocument.Version
+	s.refresh()
+	return nil
+}
+
+func (s *Server) didClose(p DidCloseTextDocumentParams) error {
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	delete(s.docs, doc.path)
+	if s.published[doc.uri] {
+		delete(s.published, doc.uri)
+		if err := s.conn.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: doc.uri, Diagnostics: []Diagnostic{}}); err != nil {
+			return err
+		}
+	}
+	// Programs importing the file now read it from disk
+	s.refresh()
+	return nil
+}
+
+// document returns the open document with the given URI.
+func (s *Server) document(uri string) (*document, error) {
+	path, err := uriToPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	doc := s.docs[path]
+	if doc == nil {
+		return nil, &rpcError{Code: codeRequestFailed, Message: "document is not open: " + uri}
+	}
+	return doc, nil
+}
+
+// refresh analyzes the program of every open document again, since a change to one file can
+// break those importing it, and publishes their diagnostics. Only changed files are parsed again.
+func (s *Server) refresh() {
+	opts := builder.LoadOptions{
+		ImportPaths: s.importPaths,
+		ReadFile:    s.readFile,
+		Raw:         true,
+		Cache:       &s.cache,
+	}
+	for _, path := range slices.Sorted(maps.Keys(s.docs)) {
+		doc := s.docs[path]
+		a, diags := analyze(doc.path, opts)
+		if a != nil {
+			doc.analysis = a
+		}
+		params := PublishDiagnosticsParams{URI: doc.uri, Version: &doc.version, Diagnostics: s.convert(doc, diags)}
+		if err := s.conn.notify("textDocument/publishDiagnostics", params); err != nil {
+			slog.Error("Failed to publish diagnostics", "uri", doc.uri, "err", err)
+		}
+		s.published[doc.uri] = len(params.Diagnostics) > 0
+	}
+}
+
+// readFile reads a source file for the loader, from the client for open documents.
+func (s *Server) readFile(abs string) ([]byte, error) {
+	if doc := s.docs[abs]; doc != nil {
+		return []byte(doc.text), nil
+	}
+	return os.ReadFile(abs)
+}
+
+// convert returns the diagnostics of the program of doc to show in doc. Errors in imported modules
+// are shown on its first line, prefixed by their file; their warnings are left to the modules' own
+// documents.
+func (s *Server) convert(doc *document, diags []*diag.Diagnostic) []Diagnostic {
+	lines := strings.Split(doc.text, "\n")
+	dir := filepath.Dir(doc.path)
+	out := []Diagnostic{}
+	for _, d := range diags {
+		file := d.Span.File
+		if file != "" && !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		msg := d.Message
+		for _, note := range d.Notes {
+			msg += "\n" + note
+		}
+		var r Range
+		switch {
+		case file != "" && file != doc.path:
+			if d.Severity != diag.Error {
+				continue
+			}
+			msg = fmt.Sprintf("%s:%d:%d: %s", d.Span.File, d.Span.Start.Line, d.Span.Start.Column, msg)
+		case d.Located():
+			r = spanRange(lines, d.Span)
+		}
+		severity := severityError
+		if d.Severity != diag.Error {
+			severity = severityWarning
+		}
+		out = append(out, Diagnostic{Range: r, Severity: severity, Code: d.Code, Source: "pede", Message: msg})
+	}
+	return out
+}
+
+// spanRange converts a span of a diagnostic to a range of the document with the given lines. A span
+// without an end covers the character at its start.
+func spanRange(lines []string, span diag.Span) Range {
+	end := span.End
+	if end.Line == 0 {
+		end = diag.Pos{Line: span.Start.Line, Column: span.Start.Column + 1}
+	}
+	return Range{
+		Start: Position{Line: span.Start.Line - 1, Character: character(lineAt(lines, span.Start.Line), span.Start.Column)},
+		End:   Position{Line: end.Line - 1, Character: character(lineAt(lines, end.Line), end.Column)},
+	}
+}
+
+// lineAt returns the 1-based line n of lines, or "" if there is none.
+func lineAt(lines []string, n int) string {
+	if n >= 1 && n <= len(lines) {
+		return lines[n-1]
+	}
+	return ""
+}
+
+// character converts a 1-based column in characters on line to an LSP character offset, which
+// counts UTF-16 code units.
+func character(line string, col int) int {
+	units := 0
+	for i, r := range []rune(line) {
+		if i >= col-1 {
+			break
+		}
+		units += utf16.RuneLen(r)
+	}
+	return units + max(col-1-len([]rune(line)), 0)
+}
+
+// column converts an LSP character offset on line to a 1-based column in characters.
+func column(line string, char int) int {
+	col, units := 1, 0
+	for _, r := range line {
+		if units >= char {
+			break
+		}
+		units += utf16.RuneLen(r)
+		col++
+	}
+	return col
+}
+
+// offset converts a position of text to a byte offset, clamped to the line and the text.
+func offset(text string, pos Position) int {
+	start := 0
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(text[start:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		start += i + 1
+	}
+	units := 0
+	for i, r := range text[start:] {
+		if units >= pos.Character || r == '\n' {
+			return start + i
+		}
+		units += utf16.RuneLen(r)
+	}
+	return len(text)
+}
+
+func uriToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", &rpcError{Code: codeInvalidParams, Message: "not a file URI: " + uri}
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// uri returns the URI of the file at the absolute path, as the client named it if it is open.
+func (s *Server) uri(path string) string {
+	if doc := s.docs[path]; doc != nil {
+		return doc.uri
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
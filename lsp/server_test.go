@@ -0,0 +1,370 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/builder/buildertest"
+)
+
+// newTestServer returns a server whose notifications are discarded.
+func newTestServer() *Server {
+	return NewServer(strings.NewReader(""), io.Discard, nil)
+}
+
+// fileURI returns the URI of the file at path.
+func fileURI(path string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+func TestOffset(t *testing.T) {
+	text := "ab\né😀x\n\nlast"
+	tests := []struct {
+		pos  Position
+		want int
+	}{
+		{Position{0, 0}, 0},
+		{Position{0, 2}, 2},
+		{Position{0, 9}, 2}, // clamped to the end of the line
+		{Position{1, 0}, 3},
+		{Position{1, 1}, 5},  // é is one UTF-16 unit and two bytes
+		{Position{1, 3}, 9},  // 😀 is two UTF-16 units and four bytes
+		{Position{1, 4}, 10}, // end of the line
+		{Position{2, 0}, 11},
+		{Position{3, 2}, 14},
+		{Position{3, 9}, 16},
+		{Position{9, 0}, 16}, // clamped to the end of the text
+	}
+	for _, tt := range tests {
+		if got := offset(text, tt.pos); got != tt.want {
+			t.Errorf("offset(%q, %+v) = %d, want %d", text, tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestCharacter(t *testing.T) {
+	tests := []struct {
+		line string
+		col  int
+		want int
+	}{
+		{"abc", 1, 0},
+		{"abc", 3, 2},
+		{"abc", 4, 3},
+		{"é😀x", 2, 1},
+		{"é😀x", 3, 3},
+		{"é😀x", 4, 4},
+		{"ab", 5, 4}, // past the end, one unit per column
+		{"", 1, 0},
+	}
+	for _, tt := range tests {
+		if got := character(tt.line, tt.col); got != tt.want {
+			t.Errorf("character(%q, %d) = %d, want %d", tt.line, tt.col, got, tt.want)
+		}
+	}
+}
+
+func TestColumn(t *testing.T) {
+	tests := []struct {
+		line string
+		char int
+		want int
+	}{
+		{"abc", 0, 1},
+		{"abc", 2, 3},
+		{"abc", 9, 4},
+		{"é😀x", 1, 2},
+		{"é😀x", 3, 3},
+		{"é😀x", 2, 3}, // inside 😀, rounded up to the column after it
+		{"é😀x", 4, 4},
+		{"", 0, 1},
+	}
+	for _, tt := range tests {
+		if got := column(tt.line, tt.char); got != tt.want {
+			t.Errorf("column(%q, %d) = %d, want %d", tt.line, tt.char, got, tt.want)
+		}
+	}
+}
+
+func TestDidChange(t *testing.T) {
+	rng := func(l1, c1, l2, c2 int) *Range {
+		return &Range{Start: Position{l1, c1}, End: Position{l2, c2}}
+	}
+	tests := []struct {
+		name    string
+		text    string
+		changes []TextDocumentContentChangeEvent
+		want    string
+	}{
+		{"insert", "x = 1\n", []TextDocumentContentChangeEvent{{Range: rng(0, 5, 0, 5), Text: "0"}}, "x = 10\n"},
+		{"delete", "x = 10\n", []TextDocumentContentChangeEvent{{Range: rng(0, 4, 0, 5)}}, "x = 0\n"},
+		{"replace", "x = 1\nprint(x)\n", []TextDocumentContentChangeEvent{{Range: rng(1, 6, 1, 7), Text: "y"}}, "x = 1\nprint(y)\n"},
+		{"across lines", "a = 1\nb = 2\nc = 3\n", []TextDocumentContentChangeEvent{{Range: rng(0, 4, 2, 4), Text: "9\nd = "}}, "a = 9\nd = 3\n"},
+		{"whole document", "a = 1\n", []TextDocumentContentChangeEvent{{Text: "b = 2\n"}}, "b = 2\n"},
+		{
+			"in order",
+			"x = 1\n",
+			[]TextDocumentContentChangeEvent{{Range: rng(0, 0, 0, 1), Text: "yy"}, {Range: rng(0, 2, 0, 2), Text: "z"}},
+			"yyz = 1\n",
+		},
+		{"after astral", "s = \"😀\"\n", []TextDocumentContentChangeEvent{{Range: rng(0, 7, 0, 7), Text: "!"}}, "s = \"😀!\"\n"},
+		{"append at end", "x = 1\n", []TextDocumentContentChangeEvent{{Range: rng(1, 0, 1, 0), Text: "print(x)\n"}}, "x = 1\nprint(x)\n"},
+		{"reversed range", "abc\n", []TextDocumentContentChangeEvent{{Range: rng(0, 2, 0, 1), Text: "X"}}, "abXc\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer()
+			uri := fileURI(filepath.Join(t.TempDir(), "main.pede"))
+			if err := s.didOpen(DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, Version: 1, Text: tt.text}}); err != nil {
+				t.Fatal(err)
+			}
+			p := DidChangeTextDocumentParams{TextDocument: VersionedTextDocumentIdentifier{URI: uri, Version: 2}, ContentChanges: tt.changes}
+			if err := s.didChange(p); err != nil {
+				t.Fatal(err)
+			}
+			doc, err := s.document(uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.text != tt.want || doc.version != 2 {
+				t.Errorf("didChange() text = %q at version %d, want %q at version 2", doc.text, doc.version, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenameAcrossModules(t *testing.T) {
+	dir := buildertest.WriteFiles(t, map[string]string{
+		"main.pede": "import \"lib\"\nprint(lib.area(2))\nprint(lib.area(3))\n",
+		"lib.pede":  "pub fn area(r: float): float {\n    return r * r\n}\n",
+	})
+	s := newTestServer()
+	uris := make(map[string]string)
+	for _, name := range []string{"main.pede", "lib.pede"} {
+		path := filepath.Join(dir, name)
+		src, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uris[name] = fileURI(path)
+		if err := s.didOpen(DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uris[name], Version: 1, Text: string(src)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		uri  string
+		pos  Position
+	}{
+		{"from a use", uris["main.pede"], Position{1, 11}},
+		{"from the declaration", uris["lib.pede"], Position{0, 8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			edit, err := s.rename(RenameParams{
+				TextDocumentPositionParams: TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: tt.uri}, Position: tt.pos},
+				NewName:                    "square",
+			})
+			if err != nil {
+				t.Fatalf("rename() error = %v", err)
+			}
+			want := map[string][]Range{
+				uris["main.pede"]: {{Position{1, 10}, Position{1, 14}}, {Position{2, 10}, Position{2, 14}}},
+				uris["lib.pede"]:  {{Position{0, 7}, Position{0, 11}}},
+			}
+			if len(edit.Changes) != len(want) {
+				t.Fatalf("rename() changed %d files, want %d: %+v", len(edit.Changes), len(want), edit.Changes)
+			}
+			for uri, ranges := range want {
+				var got []Range
+				for _, e := range edit.Changes[uri] {
+					if e.NewText != "square" {
+						t.Errorf("rename() edit in %s has text %q, want square", uri, e.NewText)
+					}
+					got = append(got, e.Range)
+				}
+				slices.SortFunc(got, func(a, b Range) int { return a.Start.Line - b.Start.Line })
+				if !slices.Equal(got, ranges) {
+					t.Errorf("rename() edits in %s = %+v, want %+v", uri, got, ranges)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameRejects(t *testing.T) {
+	s := newTestServer()
+	uri := fileURI(filepath.Join(t.TempDir(), "main.pede"))
+	if err := s.didOpen(DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, Version: 1, Text: "x = 1\nprint(x)\n"}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		pos     Position
+		newName string
+		want    string
+	}{
+		{Position{0, 4}, "y", "no symbol to rename here"},
+		{Position{0, 0}, "len", "len is not a valid name"},
+		{Position{0, 0}, "if", "if is not a valid name"},
+		{Position{0, 0}, "1y", "1y is not a valid name"},
+	}
+	for _, tt := range tests {
+		_, err := s.rename(RenameParams{
+			TextDocumentPositionParams: TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: tt.pos},
+			NewName:                    tt.newName,
+		})
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("rename(%+v, %q) error = %v, want %q", tt.pos, tt.newName, err, tt.want)
+		}
+	}
+}
+
+// session writes requests framed as by a client and reads the messages the server sends back.
+type session struct {
+	in  strings.Builder
+	out strings.Builder
+	id  int
+}
+
+func (s *session) send(method string, params any) {
+	s.id++
+	s.write(map[string]any{"jsonrpc": "2.0", "id": s.id, "method": method, "params": params})
+}
+
+func (s *session) notify(method string, params any) {
+	s.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *session) write(msg map[string]any) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Fprintf(&s.in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// messages returns the messages the server sent.
+func (s *session) messages(t *testing.T) []message {
+	t.Helper()
+	r := textproto.NewReader(bufio.NewReader(strings.NewReader(s.out.String())))
+	var msgs []message
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err == io.EOF {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("reading header: %v", err)
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			t.Fatalf("invalid Content-Length: %v", err)
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			t.Fatalf("reading body: %v", err)
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("invalid message %s: %v", body, err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func TestSession(t *testing.T) {
+	uri := fileURI(filepath.Join(t.TempDir(), "main.pede"))
+	doc := map[string]any{"uri": uri}
+	var s session
+	s.send("initialize", map[string]any{})
+	s.notify("initialized", map[string]any{})
+	s.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "languageId": "pede", "version": 1, "text": "x = 1\nprint(y)\n"}})
+	s.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []any{map[string]any{"range": Range{Position{1, 6}, Position{1, 7}}, "text": "x"}},
+	})
+	s.send("textDocument/hover", map[string]any{"textDocument": doc, "position": Position{1, 6}})
+	s.send("textDocument/definition", map[string]any{"textDocument": doc, "position": Position{1, 6}})
+	s.send("textDocument/formatting", map[string]any{"textDocument": doc})
+	s.send("shutdown", nil)
+	s.notify("exit", nil)
+
+	server := NewServer(strings.NewReader(s.in.String()), &s.out, nil)
+	if err := server.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	msgs := s.messages(t)
+
+	var diags []PublishDiagnosticsParams
+	results := make(map[int]message)
+	for _, msg := range msgs {
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var p PublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &p); err != nil {
+				t.Fatal(err)
+			}
+			diags = append(diags, p)
+			continue
+		}
+		var id int
+		if msg.ID == nil || json.Unmarshal(*msg.ID, &id) != nil {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		results[id] = msg
+	}
+
+	if len(diags) != 2 {
+		t.Fatalf("published diagnostics %d times, want 2: %+v", len(diags), diags)
+	}
+	if d := diags[0].Diagnostics; len(d) != 1 || d[0].Code != "E0501" || d[0].Range != (Range{Position{1, 6}, Position{1, 7}}) {
+		t.Errorf("diagnostics after didOpen = %+v, want undefined variable y at 1:6", d)
+	}
+	if d := diags[1].Diagnostics; len(d) != 0 || *diags[1].Version != 2 {
+		t.Errorf("diagnostics after didChange = %+v at version %d, want none at version 2", d, *diags[1].Version)
+	}
+
+	var init struct {
+		Capabilities struct {
+			TextDocumentSync struct {
+				Change int `json:"change"`
+			} `json:"textDocumentSync"`
+			RenameProvider bool `json:"renameProvider"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(results[1].Result, &init); err != nil || init.Capabilities.TextDocumentSync.Change != 2 || !init.Capabilities.RenameProvider {
+		t.Errorf("initialize result = %s, want incremental sync and rename", results[1].Result)
+	}
+	var hover Hover
+	if err := json.Unmarshal(results[2].Result, &hover); err != nil || !strings.Contains(hover.Contents.Value, "x: float") {
+		t.Errorf("hover result = %s, want the type of x", results[2].Result)
+	}
+	var defs []Location
+	want := []Location{{URI: uri, Range: Range{Position{0, 0}, Position{0, 1}}}}
+	if err := json.Unmarshal(results[3].Result, &defs); err != nil || !slices.Equal(defs, want) {
+		t.Errorf("definition result = %s, want %+v", results[3].Result, want)
+	}
+	if e := results[4].Error; e == nil || e.Code != codeMethodNotFound {
+		t.Errorf("formatting response = %+v, want a method not found error", results[4])
+	}
+	if r := results[5]; r.Error != nil || string(r.Result) != "null" {
+		t.Errorf("shutdown response = %+v, want a null result", r)
+	}
+}
+
+func TestRunRequiresShutdown(t *testing.T) {
+	var s session
+	s.notify("exit", nil)
+	if err := NewServer(strings.NewReader(s.in.String()), &s.out, nil).Run(); err != errExitWithoutShutdown {
+		t.Errorf("Run() error = %v, want %v", err, errExitWithoutShutdown)
+	}
+}
@@ -3,8 +3,10 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
 	"github.com/engpetarmarinov/pede/lexer"
 )
 
@@ -14,189 +16,1369 @@ type Parser struct {
 	curLine   int
 	curColumn int
 	curSource string
+	comments  []ast.Comment
+	// noBraceLit is set while parsing the header of if, while and for, where a '{'
+	// after an identifier opens the body rather than a struct literal.
+	noBraceLit bool
+	// err is the error of reading the first token, which Parse returns.
+	err error
 }
 
 func NewParser(lx *lexer.Lexer) *Parser {
 	p := &Parser{lx: lx}
-	p.next()
+	p.err = p.next()
 	return p
 }
 
+// next advances to the next token, setting aside the comments before it.
 func (p *Parser) next() error {
 	tok, err := p.lx.Next()
+	for err == nil && tok.Type == lexer.TokenComment {
+		p.comments = append(p.comments, ast.Comment{Pos: ast.Pos{Line: tok.Line, Column: tok.Col}, Text: tok.Value})
+		tok, err = p.lx.Next()
+	}
 	if err != nil {
 		return err
 	}
 	p.cur = tok
 	if p.lx != nil {
-		p.curLine = p.lx.Line
-		p.curColumn = p.lx.Col
-		p.curSource = p.lx.CurrentLineSource()
+		p.curLine = tok.Line
+		p.curColumn = tok.Col
+		p.curSource = p.lx.LineSource(tok.Line)
+	}
+	return nil
+}
+
+// pos returns the position of the current token.
+func (p *Parser) pos() ast.Pos {
+	return ast.Pos{Line: p.curLine, Column: p.curColumn}
+}
+
+// errorf returns a parser error pointing at the current token.
+func (p *Parser) errorf(format string, args ...any) error {
+	start := diag.Pos{Line: p.curLine, Column: p.curColumn}
+	end := diag.Pos{Line: p.curLine, Column: max(p.cur.End, p.curColumn+1)}
+	return diag.Errorf(diag.CodeSyntax, diag.Span{Start: start, End: end}, p.curSource, format, args...)
+}
+
+// expect consumes the current token if it has type tt, otherwise it returns an error with msg.
+func (p *Parser) expect(tt lexer.TokenType, msg string) error {
+	if p.cur.Type != tt {
+		return p.errorf("%s", msg)
+	}
+	return p.next()
+}
+
+// setBraceLits sets whether `Name{` starts a struct literal and returns a func restoring the previous setting.
+func (p *Parser) setBraceLits(allowed bool) func() {
+	old := p.noBraceLit
+	p.noBraceLit = !allowed
+	return func() { p.noBraceLit = old }
+}
+
+// parseHeaderExpr parses the expression in the header of an if, while or for statement.
+func (p *Parser) parseHeaderExpr() (ast.Expr, error) {
+	defer p.setBraceLits(false)()
+	return p.parseExpr()
+}
+
+// skipNewlines advances past any NEWLINE tokens.
+func (p *Parser) skipNewlines() error {
+	for p.cur.Type == lexer.TokenNewline {
+		if err := p.next(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 // Parse parses a program (sequence of statements)
 func (p *Parser) Parse() (*ast.Program, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
 	stmts := []ast.Stmt{}
 	for {
 		// Skip any NEWLINE tokens before parsing a statement
-		for p.cur.Type == lexer.TokenNewline {
-			if err := p.next(); err != nil {
-				return nil, err
-			}
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
 		}
 		if p.cur.Type == lexer.TokenEOF {
 			break
 		}
-		stmt, err := p.parseStmt()
+		stmt, err := p.parseTopLevel()
 		if err != nil {
 			return nil, err
 		}
 		stmts = append(stmts, stmt)
 	}
-	return &ast.Program{Stmts: stmts}, nil
+	return &ast.Program{Stmts: stmts, Comments: p.comments}, nil
 }
 
-// parseStmt parses a single statement
-func (p *Parser) parseStmt() (ast.Stmt, error) {
-	// Skip over any NEWLINE tokens
-	for p.cur.Type == lexer.TokenNewline {
+// parseTopLevel parses a declaration, which may only appear at the top level, or a statement.
+func (p *Parser) parseTopLevel() (ast.Stmt, error) {
+	pub := false
+	if p.cur.Type == lexer.TokenPub {
+		pub = true
 		if err := p.next(); err != nil {
 			return nil, err
 		}
+		switch p.cur.Type {
+		case lexer.TokenFn, lexer.TokenExport, lexer.TokenExtern, lexer.TokenTypeDecl, lexer.TokenEnum, lexer.TokenConst:
+		default:
+			return nil, p.errorf("expected fn, export, extern, type, enum or const after pub")
+		}
 	}
-	if p.cur.Type == lexer.TokenPrint {
-		return p.parsePrint()
+	switch p.cur.Type {
+	case lexer.TokenImport:
+		return p.parseImport()
+	case lexer.TokenConst:
+		return p.parseConstDecl(pub)
+	case lexer.TokenFn:
+		return p.parseFuncDecl(pub, false)
+	case lexer.TokenExport:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type != lexer.TokenFn {
+			return nil, p.errorf("expected fn after export")
+		}
+		return p.parseFuncDecl(pub, true)
+	case lexer.TokenExtern:
+		return p.parseExternDecl(pub)
+	case lexer.TokenTypeDecl:
+		return p.parseTypeDecl(pub)
+	case lexer.TokenEnum:
+		return p.parseEnumDecl(pub)
+	case lexer.TokenTest, lexer.TokenBench:
+		return p.parseTestDecl()
+	}
+	return p.parseStmt()
+}
+
+// parseImport parses: import [name] "path"
+func (p *Parser) parseImport() (ast.Stmt, error) {
+	decl := &ast.ImportDecl{Pos: p.pos()}
+	if err := p.next(); err != nil {
+		return nil, err
 	}
 	if p.cur.Type == lexer.TokenIdent {
-		name := p.cur.Value
+		decl.Name = p.cur.Value
 		if err := p.next(); err != nil {
 			return nil, err
 		}
-		if p.cur.Type != lexer.TokenEqual {
-			return nil, &lexer.Error{
-				Message:    "parser: expected '=' after identifier",
-				Line:       p.curLine,
-				Column:     p.curColumn,
-				LineSource: p.curSource,
-			}
+	}
+	if p.cur.Type != lexer.TokenString {
+		return nil, p.errorf("expected import path string")
+	}
+	decl.Path = p.cur.Value
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if decl.Name == "" {
+		decl.Name = decl.Path[strings.LastIndex(decl.Path, "/")+1:]
+	}
+	return decl, nil
+}
+
+// parseFuncDecl parses: fn name(param: Type, ...)[: Result] { ... }
+func (p *Parser) parseFuncDecl(pub, export bool) (ast.Stmt, error) {
+	decl := &ast.FuncDecl{Pos: p.pos(), Pub: pub, Export: export}
+	if err := p.parseSignature(decl); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	decl.Body = body
+	return decl, nil
+}
+
+// parseTestDecl parses: test "name" { ... } or bench "name" { ... }
+func (p *Parser) parseTestDecl() (ast.Stmt, error) {
+	pos, keyword := p.pos(), p.cur
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type != lexer.TokenString {
+		return nil, p.errorf("expected %s name string after %s", keyword.Value, keyword.Value)
+	}
+	name := p.cur.Value
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	if keyword.Type == lexer.TokenBench {
+		return &ast.BenchDecl{Pos: pos, Name: name, Body: body}, nil
+	}
+	return &ast.TestDecl{Pos: pos, Name: name, Body: body}, nil
+}
+
+// parseExternDecl parses: extern fn name(param: Type, ...)[: Result]
+func (p *Parser) parseExternDecl(pub bool) (ast.Stmt, error) {
+	decl := &ast.FuncDecl{Pos: p.pos(), Pub: pub, Extern: true}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type != lexer.TokenFn {
+		return nil, p.errorf("expected fn after extern")
+	}
+	if err := p.parseSignature(decl); err != nil {
+		return nil, err
+	}
+	if p.cur.Type == lexer.TokenLBrace {
+		return nil, p.errorf("extern function %s cannot have a body", decl.Name)
+	}
+	return decl, nil
+}
+
+// parseSignature parses the name, parameters and result of a function, starting at fn.
+func (p *Parser) parseSignature(decl *ast.FuncDecl) error {
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.cur.Type != lexer.TokenIdent {
+		return p.errorf("expected function name after fn")
+	}
+	decl.Name = p.cur.Value
+	if err := p.next(); err != nil {
+		return err
+	}
+	if p.cur.Type != lexer.TokenLParen {
+		return p.errorf("expected '(' after function name")
+	}
+	params, err := p.parseParams(false)
+	if err != nil {
+		return err
+	}
+	decl.Params = params
+	result, err := p.parseResult()
+	if err != nil {
+		return err
+	}
+	decl.Result = result
+	return nil
+}
+
+// parseParams parses a parenthesized parameter list: (name: Type, ...)
+// With untyped set, the type annotations may be left out.
+func (p *Parser) parseParams(untyped bool) ([]ast.Field, error) {
+	if err := p.expect(lexer.TokenLParen, "expected '('"); err != nil {
+		return nil, err
+	}
+	var params []ast.Field
+	for p.cur.Type != lexer.TokenRParen {
+		if p.cur.Type != lexer.TokenIdent {
+			return nil, p.errorf("expected parameter name, got %s", p.cur)
 		}
+		param := ast.Field{Pos: p.pos(), Name: p.cur.Value}
 		if err := p.next(); err != nil {
 			return nil, err
 		}
-		expr, err := p.parseExpr()
-		if err != nil {
+		if p.cur.Type == lexer.TokenColon || !untyped {
+			if err := p.expect(lexer.TokenColon, "expected ':' after parameter name"); err != nil {
+				return nil, err
+			}
+			typ, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			param.Type = typ
+		}
+		params = append(params, param)
+		if p.cur.Type != lexer.TokenComma {
+			break
+		}
+		if err := p.next(); err != nil {
 			return nil, err
 		}
-		return &ast.Assignment{Name: name, Expr: expr}, nil
 	}
-	return nil, &lexer.Error{
-		Message:    fmt.Sprintf("parser: unexpected token: %v", p.cur),
-		Line:       p.curLine,
-		Column:     p.curColumn,
-		LineSource: p.curSource,
+	if err := p.expect(lexer.TokenRParen, "expected ')' after parameters"); err != nil {
+		return nil, err
 	}
+	return params, nil
 }
 
-// parsePrint parses a print statement: print(expr)
-func (p *Parser) parsePrint() (ast.Stmt, error) {
+// parseResult parses the optional `: Result` following a parameter list.
+func (p *Parser) parseResult() (ast.TypeExpr, error) {
+	if p.cur.Type != lexer.TokenColon {
+		return nil, nil
+	}
 	if err := p.next(); err != nil {
 		return nil, err
 	}
-	if p.cur.Type != lexer.TokenLParen {
-		return nil, &lexer.Error{
-			Message:    "parser: expected '(' after print",
-			Line:       p.curLine,
-			Column:     p.curColumn,
-			LineSource: p.curSource,
+	return p.parseType()
+}
+
+// parseReturn parses: return [expr]
+func (p *Parser) parseReturn() (ast.Stmt, error) {
+	stmt := &ast.ReturnStmt{Pos: p.pos()}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	switch p.cur.Type {
+	case lexer.TokenNewline, lexer.TokenRBrace, lexer.TokenEOF:
+		return stmt, nil
+	}
+	val, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Value = val
+	return stmt, nil
+}
+
+// parseStmt parses a single statement
+func (p *Parser) parseStmt() (ast.Stmt, error) {
+	// Skip over any NEWLINE tokens
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	switch p.cur.Type {
+	case lexer.TokenPrint:
+		return p.parsePrint()
+	case lexer.TokenIf:
+		return p.parseIf()
+	case lexer.TokenWhile:
+		return p.parseWhile()
+	case lexer.TokenFor:
+		return p.parseFor()
+	case lexer.TokenIdent:
+		return p.parseSimpleStmt()
+	case lexer.TokenMatch:
+		pos := p.pos()
+		m, err := p.parseMatch()
+		if err != nil {
+			return nil, err
 		}
+		return &ast.ExprStmt{Pos: pos, Expr: m}, nil
+	case lexer.TokenReturn:
+		return p.parseReturn()
+	case lexer.TokenLet:
+		return p.parseLet()
+	case lexer.TokenTry:
+		return p.parseTry()
+	case lexer.TokenTypeDecl, lexer.TokenEnum, lexer.TokenExport, lexer.TokenExtern, lexer.TokenImport, lexer.TokenPub, lexer.TokenConst, lexer.TokenTest, lexer.TokenBench:
+		return nil, p.errorf("%s declarations are only allowed at the top level", p.cur.Value)
+	}
+	pos := p.pos()
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
 	}
+	return &ast.ExprStmt{Pos: pos, Expr: expr}, nil
+}
+
+// parseTry parses: try { ... } catch [name] { ... }
+func (p *Parser) parseTry() (ast.Stmt, error) {
+	stmt := &ast.TryStmt{Pos: p.pos()}
 	if err := p.next(); err != nil {
 		return nil, err
 	}
-	expr, err := p.parseExpr()
+	body, err := p.parseBlock()
 	if err != nil {
 		return nil, err
 	}
-	if p.cur.Type != lexer.TokenRParen {
-		return nil, &lexer.Error{
-			Message:    "parser: expected ')' after print expression",
-			Line:       p.curLine,
-			Column:     p.curColumn,
-			LineSource: p.curSource,
+	stmt.Body = body
+	if err := p.expect(lexer.TokenCatch, "expected catch after try block"); err != nil {
+		return nil, err
+	}
+	if p.cur.Type == lexer.TokenIdent {
+		stmt.Var = p.cur.Value
+		if err := p.next(); err != nil {
+			return nil, err
 		}
 	}
-	if err := p.next(); err != nil {
+	catch, err := p.parseBlock()
+	if err != nil {
 		return nil, err
 	}
-	return &ast.PrintStmt{Expr: expr}, nil
+	stmt.Catch = catch
+	return stmt, nil
 }
 
-func (p *Parser) parseExpr() (ast.Expr, error) {
-	left, err := p.parseTerm()
+// parseConstDecl parses: const name [: Type] = expr
+func (p *Parser) parseConstDecl(pub bool) (ast.Stmt, error) {
+	pos := p.pos()
+	name, typ, expr, err := p.parseBinding("const")
 	if err != nil {
 		return nil, err
 	}
-	for p.cur.Type == lexer.TokenPlus {
-		op := p.cur.Value
+	return &ast.ConstDecl{Pos: pos, Pub: pub, Name: name, Type: typ, Value: expr}, nil
+}
+
+// parseLet parses: let name [: Type] = expr
+func (p *Parser) parseLet() (ast.Stmt, error) {
+	pos := p.pos()
+	name, typ, expr, err := p.parseBinding("let")
+	if err != nil {
+		return nil, err
+	}
+	return &ast.Assignment{Pos: pos, Let: true, Name: name, Type: typ, Expr: expr}, nil
+}
+
+// parseBinding parses `name [: Type] = expr` following the keyword at the current token.
+func (p *Parser) parseBinding(keyword string) (string, ast.TypeExpr, ast.Expr, error) {
+	if err := p.next(); err != nil {
+		return "", nil, nil, err
+	}
+	if p.cur.Type != lexer.TokenIdent {
+		return "", nil, nil, p.errorf("expected name after %s", keyword)
+	}
+	name := p.cur.Value
+	if err := p.next(); err != nil {
+		return "", nil, nil, err
+	}
+	var typ ast.TypeExpr
+	if p.cur.Type == lexer.TokenColon {
 		if err := p.next(); err != nil {
-			return nil, err
+			return "", nil, nil, err
 		}
-		right, err := p.parseTerm()
+		t, err := p.parseType()
 		if err != nil {
-			return nil, err
+			return "", nil, nil, err
 		}
-		left = &ast.Binary{Op: op, Left: left, Right: right}
+		typ = t
 	}
-	return left, nil
+	if err := p.expect(lexer.TokenEqual, fmt.Sprintf("expected '=' after %s %s", keyword, name)); err != nil {
+		return "", nil, nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return name, typ, expr, nil
 }
 
-func (p *Parser) parseTerm() (ast.Expr, error) {
-	left, err := p.parseFactor()
-	if err != nil {
+// parseSimpleStmt parses a statement starting with an identifier: an assignment `name [: type] = expr`,
+// an element assignment `xs[i] = expr`, a field assignment `p.x = expr` or a call.
+func (p *Parser) parseSimpleStmt() (ast.Stmt, error) {
+	pos := p.pos()
+	name := p.cur.Value
+	if err := p.next(); err != nil {
 		return nil, err
 	}
-	for p.cur.Type == lexer.TokenStar {
-		op := p.cur.Value
+	if p.cur.Type == lexer.TokenColon {
 		if err := p.next(); err != nil {
 			return nil, err
 		}
-		right, err := p.parseFactor()
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenEqual, "expected '=' after type annotation"); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
-		left = &ast.Binary{Op: op, Left: left, Right: right}
+		return &ast.Assignment{Pos: pos, Name: name, Type: typ, Expr: expr}, nil
 	}
-	return left, nil
+	target, err := p.parsePostfix(&ast.Variable{Pos: pos, Name: name})
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := compoundOps[p.cur.Type]; ok {
+		return p.parseCompoundAssign(pos, target, op)
+	}
+	if p.cur.Type != lexer.TokenEqual {
+		// Anything else is an expression statement; sema decides whether its value may be dropped
+		expr, err := p.parseBinaryFrom(0, target)
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ExprStmt{Pos: pos, Expr: expr}, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	switch t := target.(type) {
+	case *ast.Variable:
+		return &ast.Assignment{Pos: pos, Name: t.Name, Expr: expr}, nil
+	case *ast.Index:
+		return &ast.IndexAssign{Pos: pos, Target: t, Expr: expr}, nil
+	case *ast.Selector:
+		return &ast.FieldAssign{Pos: pos, Target: t, Expr: expr}, nil
+	}
+	return nil, p.errorf("cannot assign to a call")
 }
 
-func (p *Parser) parseFactor() (ast.Expr, error) {
-	switch p.cur.Type {
-	case lexer.TokenNumber:
-		val, _ := strconv.ParseFloat(p.cur.Value, 64)
-		if err := p.next(); err != nil {
+// compoundOps maps the compound assignment tokens to the arithmetic operator they apply.
+var compoundOps = map[lexer.TokenType]string{
+	lexer.TokenPlusEq:  lexer.TokenPlus,
+	lexer.TokenMinusEq: lexer.TokenMinus,
+	lexer.TokenStarEq:  lexer.TokenStar,
+	lexer.TokenSlashEq: lexer.TokenSlash,
+	lexer.TokenInc:     lexer.TokenPlus,
+	lexer.TokenDec:     lexer.TokenMinus,
+}
+
+// parseCompoundAssign parses the rest of `target op= expr`, `target++` or `target--`.
+func (p *Parser) parseCompoundAssign(pos ast.Pos, target ast.Expr, op string) (ast.Stmt, error) {
+	switch target.(type) {
+	case *ast.Variable, *ast.Index, *ast.Selector:
+	default:
+		return nil, p.errorf("cannot assign to a call")
+	}
+	stmt := &ast.CompoundAssign{Pos: pos, Target: target, Op: op}
+	opPos, opType := p.pos(), p.cur.Type
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if opType == lexer.TokenInc || opType == lexer.TokenDec {
+		stmt.Expr = &ast.Number{Pos: opPos, Value: 1}
+		stmt.IncDec = true
+		return stmt, nil
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Expr = expr
+	return stmt, nil
+}
+
+// parseTypeDecl parses a struct declaration: type Name { field: Type, ... }
+// Fields are separated by commas or newlines.
+func (p *Parser) parseTypeDecl(pub bool) (ast.Stmt, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type != lexer.TokenIdent {
+		return nil, p.errorf("expected type name after type")
+	}
+	decl := &ast.TypeDecl{Pos: pos, Pub: pub, Name: p.cur.Value}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenLBrace, "expected '{' after type name"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.skipNewlines(); err != nil {
 			return nil, err
 		}
-		return &ast.Number{Value: val}, nil
-	case lexer.TokenIdent:
-		name := p.cur.Value
+		if p.cur.Type == lexer.TokenRBrace {
+			break
+		}
+		if p.cur.Type != lexer.TokenIdent {
+			return nil, p.errorf("expected field name, got %s", p.cur)
+		}
+		field := ast.Field{Pos: p.pos(), Name: p.cur.Value}
 		if err := p.next(); err != nil {
 			return nil, err
 		}
-		return &ast.Variable{Name: name}, nil
-	case lexer.TokenString:
-		str := p.cur.Value
-		if err := p.next(); err != nil {
+		if err := p.expect(lexer.TokenColon, "expected ':' after field name"); err != nil {
 			return nil, err
 		}
-		return &ast.String{Value: str}, nil
-	default:
-		return nil, &lexer.Error{
-			Message:    fmt.Sprintf("parser: unexpected token in expression: %v", p.cur),
-			Line:       p.curLine,
-			Column:     p.curColumn,
-			LineSource: p.curSource,
+		typ, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		field.Type = typ
+		decl.Fields = append(decl.Fields, field)
+		if p.cur.Type == lexer.TokenComma {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else if p.cur.Type != lexer.TokenNewline && p.cur.Type != lexer.TokenRBrace {
+			return nil, p.errorf("expected ',' or newline after field")
+		}
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return decl, nil
+}
+
+// parseEnumDecl parses an enum declaration: enum Name { Variant, Variant(field, field: Type), ... }
+// Variants are separated by commas or newlines.
+func (p *Parser) parseEnumDecl(pub bool) (ast.Stmt, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type != lexer.TokenIdent {
+		return nil, p.errorf("expected enum name after enum")
+	}
+	decl := &ast.EnumDecl{Pos: pos, Pub: pub, Name: p.cur.Value}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenLBrace, "expected '{' after enum name"); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenRBrace {
+			break
+		}
+		if p.cur.Type != lexer.TokenIdent {
+			return nil, p.errorf("expected variant name, got %s", p.cur)
+		}
+		variant := ast.Variant{Pos: p.pos(), Name: p.cur.Value}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenLParen {
+			fields, err := p.parseVariantFields()
+			if err != nil {
+				return nil, err
+			}
+			variant.Fields = fields
+		}
+		decl.Variants = append(decl.Variants, variant)
+		if p.cur.Type == lexer.TokenComma {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else if p.cur.Type != lexer.TokenNewline && p.cur.Type != lexer.TokenRBrace {
+			return nil, p.errorf("expected ',' or newline after variant")
+		}
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return decl, nil
+}
+
+// parseVariantFields parses the payload of an enum variant: (name, name: Type, ...)
+func (p *Parser) parseVariantFields() ([]ast.Field, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var fields []ast.Field
+	for p.cur.Type != lexer.TokenRParen {
+		if p.cur.Type != lexer.TokenIdent {
+			return nil, p.errorf("expected field name, got %s", p.cur)
+		}
+		field := ast.Field{Pos: p.pos(), Name: p.cur.Value}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenColon {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			typ, err := p.parseType()
+			if err != nil {
+				return nil, err
+			}
+			field.Type = typ
+		}
+		fields = append(fields, field)
+		if p.cur.Type != lexer.TokenComma {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(lexer.TokenRParen, "expected ')' after variant fields"); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// parseMatch parses: match expr { Variant(a, b) => expr, _ => { ... } }
+// Arms are separated by commas or newlines.
+func (p *Parser) parseMatch() (*ast.Match, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	subject, err := p.parseHeaderExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenLBrace, "expected '{' after match subject"); err != nil {
+		return nil, err
+	}
+	defer p.setBraceLits(true)()
+	m := &ast.Match{Pos: pos, Subject: subject}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenRBrace {
+			break
+		}
+		arm, err := p.parseMatchArm()
+		if err != nil {
+			return nil, err
+		}
+		m.Arms = append(m.Arms, arm)
+		if p.cur.Type == lexer.TokenComma {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else if p.cur.Type != lexer.TokenNewline && p.cur.Type != lexer.TokenRBrace {
+			return nil, p.errorf("expected ',' or newline after match arm")
+		}
+	}
+	m.End = p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parseMatchArm parses one arm: Variant(a, b) => body
+func (p *Parser) parseMatchArm() (*ast.MatchArm, error) {
+	if p.cur.Type != lexer.TokenIdent {
+		return nil, p.errorf("expected variant name or '_' in match arm, got %s", p.cur)
+	}
+	arm := &ast.MatchArm{Pos: p.pos(), Variant: p.cur.Value}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type == lexer.TokenLParen {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for p.cur.Type != lexer.TokenRParen {
+			if p.cur.Type != lexer.TokenIdent {
+				return nil, p.errorf("expected binding name, got %s", p.cur)
+			}
+			arm.Bindings = append(arm.Bindings, p.cur.Value)
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.cur.Type != lexer.TokenComma {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(lexer.TokenRParen, "expected ')' after bindings"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(lexer.TokenArrow, "expected '=>' in match arm"); err != nil {
+		return nil, err
+	}
+	if p.cur.Type == lexer.TokenLBrace {
+		block, err := p.parseBlock()
+		if err != nil {
+			return nil, err
+		}
+		arm.Block = block
+		return arm, nil
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	arm.Expr = expr
+	return arm, nil
+}
+
+// parseStructLit parses the fields of a struct literal after its type name: {field: value, ...}
+func (p *Parser) parseStructLit(pos ast.Pos, module, name string) (*ast.StructLit, error) {
+	defer p.setBraceLits(true)()
+	lit := &ast.StructLit{Pos: pos, Module: module, Type: name}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenRBrace {
+			break
+		}
+		if p.cur.Type != lexer.TokenIdent {
+			return nil, p.errorf("expected field name, got %s", p.cur)
+		}
+		field := ast.FieldInit{Pos: p.pos(), Name: p.cur.Value}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenColon, "expected ':' after field name"); err != nil {
+			return nil, err
+		}
+		val, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		field.Value = val
+		lit.Fields = append(lit.Fields, field)
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type != lexer.TokenComma {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(lexer.TokenRBrace, "expected '}' after struct fields"); err != nil {
+		return nil, err
+	}
+	return lit, nil
+}
+
+// parseType parses a type: float, string, bool, a declared type name, [T] or {K: V}
+func (p *Parser) parseType() (ast.TypeExpr, error) {
+	pos := p.pos()
+	switch p.cur.Type {
+	case lexer.TokenIdent:
+		name := p.cur.Value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type != lexer.TokenDot {
+			return &ast.NamedType{Pos: pos, Name: name}, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type != lexer.TokenIdent {
+			return nil, p.errorf("expected type name after '%s.'", name)
+		}
+		typ := &ast.NamedType{Pos: pos, Module: name, Name: p.cur.Value}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return typ, nil
+	case lexer.TokenLBracket:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenRBracket, "expected ']' after list element type"); err != nil {
+			return nil, err
+		}
+		return &ast.ListType{Pos: pos, Elem: elem}, nil
+	case lexer.TokenLBrace:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		key, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenColon, "expected ':' after map key type"); err != nil {
+			return nil, err
+		}
+		val, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenRBrace, "expected '}' after map value type"); err != nil {
+			return nil, err
+		}
+		return &ast.MapType{Pos: pos, Key: key, Value: val}, nil
+	case lexer.TokenFn:
+		return p.parseFuncType()
+	case lexer.TokenBang:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		val, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.ResultType{Pos: pos, Value: val}, nil
+	}
+	return nil, p.errorf("expected a type, got %s", p.cur)
+}
+
+// parseFuncType parses a function type: fn(Type, ...)[: Result]
+func (p *Parser) parseFuncType() (ast.TypeExpr, error) {
+	typ := &ast.FuncType{Pos: p.pos()}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenLParen, "expected '(' after fn"); err != nil {
+		return nil, err
+	}
+	for p.cur.Type != lexer.TokenRParen {
+		param, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		typ.Params = append(typ.Params, param)
+		if p.cur.Type != lexer.TokenComma {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(lexer.TokenRParen, "expected ')' after parameter types"); err != nil {
+		return nil, err
+	}
+	result, err := p.parseResult()
+	if err != nil {
+		return nil, err
+	}
+	typ.Result = result
+	return typ, nil
+}
+
+// parseFuncLit parses an anonymous function: fn(param[: Type], ...)[: Result] { ... }
+func (p *Parser) parseFuncLit() (*ast.FuncLit, error) {
+	lit := &ast.FuncLit{Pos: p.pos()}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type == lexer.TokenIdent {
+		return nil, p.errorf("function declarations are only allowed at the top level")
+	}
+	params, err := p.parseParams(true)
+	if err != nil {
+		return nil, err
+	}
+	lit.Params = params
+	result, err := p.parseResult()
+	if err != nil {
+		return nil, err
+	}
+	lit.Result = result
+	// The body is a block of its own, even inside the header of an if, while or for
+	defer p.setBraceLits(true)()
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	lit.Body = body
+	return lit, nil
+}
+
+// parsePrint parses a print statement: print(expr)
+func (p *Parser) parsePrint() (ast.Stmt, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenLParen, "expected '(' after print"); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenRParen, "expected ')' after print expression"); err != nil {
+		return nil, err
+	}
+	return &ast.PrintStmt{Pos: pos, Expr: expr}, nil
+}
+
+// parseBlock parses a brace-delimited list of statements: { stmt* }
+func (p *Parser) parseBlock() (*ast.Block, error) {
+	pos := p.pos()
+	if err := p.expect(lexer.TokenLBrace, "expected '{'"); err != nil {
+		return nil, err
+	}
+	block := &ast.Block{Pos: pos}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenRBrace {
+			break
+		}
+		if p.cur.Type == lexer.TokenEOF {
+			return nil, p.errorf("expected '}' before end of file")
+		}
+		stmt, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		block.Stmts = append(block.Stmts, stmt)
+	}
+	block.End = p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// parseIf parses: if expr { ... } [else if ... | else { ... }]
+func (p *Parser) parseIf() (ast.Stmt, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	cond, err := p.parseHeaderExpr()
+	if err != nil {
+		return nil, err
+	}
+	then, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	stmt := &ast.IfStmt{Pos: pos, Cond: cond, Then: then}
+	if p.cur.Type != lexer.TokenElse {
+		return stmt, nil
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type == lexer.TokenIf {
+		stmt.Else, err = p.parseIf()
+	} else {
+		stmt.Else, err = p.parseBlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseWhile parses: while expr { ... }
+func (p *Parser) parseWhile() (ast.Stmt, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	cond, err := p.parseHeaderExpr()
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.WhileStmt{Pos: pos, Cond: cond, Body: body}, nil
+}
+
+// parseFor parses: for name in expr { ... }
+func (p *Parser) parseFor() (ast.Stmt, error) {
+	pos := p.pos()
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.cur.Type != lexer.TokenIdent {
+		return nil, p.errorf("expected loop variable after for")
+	}
+	name := p.cur.Value
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(lexer.TokenIn, "expected 'in' after loop variable"); err != nil {
+		return nil, err
+	}
+	iter, err := p.parseHeaderExpr()
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.ForStmt{Pos: pos, Var: name, Iter: iter, Body: body}, nil
+}
+
+// binaryLevels lists binary operators from the lowest to the highest precedence.
+var binaryLevels = [][]lexer.TokenType{
+	{lexer.TokenOr},
+	{lexer.TokenAnd},
+	{lexer.TokenEqEq, lexer.TokenNotEq},
+	{lexer.TokenLess, lexer.TokenLessEq, lexer.TokenGreater, lexer.TokenGreaterEq},
+	{lexer.TokenPlus, lexer.TokenMinus},
+	{lexer.TokenStar, lexer.TokenSlash},
+}
+
+// Precedence returns the precedence level of the binary operator op, higher levels binding
+// tighter, or -1 if op is no binary operator.
+func Precedence(op string) int {
+	for level, ops := range binaryLevels {
+		if isOneOf(lexer.TokenType(op), ops) {
+			return level
+		}
+	}
+	return -1
+}
+
+func (p *Parser) parseExpr() (ast.Expr, error) {
+	return p.parseBinary(0)
+}
+
+// parseBinary parses a left-associative chain of operators at the given precedence level.
+func (p *Parser) parseBinary(level int) (ast.Expr, error) {
+	return p.parseBinaryFrom(level, nil)
+}
+
+// parseBinaryFrom is parseBinary with the leftmost operand already parsed as first, if non-nil.
+func (p *Parser) parseBinaryFrom(level int, first ast.Expr) (ast.Expr, error) {
+	if level == len(binaryLevels) {
+		if first != nil {
+			return first, nil
+		}
+		return p.parseUnary()
+	}
+	left, err := p.parseBinaryFrom(level+1, first)
+	if err != nil {
+		return nil, err
+	}
+	for isOneOf(p.cur.Type, binaryLevels[level]) {
+		pos := p.pos()
+		op := p.cur.Value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &ast.Binary{Pos: pos, Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseUnary() (ast.Expr, error) {
+	if p.cur.Type == lexer.TokenMinus || p.cur.Type == lexer.TokenBang {
+		pos := p.pos()
+		op := p.cur.Value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &ast.Unary{Pos: pos, Op: op, Operand: operand}, nil
+	}
+	return p.parseFactor()
+}
+
+func (p *Parser) parseFactor() (ast.Expr, error) {
+	pos := p.pos()
+	switch p.cur.Type {
+	case lexer.TokenNumber:
+		val, _ := strconv.ParseFloat(p.cur.Value, 64)
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &ast.Number{Pos: pos, Value: val}, nil
+	case lexer.TokenIdent:
+		name := p.cur.Value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenLBrace && !p.noBraceLit {
+			lit, err := p.parseStructLit(pos, "", name)
+			if err != nil {
+				return nil, err
+			}
+			return p.parsePostfix(lit)
+		}
+		return p.parsePostfix(&ast.Variable{Pos: pos, Name: name})
+	case lexer.TokenLBracket:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		elems, err := p.parseExprList(lexer.TokenRBracket, "expected ']' after list elements")
+		if err != nil {
+			return nil, err
+		}
+		return p.parsePostfix(&ast.ListLit{Pos: pos, Elems: elems})
+	case lexer.TokenLBrace:
+		lit, err := p.parseMapLit()
+		if err != nil {
+			return nil, err
+		}
+		return p.parsePostfix(lit)
+	case lexer.TokenString:
+		str := p.cur.Value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &ast.String{Pos: pos, Value: str}, nil
+	case lexer.TokenMatch:
+		m, err := p.parseMatch()
+		if err != nil {
+			return nil, err
+		}
+		return p.parsePostfix(m)
+	case lexer.TokenTrue, lexer.TokenFalse:
+		val := p.cur.Type == lexer.TokenTrue
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		return &ast.Bool{Pos: pos, Value: val}, nil
+	case lexer.TokenFn:
+		lit, err := p.parseFuncLit()
+		if err != nil {
+			return nil, err
+		}
+		return p.parsePostfix(lit)
+	case lexer.TokenLParen:
+		defer p.setBraceLits(true)()
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenRParen, "expected ')' after expression"); err != nil {
+			return nil, err
+		}
+		return p.parsePostfix(expr)
+	default:
+		return nil, p.errorf("unexpected %s in expression", p.cur)
+	}
+}
+
+// parsePostfix parses any index, call, selector and ? suffixes following x: x[i], x(args), x.name, x?
+func (p *Parser) parsePostfix(x ast.Expr) (ast.Expr, error) {
+	for {
+		pos := p.pos()
+		switch p.cur.Type {
+		case lexer.TokenQuestion:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			x = &ast.Propagate{Pos: pos, X: x}
+		case lexer.TokenLBracket:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			restore := p.setBraceLits(true)
+			index, err := p.parseExpr()
+			restore()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(lexer.TokenRBracket, "expected ']' after index"); err != nil {
+				return nil, err
+			}
+			x = &ast.Index{Pos: pos, X: x, Index: index}
+		case lexer.TokenLParen:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			args, err := p.parseExprList(lexer.TokenRParen, "expected ')' after arguments")
+			if err != nil {
+				return nil, err
+			}
+			x = &ast.Call{Pos: ast.PosOf(x), Func: x, Args: args}
+		case lexer.TokenDot:
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.cur.Type != lexer.TokenIdent {
+				return nil, p.errorf("expected field name after '.'")
+			}
+			sel := &ast.Selector{Pos: pos, X: x, Name: p.cur.Value}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			x = sel
+			// module.Type{...} is a struct literal of a type from an imported module
+			if mod, ok := sel.X.(*ast.Variable); ok && p.cur.Type == lexer.TokenLBrace && !p.noBraceLit {
+				lit, err := p.parseStructLit(mod.Pos, mod.Name, sel.Name)
+				if err != nil {
+					return nil, err
+				}
+				x = lit
+			}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// parseMapLit parses a map literal: {key: value, ...}, allowing newlines between entries.
+func (p *Parser) parseMapLit() (*ast.MapLit, error) {
+	defer p.setBraceLits(true)()
+	lit := &ast.MapLit{Pos: p.pos()}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == lexer.TokenRBrace {
+			break
+		}
+		key, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(lexer.TokenColon, "expected ':' after map key"); err != nil {
+			return nil, err
+		}
+		val, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		lit.Entries = append(lit.Entries, ast.MapEntry{Key: key, Value: val})
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type != lexer.TokenComma {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(lexer.TokenRBrace, "expected '}' after map entries"); err != nil {
+		return nil, err
+	}
+	return lit, nil
+}
+
+// parseExprList parses comma-separated expressions up to and including the closing token.
+// Newlines are allowed between the elements.
+func (p *Parser) parseExprList(closing lexer.TokenType, msg string) ([]ast.Expr, error) {
+	defer p.setBraceLits(true)()
+	var exprs []ast.Expr
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type == closing {
+			break
+		}
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.cur.Type != lexer.TokenComma {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(closing, msg); err != nil {
+		return nil, err
+	}
+	return exprs, nil
+}
+
+func isOneOf(tt lexer.TokenType, set []lexer.TokenType) bool {
+	for _, t := range set {
+		if tt == t {
+			return true
 		}
 	}
+	return false
 }
@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lexer"
+)
+
+func parse(src string) (*ast.Program, error) {
+	return NewParser(lexer.NewLexer(src)).Parse()
+}
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		src  string
+		want ast.Stmt // the type of the only statement
+	}{
+		{"x = 1 + 2 * 3", &ast.Assignment{}},
+		{"let x = 1", &ast.Assignment{}},
+		{"names: [string] = []", &ast.Assignment{}},
+		{"xs[0] = 1", &ast.IndexAssign{}},
+		{"p.x = 1", &ast.FieldAssign{}},
+		{"x += 1", &ast.CompoundAssign{}},
+		{"xs[0]++", &ast.CompoundAssign{}},
+		{"print(x)", &ast.PrintStmt{}},
+		{"f(x)", &ast.ExprStmt{}},
+		{"if x > 1 {\n} else if x < 0 {\n} else {\n}", &ast.IfStmt{}},
+		{"while true {\n}", &ast.WhileStmt{}},
+		{"for x in xs {\n}", &ast.ForStmt{}},
+		{"try {\n    f()?\n} catch e {\n}", &ast.TryStmt{}},
+		{"fn f(a: float, b: string): !float {\n    return a\n}", &ast.FuncDecl{}},
+		{"extern fn sqrt(x: float): float", &ast.FuncDecl{}},
+		{"type Point {\n    x: float\n    y: float\n}", &ast.TypeDecl{}},
+		{"enum Shape {\n    Circle(r)\n    Empty\n}", &ast.EnumDecl{}},
+		{"pub const N: float = 2", &ast.ConstDecl{}},
+		{`import g "geometry/shapes"`, &ast.ImportDecl{}},
+		{"test \"adds\" {\n    assert_eq(1 + 1, 2)\n}", &ast.TestDecl{}},
+		{"bench \"adds\" {\n    x = 1 + 1\n}", &ast.BenchDecl{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			prog, err := parse(tt.src + "\n")
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if len(prog.Stmts) != 1 {
+				t.Fatalf("Parse() = %d statements, want 1", len(prog.Stmts))
+			}
+			if got, want := reflect.TypeOf(prog.Stmts[0]), reflect.TypeOf(tt.want); got != want {
+				t.Errorf("Parse() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	prog, err := parse("x = 1 + 2 * 3 == 7 && !done\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	and, ok := prog.Stmts[0].(*ast.Assignment).Expr.(*ast.Binary)
+	if !ok || and.Op != lexer.TokenAnd {
+		t.Fatalf("Parse() = %#v, want && at the root", prog.Stmts[0].(*ast.Assignment).Expr)
+	}
+	eq := and.Left.(*ast.Binary)
+	sum := eq.Left.(*ast.Binary)
+	if eq.Op != lexer.TokenEqEq || sum.Op != lexer.TokenPlus || sum.Right.(*ast.Binary).Op != lexer.TokenStar {
+		t.Errorf("Parse() grouped 1 + 2 * 3 == 7 as %#v", eq)
+	}
+	if _, ok := and.Right.(*ast.Unary); !ok {
+		t.Errorf("Parse() = %#v, want a unary !done", and.Right)
+	}
+}
+
+func TestParsePositions(t *testing.T) {
+	prog, err := parse("fn f() {\n    if true {\n        x = 1\n    }\n}\n")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	stmt := prog.Stmts[0].(*ast.FuncDecl).Body.Stmts[0].(*ast.IfStmt).Then.Stmts[0]
+	if got, want := ast.PosOf(stmt), (ast.Pos{Line: 3, Column: 9}); got != want {
+		t.Errorf("position of x = 1 is %v, want %v", got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		line int
+		col  int
+	}{
+		{"x = (1\n", "expected ')' after expression", 1, 7},
+		{"fn f() {\n    fn g() {\n    }\n}\n", "function declarations are only allowed at the top level", 2, 8},
+		{"if true {\n    test \"t\" {\n    }\n}\n", "test declarations are only allowed at the top level", 2, 5},
+		{"test adds {\n}\n", "expected test name string after test", 1, 6},
+		{"pub x = 1\n", "expected fn, export, extern, type, enum or const after pub", 1, 5},
+		{"extern fn f() {\n}\n", "extern function f cannot have a body", 1, 15},
+		{"fn f() {\n", "expected '}' before end of file", 2, 1},
+		{"x = 1 +\n", "unexpected end of line in expression", 1, 8},
+		{"x = )\n", "unexpected ')' in expression", 1, 5},
+		{"fn f(1) {\n}\n", "expected parameter name, got number 1", 1, 6},
+		{"type P {\n    \"x\": float\n}\n", `expected field name, got string "x"`, 2, 5},
+		{"x: = 1\n", "expected a type, got '='", 1, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			_, err := parse(tt.src)
+			var d *diag.Diagnostic
+			if !errors.As(err, &d) {
+				t.Fatalf("Parse() error = %v, want a diagnostic", err)
+			}
+			if !strings.Contains(d.Message, tt.want) {
+				t.Errorf("Parse() error = %q, want %q", d.Message, tt.want)
+			}
+			if d.Code != diag.CodeSyntax || d.Span.Start.Line != tt.line || d.Span.Start.Column != tt.col {
+				t.Errorf("Parse() error %s at %d:%d, want %s at %d:%d", d.Code, d.Span.Start.Line, d.Span.Start.Column, diag.CodeSyntax, tt.line, tt.col)
+			}
+		})
+	}
+}
+
+func TestParseReportsLexErrors(t *testing.T) {
+	// The first token is read by NewParser, the others as parsing goes
+	for _, src := range []string{"@\n", "x = @\n"} {
+		_, err := parse(src)
+		var d *diag.Diagnostic
+		if !errors.As(err, &d) || !strings.Contains(d.Message, "unknown character '@'") || !d.Located() {
+			t.Errorf("Parse(%q) error = %v, want an unknown character at its position", src, err)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package preprocessor
 
 import (
 	"strings"
+	"unicode"
 )
 
 // Rule defines a function that determines if a line should be stripped.
@@ -18,10 +19,12 @@ func DefaultRules() []Rule {
 }
 
 // Preprocess applies the given rules to the input string, stripping lines that match any rule.
+// Stripped lines are replaced by empty lines and the other lines are kept as written, so line and
+// column numbers in the output match the input; the lexer skips their trailing comments.
 func Preprocess(input string, rules []Rule) (string, error) {
 	var sb strings.Builder
-	lines := strings.Split(input, "\n")
-	for i, line := range lines {
+	lines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
 		strip := false
 		for _, rule := range rules {
@@ -31,25 +34,62 @@ func Preprocess(input string, rules []Rule) (string, error) {
 			}
 		}
 		if strip {
+			sb.WriteString("\n")
 			continue
 		}
-		// Remove inline comments (everything after //)
-		if idx := strings.Index(trimmed, "//"); idx != -1 {
-			trimmed = strings.TrimSpace(trimmed[:idx])
-			if trimmed == "" {
-				continue
+		sb.WriteString(strings.TrimRightFunc(line, unicode.IsSpace))
+		sb.WriteString("\n")
+	}
+	out := sb.String()
+	// An input made only of stripped lines preprocesses to nothing
+	if strings.TrimSpace(out) == "" {
+		return "", nil
+	}
+	return out, nil
+}
+
+// IgnoreDirective starts a comment that suppresses warnings: // pede:ignore <id>...
+// Each id is the name or code of a warning, such as unused-variable or W0101.
+const IgnoreDirective = "pede:ignore"
+
+// Ignores returns the warnings suppressed by the // pede:ignore comments of input, which
+// Preprocess strips, keyed by the line they apply to. A comment on a line of its own applies to
+// the next line with code, a trailing comment to its own line. A comment without ids suppresses
+// every warning, which is recorded as an empty list.
+func Ignores(input string) map[int][]string {
+	ignores := make(map[int][]string)
+	var pending [][]string // directives waiting for the next line with code
+	lines := strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+	for i, line := range lines {
+		code, comment, found := strings.Cut(strings.TrimSpace(line), "//")
+		code = strings.TrimSpace(code)
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(comment), IgnoreDirective); found && ok && (rest == "" || rest[0] == ' ' || rest[0] == '\t') {
+			ids := strings.Fields(rest)
+			if ids == nil {
+				ids = []string{}
+			}
+			if code == "" {
+				pending = append(pending, ids)
+			} else {
+				addIgnore(ignores, i+1, ids)
 			}
 		}
-		sb.WriteString(trimmed)
-		// Only add a newline if not the last line
-		if i < len(lines)-1 || trimmed != "" {
-			sb.WriteString("\n")
+		if code != "" {
+			for _, ids := range pending {
+				addIgnore(ignores, i+1, ids)
+			}
+			pending = nil
 		}
 	}
-	// Ensure output ends with a newline if not empty
-	out := sb.String()
-	if len(out) > 0 && out[len(out)-1] != '\n' {
-		out += "\n"
+	return ignores
+}
+
+// addIgnore records ids as suppressed on line; an empty list suppresses every warning there.
+func addIgnore(ignores map[int][]string, line int, ids []string) {
+	prev, ok := ignores[line]
+	if ok && len(prev) == 0 || len(ids) == 0 {
+		ignores[line] = []string{}
+		return
 	}
-	return out, nil
+	ignores[line] = append(prev, ids...)
 }
@@ -0,0 +1,38 @@
+package preprocessor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPreprocess(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"comments and blank lines", "// header\nx = 1\n\n   \ny = 2\n", "\nx = 1\n\n\ny = 2\n"},
+		{"indentation", "if x {\n    y = 1\n\tz = 2\n}\n", "if x {\n    y = 1\n\tz = 2\n}\n"},
+		{"trailing comments", "x = 1 // one\n    y = \"a//b\"   \n", "x = 1 // one\n    y = \"a//b\"\n"},
+		{"only comments", "// a\n\n// b\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Preprocess(tt.input, DefaultRules())
+			if err != nil {
+				t.Fatalf("Preprocess() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Preprocess() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnores(t *testing.T) {
+	input := "// pede:ignore unused-variable\nx = 1\ny = 2 // pede:ignore W0104 W0105\n// pede:ignore\n\nz = 3\n"
+	want := map[int][]string{2: {"unused-variable"}, 3: {"W0104", "W0105"}, 6: {}}
+	if got := Ignores(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ignores() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,490 @@
+// pede runtime: support code linked into every pede executable.
+// clock_gettime, used to time benchmarks, is POSIX rather than standard C.
+#define _POSIX_C_SOURCE 199309L
+#include <stdarg.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <time.h>
+
+// PEDE_PANIC_EXIT is the exit code of a program stopped by a runtime panic, rt.PanicExit in Go.
+#define PEDE_PANIC_EXIT 2
+
+// pede_frame is a frame of the shadow call stack kept by code built with stack traces: the
+// function, its source file and the line of the call it is making.
+typedef struct pede_frame {
+    struct pede_frame *prev;
+    const char *func;
+    const char *file;
+    int64_t line;
+} pede_frame;
+
+// pede_trace_top is the innermost frame of the shadow call stack, NULL without stack traces.
+pede_frame *pede_trace_top;
+
+// pede_print_trace prints the shadow call stack, innermost first. The innermost function is the
+// one that panicked, at line:col.
+static void pede_print_trace(int64_t line, int64_t col) {
+    if (pede_trace_top == NULL) {
+        return;
+    }
+    fputs("\nstack trace:\n", stderr);
+    for (pede_frame *f = pede_trace_top; f != NULL; f = f->prev) {
+        if (f == pede_trace_top) {
+            fprintf(stderr, "    %s at %s:%lld:%lld\n", f->func, f->file, (long long)line, (long long)col);
+        } else {
+            fprintf(stderr, "    %s at %s:%lld\n", f->func, f->file, (long long)f->line);
+        }
+    }
+}
+
+// PEDE_ASSERT_EXIT is the exit code of a test stopped by a failed assertion, rt.AssertExit in Go.
+#define PEDE_ASSERT_EXIT 3
+
+// pede_fail reports a fatal error at a .pede source location and exits with code: what went wrong,
+// followed by the details formatted by fmt unless it is NULL, the location and the stack trace.
+static void pede_fail(int code, const char *what, const char *file, int64_t line, int64_t col, const char *fmt, va_list args) {
+    fflush(stdout);
+    fputs(what, stderr);
+    if (fmt != NULL) {
+        fputs(": ", stderr);
+        vfprintf(stderr, fmt, args);
+    }
+    fprintf(stderr, " at %s:%lld:%lld\n", file, (long long)line, (long long)col);
+    pede_print_trace(line, col);
+    exit(code);
+}
+
+// pede_panicf reports a fatal runtime error at a .pede source location and exits.
+static void pede_panicf(const char *file, int64_t line, int64_t col, const char *fmt, ...) {
+    va_list args;
+    va_start(args, fmt);
+    pede_fail(PEDE_PANIC_EXIT, "panic", file, line, col, fmt, args);
+    va_end(args);
+}
+
+// pede_assertf reports a failed assertion at a .pede source location and exits. fmt may be NULL
+// when there is nothing to add.
+static void pede_assertf(const char *file, int64_t line, int64_t col, const char *fmt, ...) {
+    va_list args;
+    va_start(args, fmt);
+    pede_fail(PEDE_ASSERT_EXIT, "assertion failed", file, line, col, fmt, args);
+    va_end(args);
+}
+
+// pede_panic is called by generated code for failed runtime checks, such as division by zero.
+void pede_panic(const char *msg, const char *file, int64_t line, int64_t col) {
+    pede_panicf(file, line, col, "%s", msg);
+}
+
+// pede_float_to_int converts v to a C integer of the given size in bits, panicking if it is out
+// of range. Fractions are truncated, as in C.
+int64_t pede_float_to_int(double v, int32_t bits, const char *file, int64_t line, int64_t col) {
+    double limit = bits == 32 ? 2147483648.0 : 9223372036854775808.0;
+    if (!(v > -limit - 1 && v < limit)) {
+        pede_panicf(file, line, col, "%g out of range for a %d-bit C integer", v, bits);
+    }
+    return (int64_t)v;
+}
+
+// pede_exit ends the program with the given exit code, flushing the output.
+void pede_exit(double code, const char *file, int64_t line, int64_t col) {
+    if (!(code >= 0 && code <= 255) || code != (double)(int)code) {
+        pede_panicf(file, line, col, "exit code %g is not an integer between 0 and 255", code);
+    }
+    exit((int)code);
+}
+
+static void *pede_alloc(size_t size) {
+    void *p = calloc(1, size > 0 ? size : 1);
+    if (p == NULL) {
+        fprintf(stderr, "pede: out of memory\n");
+        abort();
+    }
+    return p;
+}
+
+// pede_new allocates size zeroed bytes for a struct value.
+void *pede_new(int64_t size) {
+    return pede_alloc((size_t)size);
+}
+
+// ---- lists -----------------------------------------------------------------
+
+// pede_list is a growable array of elements of elem_size bytes each.
+typedef struct pede_list {
+    int64_t len;
+    int64_t cap;
+    int64_t elem_size;
+    char *data;
+} pede_list;
+
+pede_list *pede_list_new(int64_t elem_size, int64_t cap) {
+    pede_list *l = pede_alloc(sizeof(pede_list));
+    l->elem_size = elem_size;
+    l->cap = cap;
+    l->data = pede_alloc((size_t)(cap * elem_size));
+    return l;
+}
+
+double pede_list_len(pede_list *l) {
+    return (double)l->len;
+}
+
+// pede_list_at returns a pointer to the element at index, panicking if it is out of range.
+void *pede_list_at(pede_list *l, double index, const char *file, int64_t line, int64_t col) {
+    // Converting a NaN or an out of range double is undefined, so check the range first
+    if (!(index >= 0 && index < (double)l->len) || index != (double)(int64_t)index) {
+        pede_panicf(file, line, col, "index out of range [%g] with length %lld", index, (long long)l->len);
+    }
+    return l->data + (int64_t)index * l->elem_size;
+}
+
+// pede_list_append copies the element pointed to by elem to the end of the list and returns the list.
+pede_list *pede_list_append(pede_list *l, const void *elem) {
+    if (l->len == l->cap) {
+        int64_t cap = l->cap < 4 ? 4 : l->cap * 2;
+        char *data = pede_alloc((size_t)(cap * l->elem_size));
+        memcpy(data, l->data, (size_t)(l->len * l->elem_size));
+        free(l->data);
+        l->data = data;
+        l->cap = cap;
+    }
+    memcpy(l->data + l->len * l->elem_size, elem, (size_t)l->elem_size);
+    l->len++;
+    return l;
+}
+
+// ---- maps ------------------------------------------------------------------
+
+enum { PEDE_KEY_NUMBER = 0, PEDE_KEY_STRING = 1 };
+
+// pede_map is a hash map that remembers insertion order. Entries live in a dense
+// array that iteration walks; index maps hash slots to entry positions.
+typedef struct pede_map {
+    int64_t key_kind;
+    int64_t value_size;
+    int64_t len;        // live entries
+    int64_t used;       // entries in use, including deleted ones
+    int64_t cap;        // capacity of the entry arrays
+    int64_t index_cap;  // number of hash slots, a power of two
+    int64_t *index;     // entry position + 1 per slot; 0 is empty, -1 is deleted
+    char *keys;         // 8 bytes per entry: a double or a char*
+    char *values;       // value_size bytes per entry
+    char *live;         // 1 if the entry has not been deleted
+} pede_map;
+
+static uint64_t pede_map_hash(pede_map *m, const void *key) {
+    uint64_t h = 14695981039346656037ULL;
+    if (m->key_kind == PEDE_KEY_STRING) {
+        for (const unsigned char *s = *(const unsigned char **)key; *s; s++) {
+            h = (h ^ *s) * 1099511628211ULL;
+        }
+        return h;
+    }
+    double d = *(const double *)key;
+    if (d == 0) {
+        d = 0; // -0 and 0 are the same key
+    }
+    uint64_t bits;
+    memcpy(&bits, &d, sizeof bits);
+    return (bits ^ (bits >> 29)) * 1099511628211ULL;
+}
+
+static int pede_map_equal(pede_map *m, const void *a, const void *b) {
+    if (m->key_kind == PEDE_KEY_STRING) {
+        return strcmp(*(const char **)a, *(const char **)b) == 0;
+    }
+    return *(const double *)a == *(const double *)b;
+}
+
+// pede_map_find returns the hash slot holding key, or the slot where it would be inserted.
+static int64_t pede_map_find(pede_map *m, const void *key, int *found) {
+    uint64_t mask = (uint64_t)m->index_cap - 1;
+    uint64_t slot = pede_map_hash(m, key) & mask;
+    int64_t insert_at = -1;
+    for (;;) {
+        int64_t e = m->index[slot];
+        if (e == 0) {
+            *found = 0;
+            return insert_at >= 0 ? insert_at : (int64_t)slot;
+        }
+        if (e < 0) {
+            if (insert_at < 0) {
+                insert_at = (int64_t)slot;
+            }
+        } else if (pede_map_equal(m, m->keys + (e - 1) * 8, key)) {
+            *found = 1;
+            return (int64_t)slot;
+        }
+        slot = (slot + 1) & mask;
+    }
+}
+
+// pede_map_grow compacts deleted entries away and doubles the capacity.
+static void pede_map_grow(pede_map *m) {
+    int64_t cap = m->cap < 8 ? 8 : m->cap * 2;
+    char *keys = pede_alloc((size_t)(cap * 8));
+    char *values = pede_alloc((size_t)(cap * m->value_size));
+    char *live = pede_alloc((size_t)cap);
+    int64_t n = 0;
+    for (int64_t i = 0; i < m->used; i++) {
+        if (!m->live[i]) {
+            continue;
+        }
+        memcpy(keys + n * 8, m->keys + i * 8, 8);
+        memcpy(values + n * m->value_size, m->values + i * m->value_size, (size_t)m->value_size);
+        live[n] = 1;
+        n++;
+    }
+    free(m->keys);
+    free(m->values);
+    free(m->live);
+    free(m->index);
+    m->keys = keys;
+    m->values = values;
+    m->live = live;
+    m->cap = cap;
+    m->used = n;
+    m->index_cap = cap * 2;
+    m->index = pede_alloc((size_t)(m->index_cap * 8));
+    for (int64_t i = 0; i < n; i++) {
+        int found;
+        int64_t slot = pede_map_find(m, keys + i * 8, &found);
+        m->index[slot] = i + 1;
+    }
+}
+
+pede_map *pede_map_new(int64_t key_kind, int64_t value_size) {
+    pede_map *m = pede_alloc(sizeof(pede_map));
+    m->key_kind = key_kind;
+    m->value_size = value_size;
+    pede_map_grow(m);
+    return m;
+}
+
+double pede_map_len(pede_map *m) {
+    return (double)m->len;
+}
+
+int32_t pede_map_has(pede_map *m, const void *key) {
+    int found;
+    pede_map_find(m, key, &found);
+    return found;
+}
+
+// pede_map_get returns a pointer to the value stored under key, panicking if there is none.
+void *pede_map_get(pede_map *m, const void *key, const char *file, int64_t line, int64_t col) {
+    int found;
+    int64_t slot = pede_map_find(m, key, &found);
+    if (!found) {
+        if (m->key_kind == PEDE_KEY_STRING) {
+            pede_panicf(file, line, col, "key \"%s\" not found in map", *(const char **)key);
+        }
+        pede_panicf(file, line, col, "key %g not found in map", *(const double *)key);
+    }
+    return m->values + (m->index[slot] - 1) * m->value_size;
+}
+
+// pede_map_slot returns a pointer to the value stored under key, inserting a zero value if there is none.
+void *pede_map_slot(pede_map *m, const void *key) {
+    int found;
+    int64_t slot = pede_map_find(m, key, &found);
+    if (found) {
+        return m->values + (m->index[slot] - 1) * m->value_size;
+    }
+    if (m->used == m->cap) {
+        pede_map_grow(m);
+        slot = pede_map_find(m, key, &found);
+    }
+    int64_t e = m->used++;
+    memcpy(m->keys + e * 8, key, 8);
+    memset(m->values + e * m->value_size, 0, (size_t)m->value_size);
+    m->live[e] = 1;
+    m->index[slot] = e + 1;
+    m->len++;
+    return m->values + e * m->value_size;
+}
+
+// pede_map_delete removes key from the map and returns the map; missing keys are ignored.
+pede_map *pede_map_delete(pede_map *m, const void *key) {
+    int found;
+    int64_t slot = pede_map_find(m, key, &found);
+    if (found) {
+        m->live[m->index[slot] - 1] = 0;
+        m->index[slot] = -1;
+        m->len--;
+    }
+    return m;
+}
+
+// pede_map_keys returns a new list holding the keys of the map in insertion order.
+pede_list *pede_map_keys(pede_map *m) {
+    pede_list *l = pede_list_new(8, m->len);
+    for (int64_t i = 0; i < m->used; i++) {
+        if (m->live[i]) {
+            pede_list_append(l, m->keys + i * 8);
+        }
+    }
+    return l;
+}
+
+// ---- tests and benchmarks --------------------------------------------------
+
+// pede_block is a test or bench block of the program, compiled into a function without arguments.
+typedef struct pede_block {
+    const char *name;
+    void (*run)(void);
+} pede_block;
+
+// pede_tests and pede_benches hold the blocks registered by the main function of a test or
+// benchmark binary, in order.
+static pede_list *pede_tests;
+static pede_list *pede_benches;
+
+// pede_block_add appends the block name, which calls run, to *blocks.
+static void pede_block_add(pede_list **blocks, const char *name, void (*run)(void)) {
+    if (*blocks == NULL) {
+        *blocks = pede_list_new(sizeof(pede_block), 0);
+    }
+    pede_block b = {name, run};
+    pede_list_append(*blocks, &b);
+}
+
+// pede_block_find returns the block called name among blocks. Without a name it lists the blocks,
+// one per line, and returns NULL; so it does when there is no such block, with an error.
+static pede_block *pede_block_find(pede_list *blocks, const char *name) {
+    int64_t n = blocks == NULL ? 0 : blocks->len;
+    pede_block *all = n == 0 ? NULL : (pede_block *)blocks->data;
+    for (int64_t i = 0; i < n; i++) {
+        if (name == NULL) {
+            puts(all[i].name);
+        } else if (strcmp(all[i].name, name) == 0) {
+            return &all[i];
+        }
+    }
+    if (name != NULL) {
+        fprintf(stderr, "pede: no block named \"%s\"\n", name);
+    }
+    return NULL;
+}
+
+// pede_test_add registers the test name, which calls run.
+void pede_test_add(const char *name, void (*run)(void)) {
+    pede_block_add(&pede_tests, name, run);
+}
+
+// pede_test_main runs the test named by the first argument, once all tests are registered, and
+// returns the exit code of the test binary. Without arguments it lists the tests, one per line.
+// A test fails by exiting: a failed assertion exits with PEDE_ASSERT_EXIT, a panic with
+// PEDE_PANIC_EXIT.
+int32_t pede_test_main(int32_t argc, char **argv) {
+    pede_block *test = pede_block_find(pede_tests, argc < 2 ? NULL : argv[1]);
+    if (test == NULL) {
+        return argc < 2 ? 0 : 1;
+    }
+    test->run();
+    fflush(stdout);
+    return 0;
+}
+
+// pede_now returns the time of a monotonic clock in nanoseconds.
+static int64_t pede_now(void) {
+    struct timespec ts;
+    clock_gettime(CLOCK_MONOTONIC, &ts);
+    return (int64_t)ts.tv_sec * 1000000000 + ts.tv_nsec;
+}
+
+// pede_bench_time runs the benchmark n times and returns how long that took in nanoseconds.
+static int64_t pede_bench_time(pede_block *bench, int64_t n) {
+    int64_t start = pede_now();
+    for (int64_t i = 0; i < n; i++) {
+        bench->run();
+    }
+    return pede_now() - start;
+}
+
+// pede_bench_add registers the benchmark name, which calls run.
+void pede_bench_add(const char *name, void (*run)(void)) {
+    pede_block_add(&pede_benches, name, run);
+}
+
+// pede_bench_main runs the benchmark named by the first argument, once all benchmarks are
+// registered, and returns the exit code of the benchmark binary. Without arguments it lists the
+// benchmarks, one per line.
+//
+// The arguments after the name are the time a sample should take in nanoseconds, the number of
+// samples and the file to write them to. The number of iterations of a sample is calibrated first,
+// by growing it until the runs take long enough; then every sample is written as a line with the
+// number of iterations and the nanoseconds they took.
+int32_t pede_bench_main(int32_t argc, char **argv) {
+    pede_block *bench = pede_block_find(pede_benches, argc < 2 ? NULL : argv[1]);
+    if (bench == NULL) {
+        return argc < 2 ? 0 : 1;
+    }
+    if (argc != 5) {
+        fprintf(stderr, "usage: %s <name> <sample ns> <samples> <output file>\n", argv[0]);
+        return 1;
+    }
+    int64_t target = strtoll(argv[2], NULL, 10);
+    int64_t samples = strtoll(argv[3], NULL, 10);
+    int64_t n = 1;
+    int64_t elapsed = pede_bench_time(bench, n);
+    while (elapsed < target && n < 1000000000) {
+        // Aim past the target, as the estimate is rough for short runs, and grow at most a
+        // hundredfold at a time
+        int64_t next = elapsed > 0 ? (int64_t)(1.2 * (double)target * (double)n / (double)elapsed) : n * 100;
+        if (next > n * 100) {
+            next = n * 100;
+        }
+        if (next <= n) {
+            next = n + 1;
+        }
+        n = next;
+        elapsed = pede_bench_time(bench, n);
+    }
+    FILE *out = fopen(argv[4], "w");
+    if (out == NULL) {
+        perror(argv[4]);
+        return 1;
+    }
+    for (int64_t i = 0; i < samples; i++) {
+        fprintf(out, "%lld %lld\n", (long long)n, (long long)pede_bench_time(bench, n));
+    }
+    fflush(stdout);
+    return fclose(out) == 0 ? 0 : 1;
+}
+
+// pede_assert fails the running test at a .pede source location if ok is 0, with msg if it is
+// not NULL.
+void pede_assert(int32_t ok, const char *msg, const char *file, int64_t line, int64_t col) {
+    if (ok) {
+        return;
+    }
+    if (msg == NULL) {
+        pede_assertf(file, line, col, NULL);
+    }
+    pede_assertf(file, line, col, "%s", msg);
+}
+
+// pede_assert_eq_float fails the running test at a .pede source location if got is not want.
+void pede_assert_eq_float(double got, double want, const char *file, int64_t line, int64_t col) {
+    if (got != want) {
+        pede_assertf(file, line, col, "got %g, want %g", got, want);
+    }
+}
+
+// pede_assert_eq_string fails the running test at a .pede source location if got is not want.
+void pede_assert_eq_string(const char *got, const char *want, const char *file, int64_t line, int64_t col) {
+    if (strcmp(got, want) != 0) {
+        pede_assertf(file, line, col, "got \"%s\", want \"%s\"", got, want);
+    }
+}
+
+// pede_assert_eq_bool fails the running test at a .pede source location if got is not want.
+void pede_assert_eq_bool(int32_t got, int32_t want, const char *file, int64_t line, int64_t col) {
+    if (got != want) {
+        pede_assertf(file, line, col, "got %s, want %s", got ? "true" : "false", want ? "true" : "false");
+    }
+}
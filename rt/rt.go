@@ -0,0 +1,30 @@
+// Package rt holds the C runtime that is linked into every pede executable.
+package rt
+
+import (
+	_ "embed"
+	"os"
+	"path/filepath"
+)
+
+//go:embed c/pede_rt.c
+var Source string
+
+// PanicExit and AssertExit are the exit codes of a program stopped by a runtime panic and of a
+// test stopped by a failed assertion, PEDE_PANIC_EXIT and PEDE_ASSERT_EXIT in the runtime source.
+const (
+	PanicExit  = 2
+	AssertExit = 3
+)
+
+// FileName is the name the runtime source is written under.
+const FileName = "pede_rt.c"
+
+// WriteSource writes the runtime source into dir and returns its path.
+func WriteSource(dir string) (string, error) {
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, []byte(Source), 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,15 @@
+package rt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExitCodesMatchSource(t *testing.T) {
+	for name, code := range map[string]int{"PEDE_PANIC_EXIT": PanicExit, "PEDE_ASSERT_EXIT": AssertExit} {
+		if def := fmt.Sprintf("#define %s %d\n", name, code); !strings.Contains(Source, def) {
+			t.Errorf("the runtime source does not contain %q", def)
+		}
+	}
+}
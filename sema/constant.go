@@ -0,0 +1,94 @@
+package sema
+
+import (
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/lexer"
+)
+
+// fold records the value of e in Info.Values if e is a constant expression: a literal, a
+// constant, or an operator applied to constant operands. Operands are checked, and so folded,
+// before the expressions using them, so folding works bottom-up across whole operator trees.
+func (c *Checker) fold(e ast.Expr) {
+	if v, ok := c.constValue(e); ok {
+		c.info.Values[e] = v
+	}
+}
+
+func (c *Checker) constValue(e ast.Expr) (any, bool) {
+	switch n := e.(type) {
+	case *ast.Number:
+		return n.Value, true
+	case *ast.String:
+		return n.Value, true
+	case *ast.Bool:
+		return n.Value, true
+	case *ast.Variable:
+		if c.lookup(n.Name) != nil {
+			return nil, false
+		}
+		if k, ok := c.cur.consts[n.Name]; ok {
+			return k.Value, true
+		}
+	case *ast.Selector:
+		if k, _ := c.moduleConst(n); k != nil {
+			return k.Value, true
+		}
+	case *ast.Unary:
+		v, ok := c.info.Values[n.Operand]
+		if !ok {
+			return nil, false
+		}
+		switch n.Op {
+		case lexer.TokenMinus:
+			return -v.(float64), true
+		case lexer.TokenBang:
+			return !v.(bool), true
+		}
+	case *ast.Binary:
+		l, lok := c.info.Values[n.Left]
+		r, rok := c.info.Values[n.Right]
+		if !lok || !rok {
+			return nil, false
+		}
+		return evalBinary(n.Op, l, r)
+	}
+	return nil, false
+}
+
+// evalBinary applies a binary operator to constant operands the checker has already accepted.
+func evalBinary(op string, l, r any) (any, bool) {
+	switch op {
+	case lexer.TokenEqEq:
+		return l == r, true
+	case lexer.TokenNotEq:
+		return l != r, true
+	case lexer.TokenAnd:
+		return l.(bool) && r.(bool), true
+	case lexer.TokenOr:
+		return l.(bool) || r.(bool), true
+	}
+	x, xok := l.(float64)
+	y, yok := r.(float64)
+	if !xok || !yok {
+		return nil, false
+	}
+	switch op {
+	case lexer.TokenPlus:
+		return x + y, true
+	case lexer.TokenMinus:
+		return x - y, true
+	case lexer.TokenStar:
+		return x * y, true
+	case lexer.TokenSlash:
+		return x / y, true
+	case lexer.TokenLess:
+		return x < y, true
+	case lexer.TokenLessEq:
+		return x <= y, true
+	case lexer.TokenGreater:
+		return x > y, true
+	case lexer.TokenGreaterEq:
+		return x >= y, true
+	}
+	return nil, false
+}
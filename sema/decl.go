@@ -0,0 +1,425 @@
+package sema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// declareTypes registers every struct and enum declared in the program before any statement is
+// checked, so types may refer to each other and to themselves regardless of declaration order.
+func (c *Checker) declareTypes(prog *ast.Program) error {
+	path := c.cur.mod.Path
+	for _, stmt := range prog.Stmts {
+		var name string
+		var pub bool
+		var t Type
+		switch decl := stmt.(type) {
+		case *ast.TypeDecl:
+			name, pub, t = decl.Name, decl.Pub, &Struct{Module: path, Name: decl.Name}
+		case *ast.EnumDecl:
+			name, pub, t = decl.Name, decl.Pub, &Enum{Module: path, Name: decl.Name}
+		default:
+			continue
+		}
+		if _, exists := c.cur.types[name]; exists {
+			return c.errorCodef(stmt, diag.CodeRedeclared, "type %s is already declared", name)
+		}
+		if isBuiltinType(name) {
+			return c.errorCodef(stmt, diag.CodeRedeclared, "cannot redeclare built-in type %s", name)
+		}
+		c.cur.types[name] = t
+		c.cur.pub[name] = pub
+	}
+	for _, stmt := range prog.Stmts {
+		switch decl := stmt.(type) {
+		case *ast.TypeDecl:
+			st := c.cur.types[decl.Name].(*Struct)
+			fields, err := c.resolveFields(decl.Fields, "type "+decl.Name)
+			if err != nil {
+				return err
+			}
+			st.Fields = fields
+		case *ast.EnumDecl:
+			enum := c.cur.types[decl.Name].(*Enum)
+			for i, v := range decl.Variants {
+				if enum.Variant(v.Name) != nil {
+					return c.errorCodef(v, diag.CodeRedeclared, "duplicate variant %s in enum %s", v.Name, decl.Name)
+				}
+				fields, err := c.resolveFields(v.Fields, "variant "+decl.Name+"."+v.Name)
+				if err != nil {
+					return err
+				}
+				enum.Variants = append(enum.Variants, &Variant{Enum: enum, Name: v.Name, Tag: i, Fields: fields})
+			}
+		}
+	}
+	return nil
+}
+
+// resolveFields resolves the fields of a struct or enum variant; untyped fields are floats.
+func (c *Checker) resolveFields(decls []ast.Field, owner string) ([]*Field, error) {
+	var fields []*Field
+	for _, f := range decls {
+		for _, prev := range fields {
+			if prev.Name == f.Name {
+				return nil, c.errorCodef(f, diag.CodeRedeclared, "duplicate field %s in %s", f.Name, owner)
+			}
+		}
+		var t Type = Float
+		if f.Type != nil {
+			resolved, err := c.resolveType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			t = resolved
+		}
+		fields = append(fields, &Field{Name: f.Name, Type: t})
+	}
+	return fields, nil
+}
+
+func isBuiltinType(name string) bool {
+	return name == "float" || name == "string" || name == "bool" || name == "ptr"
+}
+
+// reservedExterns lists C functions the generated code declares itself, which extern declarations
+// would conflict with.
+var reservedExterns = map[string]bool{
+	"main":   true,
+	"printf": true,
+	"strcmp": true,
+}
+
+// declareFuncs registers the signatures of every function declared in the program, so functions
+// may call each other regardless of declaration order.
+func (c *Checker) declareFuncs(prog *ast.Program) error {
+	for _, stmt := range prog.Stmts {
+		decl, ok := stmt.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if builtins[decl.Name] {
+			return c.errorCodef(decl, diag.CodeRedeclared, "cannot redeclare built-in function %s", decl.Name)
+		}
+		if _, exists := c.cur.funcs[decl.Name]; exists {
+			return c.errorCodef(decl, diag.CodeRedeclared, "function %s is already declared", decl.Name)
+		}
+		f := &Func{Module: c.cur.mod.Path, Name: decl.Name, Pub: decl.Pub, Decl: decl}
+		if err := c.declareSymbol(f); err != nil {
+			return err
+		}
+		if decl.Extern {
+			if err := c.resolveExtern(f); err != nil {
+				return err
+			}
+		} else {
+			params, err := c.resolveFields(decl.Params, "function "+decl.Name)
+			if err != nil {
+				return err
+			}
+			f.Params = params
+			if decl.Result != nil {
+				if f.Result, err = c.resolveType(decl.Result); err != nil {
+					return err
+				}
+			}
+		}
+		c.cur.funcs[decl.Name] = f
+		c.info.Funcs = append(c.info.Funcs, f)
+	}
+	return nil
+}
+
+// declareSymbol reserves the C symbol of an extern or exported function. Several modules may
+// declare the same extern function, but an exported function must have a symbol of its own.
+func (c *Checker) declareSymbol(f *Func) error {
+	decl := f.Decl
+	if !decl.Extern && !decl.Export {
+		return nil
+	}
+	if reservedExterns[decl.Name] || strings.HasPrefix(decl.Name, "pede_") {
+		return c.errorf(decl, "cannot use %s as a C symbol: the name is reserved by the pede runtime", decl.Name)
+	}
+	if prev, ok := c.symbols[decl.Name]; ok && (decl.Export || prev.Decl.Export) {
+		return c.errorCodef(decl, diag.CodeRedeclared, "C symbol %s is already declared in module %s", decl.Name, prev.Module)
+	}
+	c.symbols[decl.Name] = f
+	return nil
+}
+
+// resolveExtern resolves the signature of an extern function, whose parameters and result must
+// have a C equivalent.
+func (c *Checker) resolveExtern(f *Func) error {
+	for _, p := range f.Decl.Params {
+		for _, prev := range f.Params {
+			if prev.Name == p.Name {
+				return c.errorCodef(p, diag.CodeRedeclared, "duplicate field %s in function %s", p.Name, f.Name)
+			}
+		}
+		t, err := c.resolveCType(p.Type)
+		if err != nil {
+			return err
+		}
+		f.Params = append(f.Params, &Field{Name: p.Name, Type: t})
+	}
+	if f.Decl.Result != nil {
+		t, err := c.resolveCType(f.Decl.Result)
+		if err != nil {
+			return err
+		}
+		f.Result = t
+	}
+	return nil
+}
+
+// resolveCType resolves a type in the signature of an extern function. Besides float, string,
+// bool and ptr, the C integer types int and long may be used; they are floats in pede and are
+// converted at the call.
+func (c *Checker) resolveCType(te ast.TypeExpr) (Type, error) {
+	if named, ok := te.(*ast.NamedType); ok && named.Module == "" && isCInt(named.Name) {
+		return Float, nil
+	}
+	t, err := c.resolveType(te)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.(*Basic); !ok {
+		return nil, c.errorf(te, "cannot pass values of type %s to C", t)
+	}
+	return t, nil
+}
+
+// isCInt reports whether name is one of the C integer types allowed in extern signatures.
+func isCInt(name string) bool {
+	return name == "int" || name == "long"
+}
+
+// declareConsts evaluates the constants declared in the program, in declaration order; a constant
+// may refer to the constants declared before it.
+func (c *Checker) declareConsts(prog *ast.Program) error {
+	c.resetScope()
+	c.fn = nil
+	for _, stmt := range prog.Stmts {
+		decl, ok := stmt.(*ast.ConstDecl)
+		if !ok {
+			continue
+		}
+		if _, exists := c.cur.consts[decl.Name]; exists {
+			return c.errorCodef(decl, diag.CodeRedeclared, "constant %s is already declared", decl.Name)
+		}
+		if _, exists := c.cur.funcs[decl.Name]; exists {
+			return c.errorCodef(decl, diag.CodeRedeclared, "%s is already declared as a function", decl.Name)
+		}
+		var declared Type
+		if decl.Type != nil {
+			t, err := c.resolveType(decl.Type)
+			if err != nil {
+				return err
+			}
+			declared = t
+		}
+		// Variables are out of scope here, so name them before checking reports them undefined
+		if v := c.nonConst(decl.Value); v != nil {
+			return c.errorCodef(v, diag.CodeNotConstant, "the value of constant %s is not known at compile time: %s is not a constant", decl.Name, v.Name)
+		}
+		t, err := c.checkExprHint(decl.Value, declared)
+		if err != nil {
+			return err
+		}
+		if declared != nil && !Identical(t, declared) {
+			return c.errorf(decl.Value, "cannot use %s as constant %s of type %s", t, decl.Name, declared)
+		}
+		val, ok := c.info.Values[decl.Value]
+		if !ok {
+			return c.errorCodef(decl.Value, diag.CodeNotConstant, "the value of constant %s is not known at compile time", decl.Name)
+		}
+		c.cur.consts[decl.Name] = &Const{Module: c.cur.mod.Path, Name: decl.Name, Type: t, Value: val, Pub: decl.Pub}
+	}
+	return nil
+}
+
+// nonConst returns the first name in e that is neither a constant nor a constant of an imported
+// module, or nil.
+func (c *Checker) nonConst(e ast.Expr) *ast.Variable {
+	var found *ast.Variable
+	ast.Inspect(e, func(n any) bool {
+		if found != nil {
+			return false
+		}
+		switch n := n.(type) {
+		case *ast.Selector:
+			if k, _ := c.moduleConst(n); k != nil {
+				return false
+			}
+		case *ast.Variable:
+			if _, ok := c.cur.consts[n.Name]; !ok {
+				found = n
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// checkFunc checks the body of f, in which only its parameters are in scope.
+func (c *Checker) checkFunc(f *Func) error {
+	if f.Decl.Extern {
+		return nil
+	}
+	c.fn = f
+	c.tries = 0
+	// The parameters and the top-level statements of the body share the outermost scope
+	c.resetScope()
+	for i, p := range f.Params {
+		v, err := c.declare(f.Decl.Params[i].Pos, p.Name, p.Type, false)
+		if err != nil {
+			return err
+		}
+		c.info.Vars[&f.Decl.Params[i]] = v
+	}
+	if err := c.checkStmts(f.Decl.Body.Stmts); err != nil {
+		return err
+	}
+	if f.Result != nil && !c.info.Terminates(f.Decl.Body) {
+		return c.errorCodef(f.Decl, diag.CodeMissingReturn, "missing return at the end of function %s", f.Name)
+	}
+	return nil
+}
+
+// checkTests checks the test and benchmark blocks of the program, whose bodies are checked like
+// those of functions without parameters or result. The names of the tests of a module must be
+// unique, as must those of its benchmarks.
+func (c *Checker) checkTests(prog *ast.Program) error {
+	declared := make(map[string]bool)
+	var failed error
+	for _, stmt := range prog.Stmts {
+		var kind, name string
+		var body *ast.Block
+		switch decl := stmt.(type) {
+		case *ast.TestDecl:
+			kind, name, body = "test", decl.Name, decl.Body
+		case *ast.BenchDecl:
+			kind, name, body = "bench", decl.Name, decl.Body
+		default:
+			continue
+		}
+		if strings.TrimSpace(name) == "" {
+			failed = c.report(c.errorf(stmt, "%s name must not be empty", kind))
+			continue
+		}
+		key := fmt.Sprintf("%s %q", kind, name)
+		if declared[key] {
+			failed = c.report(c.errorCodef(stmt, diag.CodeRedeclared, "%s is already declared", key))
+			continue
+		}
+		declared[key] = true
+		c.fn = &Func{Module: c.cur.mod.Path, Name: key}
+		c.tries = 0
+		c.resetScope()
+		if err := c.checkStmts(body.Stmts); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// resolveType converts a type written in the source into a Type.
+func (c *Checker) resolveType(te ast.TypeExpr) (Type, error) {
+	switch t := te.(type) {
+	case *ast.NamedType:
+		if t.Module != "" {
+			return c.importedType(t, t.Module, t.Name)
+		}
+		switch t.Name {
+		case "float":
+			return Float, nil
+		case "string":
+			return String, nil
+		case "bool":
+			return Bool, nil
+		case "ptr":
+			return Ptr, nil
+		}
+		if named, ok := c.cur.types[t.Name]; ok {
+			return named, nil
+		}
+		if isCInt(t.Name) {
+			return nil, c.errorf(t, "type %s is only allowed in extern function signatures", t.Name)
+		}
+		return nil, c.errorCodef(t, diag.CodeUndefined, "unknown type %q", t.Name)
+	case *ast.ListType:
+		elem, err := c.resolveType(t.Elem)
+		if err != nil {
+			return nil, err
+		}
+		return &List{Elem: elem}, nil
+	case *ast.MapType:
+		key, err := c.resolveType(t.Key)
+		if err != nil {
+			return nil, err
+		}
+		if !validKey(key) {
+			return nil, c.errorf(t.Key, "map keys must be float or string, got %s", key)
+		}
+		val, err := c.resolveType(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &Map{Key: key, Value: val}, nil
+	case *ast.ResultType:
+		val, err := c.resolveType(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := val.(*Result); ok {
+			return nil, c.errorf(t, "a result cannot hold another result")
+		}
+		return &Result{Value: val}, nil
+	case *ast.FuncType:
+		sig := &Signature{}
+		for _, p := range t.Params {
+			pt, err := c.resolveType(p)
+			if err != nil {
+				return nil, err
+			}
+			sig.Params = append(sig.Params, pt)
+		}
+		if t.Result != nil {
+			rt, err := c.resolveType(t.Result)
+			if err != nil {
+				return nil, err
+			}
+			sig.Result = rt
+		}
+		return sig, nil
+	}
+	return nil, c.errorf(te, "unsupported type expression %T", te)
+}
+
+// imported returns the scope of the module imported under name, or nil.
+func (c *Checker) imported(name string) *moduleScope {
+	dep, ok := c.cur.mod.Imports[name]
+	if !ok {
+		return nil
+	}
+	return c.modules[dep]
+}
+
+// importedType resolves module.Name, which must be a type declared pub in an imported module.
+func (c *Checker) importedType(n any, module, name string) (Type, error) {
+	scope := c.imported(module)
+	if scope == nil {
+		return nil, c.errorCodef(n, diag.CodeUndefined, "undefined module %q", module)
+	}
+	t, ok := scope.types[name]
+	if !ok {
+		return nil, c.errorCodef(n, diag.CodeUndefined, "module %s has no type %s", module, name)
+	}
+	if !scope.pub[name] {
+		return nil, c.errorCodef(n, diag.CodeNotPublic, "type %s.%s is not public; declare it with pub", module, name)
+	}
+	return t, nil
+}
@@ -0,0 +1,1029 @@
+package sema
+
+import (
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lexer"
+)
+
+func (c *Checker) checkExpr(e ast.Expr) (Type, error) {
+	return c.checkExprHint(e, nil)
+}
+
+// checkExprHint checks e and records its type. hint is the type the context expects, if known;
+// it is what gives empty list literals their element type.
+func (c *Checker) checkExprHint(e ast.Expr, hint Type) (Type, error) {
+	t, err := c.inferExpr(e, hint)
+	if err != nil {
+		return nil, err
+	}
+	c.info.Types[e] = t
+	c.fold(e)
+	return t, nil
+}
+
+func (c *Checker) inferExpr(e ast.Expr, hint Type) (Type, error) {
+	switch n := e.(type) {
+	case *ast.Number:
+		return Float, nil
+	case *ast.String:
+		return String, nil
+	case *ast.Bool:
+		return Bool, nil
+	case *ast.Variable:
+		v := c.use(n.Name)
+		if v == nil {
+			if _, isModule := c.cur.mod.Imports[n.Name]; isModule {
+				return nil, c.errorf(n, "module %s is not a value", n.Name)
+			}
+			if k, isConst := c.cur.consts[n.Name]; isConst {
+				return k.Type, nil
+			}
+			if f, isFunc := c.cur.funcs[n.Name]; isFunc {
+				return c.funcValue(n, f)
+			}
+			if c.undeclared[n.Name] {
+				return nil, errReported
+			}
+			return nil, c.errorCodef(n, diag.CodeUndefined, "undefined variable %q", n.Name)
+		}
+		c.info.Vars[n] = v
+		return v.Type, nil
+	case *ast.Unary:
+		t, err := c.checkExpr(n.Operand)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Op {
+		case lexer.TokenMinus:
+			if !Identical(t, Float) {
+				return nil, c.errorf(n, "operator - requires a float operand, got %s", t)
+			}
+			return Float, nil
+		case lexer.TokenBang:
+			if !Identical(t, Bool) {
+				return nil, c.errorf(n, "operator ! requires a bool operand, got %s", t)
+			}
+			return Bool, nil
+		}
+		return nil, c.errorf(n, "unsupported unary operator %s", n.Op)
+	case *ast.Binary:
+		return c.checkBinary(n)
+	case *ast.ListLit:
+		return c.checkListLit(n, hint)
+	case *ast.MapLit:
+		return c.checkMapLit(n, hint)
+	case *ast.Index:
+		return c.checkIndex(n)
+	case *ast.StructLit:
+		return c.checkStructLit(n)
+	case *ast.Match:
+		return c.checkMatchExpr(n)
+	case *ast.Selector:
+		v, err := c.enumVariant(n)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			if len(v.Fields) != 0 {
+				return nil, c.errorCodef(n, diag.CodeArgCount, "%s.%s expects %d arguments", v.Enum, v.Name, len(v.Fields))
+			}
+			c.info.Variants[n] = v
+			return v.Enum, nil
+		}
+		k, err := c.moduleConst(n)
+		if err != nil {
+			return nil, err
+		}
+		if k != nil {
+			return k.Type, nil
+		}
+		f, err := c.moduleFunc(n)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			return c.funcValue(n, f)
+		}
+		t, err := c.checkExpr(n.X)
+		if err != nil {
+			return nil, err
+		}
+		st, ok := t.(*Struct)
+		if !ok {
+			return nil, c.errorf(n, "cannot access field %s of a value of type %s", n.Name, t)
+		}
+		field, _ := st.Field(n.Name)
+		if field == nil {
+			return nil, c.errorCodef(n, diag.CodeUndefined, "type %s has no field %s", st, n.Name)
+		}
+		return field.Type, nil
+	case *ast.Propagate:
+		return c.checkPropagate(n)
+	case *ast.Call:
+		if c.isErrorCall(n) {
+			return c.checkErrorCall(n, hint)
+		}
+		t, err := c.checkCall(n)
+		if err != nil {
+			return nil, err
+		}
+		if t == Void {
+			if f := c.info.Calls[n]; f != nil {
+				return nil, c.errorf(n, "%s does not return a value", f.Name)
+			}
+			return nil, c.errorf(n, "the called function does not return a value")
+		}
+		return t, nil
+	case *ast.FuncLit:
+		return c.checkFuncLit(n, hint)
+	default:
+		return nil, c.errorf(e, "unknown expression node %T", e)
+	}
+}
+
+func (c *Checker) checkListLit(n *ast.ListLit, hint Type) (Type, error) {
+	var elemHint Type
+	if list, ok := hint.(*List); ok {
+		elemHint = list.Elem
+	}
+	if len(n.Elems) == 0 {
+		if elemHint == nil {
+			return nil, c.errorf(n, "cannot infer the type of an empty list; annotate it, e.g. xs: [float] = []")
+		}
+		return &List{Elem: elemHint}, nil
+	}
+	first, err := c.checkExprHint(n.Elems[0], elemHint)
+	if err != nil {
+		return nil, err
+	}
+	for _, elem := range n.Elems[1:] {
+		t, err := c.checkExprHint(elem, first)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(t, first) {
+			return nil, c.errorf(elem, "list elements must all be %s, got %s", first, t)
+		}
+	}
+	return &List{Elem: first}, nil
+}
+
+func (c *Checker) checkMapLit(n *ast.MapLit, hint Type) (Type, error) {
+	var keyHint, valHint Type
+	if m, ok := hint.(*Map); ok {
+		keyHint, valHint = m.Key, m.Value
+	}
+	if len(n.Entries) == 0 {
+		if keyHint == nil {
+			return nil, c.errorf(n, "cannot infer the type of an empty map; annotate it, e.g. m: {string: float} = {}")
+		}
+		return &Map{Key: keyHint, Value: valHint}, nil
+	}
+	var m *Map
+	for _, entry := range n.Entries {
+		kt, err := c.checkExprHint(entry.Key, keyHint)
+		if err != nil {
+			return nil, err
+		}
+		vt, err := c.checkExprHint(entry.Value, valHint)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			if !validKey(kt) {
+				return nil, c.errorf(entry.Key, "map keys must be float or string, got %s", kt)
+			}
+			m = &Map{Key: kt, Value: vt}
+			keyHint, valHint = kt, vt
+			continue
+		}
+		if !Identical(kt, m.Key) {
+			return nil, c.errorf(entry.Key, "map keys must all be %s, got %s", m.Key, kt)
+		}
+		if !Identical(vt, m.Value) {
+			return nil, c.errorf(entry.Value, "map values must all be %s, got %s", m.Value, vt)
+		}
+	}
+	return m, nil
+}
+
+// checkStructLit checks a struct literal, which must initialize every field exactly once.
+func (c *Checker) checkStructLit(n *ast.StructLit) (Type, error) {
+	var named Type
+	if n.Module != "" {
+		t, err := c.importedType(n, n.Module, n.Type)
+		if err != nil {
+			return nil, err
+		}
+		named = t
+	} else {
+		named = c.cur.types[n.Type]
+	}
+	st, ok := named.(*Struct)
+	if !ok {
+		return nil, c.errorCodef(n, diag.CodeUndefined, "unknown struct type %q", n.Type)
+	}
+	seen := make(map[string]bool)
+	for _, init := range n.Fields {
+		field, _ := st.Field(init.Name)
+		if field == nil {
+			return nil, c.errorCodef(init, diag.CodeUndefined, "type %s has no field %s", st, init.Name)
+		}
+		if seen[init.Name] {
+			return nil, c.errorCodef(init, diag.CodeRedeclared, "field %s is initialized twice", init.Name)
+		}
+		seen[init.Name] = true
+		t, err := c.checkExprHint(init.Value, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(t, field.Type) {
+			return nil, c.errorf(init.Value, "cannot use %s as field %s of type %s", t, init.Name, field.Type)
+		}
+	}
+	for _, field := range st.Fields {
+		if !seen[field.Name] {
+			return nil, c.errorf(n, "missing field %s in %s literal", field.Name, st)
+		}
+	}
+	return st, nil
+}
+
+// checkIndex checks xs[i] on a list and m[k] on a map.
+func (c *Checker) checkIndex(n *ast.Index) (Type, error) {
+	t, err := c.checkExpr(n.X)
+	if err != nil {
+		return nil, err
+	}
+	switch t := t.(type) {
+	case *List:
+		it, err := c.checkExpr(n.Index)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(it, Float) {
+			return nil, c.errorf(n.Index, "list index must be a float, got %s", it)
+		}
+		return t.Elem, nil
+	case *Map:
+		if err := c.checkKey(n.Index, t); err != nil {
+			return nil, err
+		}
+		return t.Value, nil
+	}
+	return nil, c.errorf(n.X, "cannot index a value of type %s", t)
+}
+
+// checkKey requires key to be usable as a key of m.
+func (c *Checker) checkKey(key ast.Expr, m *Map) error {
+	kt, err := c.checkExpr(key)
+	if err != nil {
+		return err
+	}
+	if !Identical(kt, m.Key) {
+		return c.errorf(key, "map key must be %s, got %s", m.Key, kt)
+	}
+	return nil
+}
+
+// checkMapArgs checks the (map, key) arguments of the map built-ins and returns the map type.
+func (c *Checker) checkMapArgs(n *ast.Call, name string) (*Map, error) {
+	if len(n.Args) != 2 {
+		return nil, c.errorCodef(n, diag.CodeArgCount, "%s expects 2 arguments, got %d", name, len(n.Args))
+	}
+	t, err := c.checkExpr(n.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	m, ok := t.(*Map)
+	if !ok {
+		return nil, c.errorf(n.Args[0], "%s expects a map, got %s", name, t)
+	}
+	if err := c.checkKey(n.Args[1], m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// enumVariant returns the variant named by a selector of the form Enum.Variant or
+// module.Enum.Variant, or nil.
+func (c *Checker) enumVariant(sel *ast.Selector) (*Variant, error) {
+	var named Type
+	switch x := sel.X.(type) {
+	case *ast.Variable:
+		if c.lookup(x.Name) != nil {
+			return nil, nil
+		}
+		named = c.cur.types[x.Name]
+	case *ast.Selector:
+		module, ok := x.X.(*ast.Variable)
+		if !ok || c.imported(module.Name) == nil {
+			return nil, nil
+		}
+		if c.lookup(module.Name) != nil {
+			return nil, nil
+		}
+		t, err := c.importedType(x, module.Name, x.Name)
+		if err != nil {
+			return nil, err
+		}
+		named = t
+	}
+	enum, ok := named.(*Enum)
+	if !ok {
+		return nil, nil
+	}
+	v := enum.Variant(sel.Name)
+	if v == nil {
+		return nil, c.errorCodef(sel, diag.CodeUndefined, "enum %s has no variant %s", enum, sel.Name)
+	}
+	return v, nil
+}
+
+// selectedModule returns the scope of the imported module a selector of the form module.name
+// refers to, or nil.
+func (c *Checker) selectedModule(sel *ast.Selector) *moduleScope {
+	module, ok := sel.X.(*ast.Variable)
+	if !ok {
+		return nil
+	}
+	if c.lookup(module.Name) != nil {
+		return nil
+	}
+	return c.imported(module.Name)
+}
+
+// moduleConst returns the constant named by a selector of the form module.NAME, or nil.
+func (c *Checker) moduleConst(sel *ast.Selector) (*Const, error) {
+	scope := c.selectedModule(sel)
+	if scope == nil {
+		return nil, nil
+	}
+	k, ok := scope.consts[sel.Name]
+	if !ok {
+		return nil, nil
+	}
+	if !k.Pub {
+		return nil, c.errorCodef(sel, diag.CodeNotPublic, "constant %s.%s is not public; declare it with pub", sel.X.(*ast.Variable).Name, sel.Name)
+	}
+	return k, nil
+}
+
+// moduleFunc returns the function named by a selector of the form module.fn, or nil.
+func (c *Checker) moduleFunc(sel *ast.Selector) (*Func, error) {
+	scope := c.selectedModule(sel)
+	if scope == nil {
+		return nil, nil
+	}
+	module := sel.X.(*ast.Variable)
+	f, ok := scope.funcs[sel.Name]
+	if !ok {
+		return nil, c.errorCodef(sel, diag.CodeUndefined, "module %s has no function %s", module.Name, sel.Name)
+	}
+	if !f.Pub {
+		return nil, c.errorCodef(sel, diag.CodeNotPublic, "function %s.%s is not public; declare it with pub", module.Name, sel.Name)
+	}
+	return f, nil
+}
+
+// checkConstructor checks Enum.Variant(args...), which builds an enum value.
+func (c *Checker) checkConstructor(n *ast.Call, v *Variant) (Type, error) {
+	if len(n.Args) != len(v.Fields) {
+		return nil, c.errorCodef(n, diag.CodeArgCount, "%s.%s expects %d arguments, got %d", v.Enum, v.Name, len(v.Fields), len(n.Args))
+	}
+	for i, arg := range n.Args {
+		t, err := c.checkExprHint(arg, v.Fields[i].Type)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(t, v.Fields[i].Type) {
+			return nil, c.errorf(arg, "cannot use %s as field %s of type %s", t, v.Fields[i].Name, v.Fields[i].Type)
+		}
+	}
+	c.info.Variants[n] = v
+	return v.Enum, nil
+}
+
+// checkMatchArms checks the subject and the patterns of a match, including exhaustiveness,
+// and calls body for every arm once its bindings are in scope.
+func (c *Checker) checkMatchArms(m *ast.Match, body func(arm *ast.MatchArm) error) error {
+	t, err := c.checkExpr(m.Subject)
+	if err != nil {
+		return err
+	}
+	enum, ok := t.(*Enum)
+	if !ok {
+		return c.errorf(m.Subject, "cannot match on a value of type %s", t)
+	}
+	covered := make(map[string]bool)
+	wildcard := false
+	for _, arm := range m.Arms {
+		if wildcard {
+			return c.errorCodef(arm, diag.CodeMatch, "unreachable match arm after '_'")
+		}
+		if arm.Variant == "_" {
+			if len(arm.Bindings) != 0 {
+				return c.errorf(arm, "the '_' arm cannot bind fields")
+			}
+			wildcard = true
+			if err := body(arm); err != nil {
+				return err
+			}
+			continue
+		}
+		v := enum.Variant(arm.Variant)
+		if v == nil {
+			return c.errorCodef(arm, diag.CodeUndefined, "enum %s has no variant %s", enum, arm.Variant)
+		}
+		if covered[v.Name] {
+			return c.errorCodef(arm, diag.CodeRedeclared, "variant %s is matched twice", v.Name)
+		}
+		covered[v.Name] = true
+		if err := c.checkArm(arm, v, body); err != nil {
+			return err
+		}
+	}
+	if !wildcard {
+		var missing []string
+		for _, v := range enum.Variants {
+			if !covered[v.Name] {
+				missing = append(missing, v.Name)
+			}
+		}
+		if len(missing) > 0 {
+			return c.errorCodef(m, diag.CodeMatch, "non-exhaustive match on %s: missing %s", enum, strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+// checkArm declares the bindings of an arm matching variant v in a scope of their own, and
+// checks the arm with body.
+func (c *Checker) checkArm(arm *ast.MatchArm, v *Variant, body func(arm *ast.MatchArm) error) error {
+	if len(arm.Bindings) != len(v.Fields) {
+		return c.errorf(arm, "variant %s has %d fields, but the pattern binds %d", v.Name, len(v.Fields), len(arm.Bindings))
+	}
+	c.openScope()
+	defer c.closeScope()
+	vars := make([]*Var, len(arm.Bindings))
+	for i, name := range arm.Bindings {
+		if name == "_" {
+			continue
+		}
+		bound, err := c.declare(arm.Pos, name, v.Fields[i].Type, false)
+		if err != nil {
+			return err
+		}
+		vars[i] = bound
+	}
+	c.info.Bindings[arm] = vars
+	return body(arm)
+}
+
+// checkMatchExpr checks a match used as an expression: every arm must be an expression
+// and all arms must have the same type.
+func (c *Checker) checkMatchExpr(m *ast.Match) (Type, error) {
+	var result Type
+	err := c.checkMatchArms(m, func(arm *ast.MatchArm) error {
+		if arm.Block != nil {
+			return c.errorf(arm, "a match used as a value needs an expression in every arm")
+		}
+		t, err := c.checkExprHint(arm.Expr, result)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			result = t
+		} else if !Identical(t, result) {
+			return c.errorf(arm.Expr, "match arms must all be %s, got %s", result, t)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, c.errorf(m, "a match used as a value needs at least one arm")
+	}
+	return result, nil
+}
+
+// checkMatchStmt checks a match used as a statement, whose arm values are discarded.
+func (c *Checker) checkMatchStmt(m *ast.Match) error {
+	return c.checkMatchArms(m, func(arm *ast.MatchArm) error {
+		if arm.Block != nil {
+			return c.checkStmt(arm.Block)
+		}
+		_, err := c.checkExpr(arm.Expr)
+		return err
+	})
+}
+
+// checkCall checks a call of a built-in or declared function, or of an enum variant
+// constructor. Calls of functions that return no value have type Void.
+func (c *Checker) checkCall(n *ast.Call) (Type, error) {
+	if sel, ok := n.Func.(*ast.Selector); ok {
+		v, err := c.enumVariant(sel)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			return c.checkConstructor(n, v)
+		}
+		f, err := c.moduleFunc(sel)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			return c.checkFuncCall(n, f)
+		}
+	}
+	// Variables hide functions of the same name, so a variable holding a function value can be
+	// called like a function
+	fn, ok := n.Func.(*ast.Variable)
+	if !ok || c.lookup(fn.Name) != nil {
+		return c.checkValueCall(n)
+	}
+	if f, ok := c.cur.funcs[fn.Name]; ok {
+		return c.checkFuncCall(n, f)
+	}
+	switch fn.Name {
+	case "len":
+		if len(n.Args) != 1 {
+			return nil, c.errorCodef(n, diag.CodeArgCount, "len expects 1 argument, got %d", len(n.Args))
+		}
+		t, err := c.checkExpr(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		switch t.(type) {
+		case *List, *Map:
+			return Float, nil
+		}
+		return nil, c.errorf(n.Args[0], "len expects a list or a map, got %s", t)
+	case "append":
+		if len(n.Args) != 2 {
+			return nil, c.errorCodef(n, diag.CodeArgCount, "append expects 2 arguments, got %d", len(n.Args))
+		}
+		t, err := c.checkExpr(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		list, ok := t.(*List)
+		if !ok {
+			return nil, c.errorf(n.Args[0], "append expects a list, got %s", t)
+		}
+		et, err := c.checkExprHint(n.Args[1], list.Elem)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(et, list.Elem) {
+			return nil, c.errorf(n.Args[1], "cannot append %s to %s", et, list)
+		}
+		return list, nil
+	case "has":
+		if _, err := c.checkMapArgs(n, fn.Name); err != nil {
+			return nil, err
+		}
+		return Bool, nil
+	case "delete":
+		return c.checkMapArgs(n, fn.Name)
+	case "keys":
+		if len(n.Args) != 1 {
+			return nil, c.errorCodef(n, diag.CodeArgCount, "keys expects 1 argument, got %d", len(n.Args))
+		}
+		t, err := c.checkExpr(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		m, ok := t.(*Map)
+		if !ok {
+			return nil, c.errorf(n.Args[0], "keys expects a map, got %s", t)
+		}
+		return &List{Elem: m.Key}, nil
+	case "map":
+		list, err := c.checkListArg(n, fn.Name, 2)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := c.checkFuncArg(n.Args[1], fn.Name, &Signature{Params: []Type{list.Elem}, Result: anyResult})
+		if err != nil {
+			return nil, err
+		}
+		return &List{Elem: sig.Result}, nil
+	case "filter":
+		list, err := c.checkListArg(n, fn.Name, 2)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.checkFuncArg(n.Args[1], fn.Name, &Signature{Params: []Type{list.Elem}, Result: Bool}); err != nil {
+			return nil, err
+		}
+		return list, nil
+	case "reduce":
+		list, err := c.checkListArg(n, fn.Name, 3)
+		if err != nil {
+			return nil, err
+		}
+		acc, err := c.checkExpr(n.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		if _, err := c.checkFuncArg(n.Args[2], fn.Name, &Signature{Params: []Type{acc, list.Elem}, Result: acc}); err != nil {
+			return nil, err
+		}
+		return acc, nil
+	case "error":
+		return nil, c.errorf(n, "the error made by error(...) is not used")
+	case "exit":
+		if len(n.Args) != 1 {
+			return nil, c.errorCodef(n, diag.CodeArgCount, "exit expects 1 argument, got %d", len(n.Args))
+		}
+		t, err := c.checkExpr(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(t, Float) {
+			return nil, c.errorf(n.Args[0], "exit expects a float exit code, got %s", t)
+		}
+		return Void, nil
+	case "assert":
+		if len(n.Args) != 1 && len(n.Args) != 2 {
+			return nil, c.errorCodef(n, diag.CodeArgCount, "assert expects 1 or 2 arguments, got %d", len(n.Args))
+		}
+		t, err := c.checkExpr(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(t, Bool) {
+			return nil, c.errorf(n.Args[0], "assert expects a bool condition, got %s", t)
+		}
+		if len(n.Args) == 2 {
+			t, err := c.checkExpr(n.Args[1])
+			if err != nil {
+				return nil, err
+			}
+			if !Identical(t, String) {
+				return nil, c.errorf(n.Args[1], "assert expects a string message, got %s", t)
+			}
+		}
+		return Void, nil
+	case "assert_eq":
+		if len(n.Args) != 2 {
+			return nil, c.errorCodef(n, diag.CodeArgCount, "assert_eq expects 2 arguments, got %d", len(n.Args))
+		}
+		got, err := c.checkExpr(n.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(got, Float) && !Identical(got, String) && !Identical(got, Bool) {
+			return nil, c.errorf(n.Args[0], "assert_eq expects a float, string or bool, got %s", got)
+		}
+		want, err := c.checkExprHint(n.Args[1], got)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(want, got) {
+			return nil, c.errorf(n.Args[1], "cannot compare %s with %s in assert_eq", got, want)
+		}
+		return Void, nil
+	}
+	if c.undeclared[fn.Name] {
+		return nil, errReported
+	}
+	return nil, c.errorCodef(n, diag.CodeUndefined, "undefined function %q", fn.Name)
+}
+
+// isErrorCall reports whether e is a call of the error built-in.
+func (c *Checker) isErrorCall(e ast.Expr) bool {
+	call, ok := e.(*ast.Call)
+	if !ok {
+		return false
+	}
+	fn, ok := call.Func.(*ast.Variable)
+	return ok && fn.Name == "error" && c.lookup(fn.Name) == nil
+}
+
+// checkErrorCall checks error(message), which makes an error of the result type the context
+// expects, hint.
+func (c *Checker) checkErrorCall(n *ast.Call, hint Type) (Type, error) {
+	if len(n.Args) != 1 {
+		return nil, c.errorCodef(n, diag.CodeArgCount, "error expects 1 argument, got %d", len(n.Args))
+	}
+	t, err := c.checkExpr(n.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !Identical(t, String) {
+		return nil, c.errorf(n.Args[0], "error expects a string message, got %s", t)
+	}
+	r, ok := hint.(*Result)
+	if !ok {
+		return nil, c.errorf(n, "cannot infer the result type of error(...); return it from a function returning a result, or annotate it, e.g. r: !float = error(\"...\")")
+	}
+	return r, nil
+}
+
+// checkPropagate checks x?, which requires x to be a result. Outside of a try block, the error is
+// returned, so the enclosing function must return a result too.
+func (c *Checker) checkPropagate(n *ast.Propagate) (Type, error) {
+	t, err := c.checkExpr(n.X)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := t.(*Result)
+	if !ok {
+		return nil, c.errorf(n, "operator ? requires a result, got %s", t)
+	}
+	if c.tries > 0 {
+		return r.Value, nil
+	}
+	switch {
+	case c.fn == nil:
+		return nil, c.errorCodef(n, diag.CodeMisplaced, "operator ? outside of a function must be in a try block")
+	case c.fn.infer:
+		return nil, c.errorf(n, "annotate the result of the function literal to use ? outside of a try block")
+	}
+	if _, ok := c.fn.Result.(*Result); !ok {
+		result := "no value"
+		if c.fn.Result != nil {
+			result = c.fn.Result.String()
+		}
+		return nil, c.errorf(n, "operator ? outside of a try block requires function %s to return a result, but it returns %s", c.fn.Name, result)
+	}
+	return r.Value, nil
+}
+
+// anyResult stands for the result in the signature map expects of its function argument, which
+// may return any value.
+var anyResult Type = &Basic{name: "T"}
+
+// checkListArg checks that the call of the built-in name has nargs arguments, the first of which
+// is a list, and returns the list type.
+func (c *Checker) checkListArg(n *ast.Call, name string, nargs int) (*List, error) {
+	if len(n.Args) != nargs {
+		return nil, c.errorCodef(n, diag.CodeArgCount, "%s expects %d arguments, got %d", name, nargs, len(n.Args))
+	}
+	t, err := c.checkExpr(n.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	list, ok := t.(*List)
+	if !ok {
+		return nil, c.errorf(n.Args[0], "%s expects a list, got %s", name, t)
+	}
+	return list, nil
+}
+
+// checkFuncArg checks the function argument of the built-in name against the signature want.
+func (c *Checker) checkFuncArg(arg ast.Expr, name string, want *Signature) (*Signature, error) {
+	t, err := c.checkExprHint(arg, want)
+	if err != nil {
+		return nil, err
+	}
+	sig, ok := t.(*Signature)
+	if ok && len(sig.Params) == len(want.Params) && sig.Result != nil {
+		matches := want.Result == anyResult || Identical(sig.Result, want.Result)
+		for i := range sig.Params {
+			matches = matches && Identical(sig.Params[i], want.Params[i])
+		}
+		if matches {
+			return sig, nil
+		}
+	}
+	return nil, c.errorf(arg, "%s expects a function of type %s, got %s", name, want, t)
+}
+
+// checkValueCall checks a call of a function value.
+func (c *Checker) checkValueCall(n *ast.Call) (Type, error) {
+	t, err := c.checkExpr(n.Func)
+	if err != nil {
+		return nil, err
+	}
+	sig, ok := t.(*Signature)
+	if !ok {
+		return nil, c.errorf(n, "cannot call a value of type %s", t)
+	}
+	if len(n.Args) != len(sig.Params) {
+		return nil, c.errorCodef(n, diag.CodeArgCount, "function value expects %d arguments, got %d", len(sig.Params), len(n.Args))
+	}
+	for i, arg := range n.Args {
+		at, err := c.checkExprHint(arg, sig.Params[i])
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(at, sig.Params[i]) {
+			return nil, c.errorf(arg, "cannot use %s as argument %d of type %s", at, i+1, sig.Params[i])
+		}
+	}
+	if sig.Result == nil {
+		return Void, nil
+	}
+	return sig.Result, nil
+}
+
+// funcValue checks a use of the declared function f as a value, not in a call.
+func (c *Checker) funcValue(n ast.Expr, f *Func) (Type, error) {
+	if f.Decl.Extern {
+		return nil, c.errorf(n, "extern function %s cannot be used as a value", f.Name)
+	}
+	c.info.FuncValues[n] = f
+	return f.Signature(), nil
+}
+
+// checkFuncLit checks a function literal. Parameters without a type annotation take their type
+// from the signature the context expects, hint. Without a result annotation the result is taken
+// from hint too, or else inferred from the first return statement or the final expression.
+func (c *Checker) checkFuncLit(n *ast.FuncLit, hint Type) (Type, error) {
+	want, _ := hint.(*Signature)
+	f := &Func{Module: c.cur.mod.Path, Name: "literal"}
+	for i, p := range n.Params {
+		var t Type
+		switch {
+		case p.Type != nil:
+			pt, err := c.resolveType(p.Type)
+			if err != nil {
+				return nil, err
+			}
+			t = pt
+		case want != nil && len(want.Params) == len(n.Params):
+			t = want.Params[i]
+		default:
+			d := c.diagnostic(p.Pos, diag.Error, diag.CodeType, "cannot infer the type of parameter %s", p.Name)
+			d.Suggestions = append(d.Suggestions, diag.Suggestion{
+				Message:     "annotate it",
+				Span:        d.Span,
+				Replacement: p.Name + ": float",
+			})
+			return nil, d
+		}
+		f.Params = append(f.Params, &Field{Name: p.Name, Type: t})
+	}
+	switch {
+	case n.Result != nil:
+		t, err := c.resolveType(n.Result)
+		if err != nil {
+			return nil, err
+		}
+		f.Result = t
+	case want != nil && want.Result != anyResult:
+		f.Result = want.Result
+	default:
+		f.infer = true
+	}
+
+	cl := &Closure{Func: f}
+	outer, tries := c.fn, c.tries
+	c.fn, c.tries = f, 0
+	c.openClosureScope(cl)
+	err := c.checkFuncBody(n, cl)
+	c.closeScope()
+	c.fn, c.tries = outer, tries
+	if err != nil {
+		return nil, err
+	}
+	c.info.Closures[n] = cl
+	return f.Signature(), nil
+}
+
+// checkFuncBody checks the parameters and body of the function literal n in the scope of cl.
+// A final expression statement gives the result of a literal that returns a value.
+func (c *Checker) checkFuncBody(n *ast.FuncLit, cl *Closure) error {
+	f := cl.Func
+	for i, p := range f.Params {
+		v, err := c.declare(n.Params[i].Pos, p.Name, p.Type, false)
+		if err != nil {
+			return err
+		}
+		c.info.Vars[&n.Params[i]] = v
+		cl.Params = append(cl.Params, v)
+	}
+	stmts := n.Body.Stmts
+	var last *ast.ExprStmt
+	if len(stmts) > 0 {
+		last, _ = stmts[len(stmts)-1].(*ast.ExprStmt)
+	}
+	if last != nil {
+		stmts = stmts[:len(stmts)-1]
+	}
+	if err := c.checkStmts(stmts); err != nil {
+		return err
+	}
+	if last != nil {
+		result, err := c.checkFinalExpr(last, f)
+		if err != nil {
+			return err
+		}
+		cl.Result = result
+	}
+	f.infer = false
+	if f.Result != nil && cl.Result == nil && !c.info.Terminates(n.Body) {
+		return c.errorCodef(n.Body, diag.CodeMissingReturn, "missing return at the end of function literal")
+	}
+	return nil
+}
+
+// checkFinalExpr checks the final expression statement of the body of the function literal f and
+// returns its expression if it gives the result, or nil if it is an ordinary statement.
+func (c *Checker) checkFinalExpr(last *ast.ExprStmt, f *Func) (ast.Expr, error) {
+	if f.infer {
+		// Calls of functions without a result and matches with block arms are statements
+		switch e := last.Expr.(type) {
+		case *ast.Call:
+			t, err := c.checkCall(e)
+			if err != nil {
+				return nil, err
+			}
+			c.info.Types[e] = t
+			if t == Void {
+				return nil, nil
+			}
+			f.Result = t
+			return e, nil
+		case *ast.Match:
+			for _, arm := range e.Arms {
+				if arm.Block != nil {
+					return nil, c.checkMatchStmt(e)
+				}
+			}
+		}
+		t, err := c.checkExpr(last.Expr)
+		if err != nil {
+			return nil, err
+		}
+		f.Result = t
+		return last.Expr, nil
+	}
+	if f.Result == nil {
+		return nil, c.checkStmt(last)
+	}
+	return last.Expr, c.checkReturnValue(last.Expr, f)
+}
+
+// checkFuncCall checks the arguments of a call of the declared function f.
+func (c *Checker) checkFuncCall(n *ast.Call, f *Func) (Type, error) {
+	if len(n.Args) != len(f.Params) {
+		return nil, c.errorCodef(n, diag.CodeArgCount, "%s expects %d arguments, got %d", f.Name, len(f.Params), len(n.Args))
+	}
+	for i, arg := range n.Args {
+		param := f.Params[i]
+		t, err := c.checkExprHint(arg, param.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !Identical(t, param.Type) {
+			return nil, c.errorf(arg, "cannot use %s as parameter %s of type %s", t, param.Name, param.Type)
+		}
+	}
+	c.info.Calls[n] = f
+	if f.Result == nil {
+		return Void, nil
+	}
+	return f.Result, nil
+}
+
+func (c *Checker) checkBinary(n *ast.Binary) (Type, error) {
+	lt, err := c.checkExpr(n.Left)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := c.checkExpr(n.Right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.Op {
+	case lexer.TokenPlus, lexer.TokenMinus, lexer.TokenStar, lexer.TokenSlash:
+		if Identical(lt, String) && Identical(rt, String) && n.Op == lexer.TokenPlus {
+			return nil, c.errorf(n, "string concatenation is not supported yet")
+		}
+		if !Identical(lt, Float) || !Identical(rt, Float) {
+			return nil, c.errorf(n, "operator %s requires float operands, got %s and %s", n.Op, lt, rt)
+		}
+		if n.Op == lexer.TokenSlash && c.info.Values[n.Right] == 0.0 {
+			return nil, c.errorCodef(n, diag.CodeDivisionByZero, "division by zero")
+		}
+		return Float, nil
+	case lexer.TokenLess, lexer.TokenLessEq, lexer.TokenGreater, lexer.TokenGreaterEq:
+		if !Identical(lt, Float) || !Identical(rt, Float) {
+			return nil, c.errorf(n, "operator %s requires float operands, got %s and %s", n.Op, lt, rt)
+		}
+		return Bool, nil
+	case lexer.TokenEqEq, lexer.TokenNotEq:
+		if !Identical(lt, rt) {
+			return nil, c.errorf(n, "cannot compare %s with %s", lt, rt)
+		}
+		if _, ok := lt.(*Basic); !ok || lt == Ptr {
+			return nil, c.errorf(n, "cannot compare values of type %s", lt)
+		}
+		return Bool, nil
+	case lexer.TokenAnd, lexer.TokenOr:
+		if !Identical(lt, Bool) || !Identical(rt, Bool) {
+			return nil, c.errorf(n, "operator %s requires bool operands, got %s and %s", n.Op, lt, rt)
+		}
+		return Bool, nil
+	}
+	return nil, c.errorf(n, "unsupported operator %s", n.Op)
+}
@@ -0,0 +1,138 @@
+package sema
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// scope is a lexical block of a function, holding the variables declared in it. Functions start
+// with a scope of their parameters that has no parent: top-level variables are not visible in them.
+//
+// A plain assignment to a name assigns to the innermost visible variable of that name and only
+// declares a new variable in the current scope if there is none. let, loop variables and match
+// bindings always declare a new variable, which shadows any variable of the same name in the
+// enclosing scopes until the end of its block.
+//
+// A function literal starts a scope of its parameters whose parent is the scope the literal
+// appears in. Variables found beyond it are captured by the literal.
+type scope struct {
+	parent  *scope
+	vars    map[string]*Var
+	closure *Closure // set on the outermost scope of a function literal
+}
+
+// openScope starts a new block nested in the current one.
+func (c *Checker) openScope() {
+	c.scope = &scope{parent: c.scope, vars: make(map[string]*Var)}
+}
+
+// resetScope starts the outermost scope of a function or of the top level.
+func (c *Checker) resetScope() {
+	c.scope = nil
+	c.undeclared = make(map[string]bool)
+	c.openScope()
+}
+
+// openClosureScope starts the outermost scope of the function literal cl.
+func (c *Checker) openClosureScope(cl *Closure) {
+	c.openScope()
+	c.scope.closure = cl
+}
+
+// closeScope ends the current block. Its variables that shadow an outer variable are remembered
+// by that variable, so that a later use of it can be reported.
+func (c *Checker) closeScope() {
+	for _, v := range c.scope.vars {
+		if v.shadows != nil {
+			v.shadows.hidden = append(v.shadows.hidden, v)
+		}
+	}
+	c.scope = c.scope.parent
+}
+
+// lookup returns the innermost variable called name, or nil.
+func (c *Checker) lookup(name string) *Var {
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// lookupLocal is lookup limited to the function being checked: it does not see the variables a
+// function literal could capture, which it cannot update and so never mistakenly shadows.
+func (c *Checker) lookupLocal(name string) *Var {
+	for s := c.scope; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v
+		}
+		if s.closure != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// captured reports whether v is a variable of a function enclosing the current function literal.
+func (c *Checker) captured(v *Var) bool {
+	for s := c.scope; s != nil; s = s.parent {
+		if s.vars[v.Name] == v {
+			return false
+		}
+		if s.closure != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// use resolves a reference to the variable called name, recording it as a capture of every
+// function literal between the reference and the declaration. Using a variable again after a
+// block that shadowed it suggests the block meant to update it rather than declare a new variable.
+func (c *Checker) use(name string) *Var {
+	var v *Var
+	var closures []*Closure
+	for s := c.scope; s != nil && v == nil; s = s.parent {
+		v = s.vars[name]
+		if v == nil && s.closure != nil {
+			closures = append(closures, s.closure)
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	for _, cl := range closures {
+		if !slices.Contains(cl.Captures, v) {
+			cl.Captures = append(cl.Captures, v)
+		}
+	}
+	for _, inner := range v.hidden {
+		w := c.diagnostic(inner.Pos, diag.Warning, diag.CodeShadow, "%s shadows a variable that is used again after the block", name)
+		w.Notes = append(w.Notes, fmt.Sprintf("the shadowed %s is declared at line %d", name, v.Pos.Line))
+		if inner.Let {
+			w.Notes = append(w.Notes, "to update it, assign to it without let, or rename one of them")
+		} else {
+			w.Notes = append(w.Notes, "rename one of them")
+		}
+		c.info.Warnings = append(c.info.Warnings, w)
+	}
+	v.hidden = nil
+	return v
+}
+
+// declare declares a new variable in the current scope.
+func (c *Checker) declare(pos ast.Pos, name string, t Type, let bool) (*Var, error) {
+	if _, ok := c.scope.vars[name]; ok {
+		return nil, c.errorCodef(pos, diag.CodeRedeclared, "%s is already declared in this block", name)
+	}
+	if _, ok := c.cur.consts[name]; ok {
+		return nil, c.errorCodef(pos, diag.CodeRedeclared, "%s is already declared as a constant", name)
+	}
+	v := &Var{Name: name, Type: t, Let: let, Pos: pos, shadows: c.lookupLocal(name)}
+	c.scope.vars[name] = v
+	return v, nil
+}
@@ -0,0 +1,219 @@
+package sema
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// builtins lists the names of the built-in functions, which cannot be redeclared.
+var builtins = map[string]bool{
+	"len":       true,
+	"append":    true,
+	"has":       true,
+	"delete":    true,
+	"keys":      true,
+	"map":       true,
+	"filter":    true,
+	"reduce":    true,
+	"error":     true,
+	"exit":      true,
+	"assert":    true,
+	"assert_eq": true,
+}
+
+// moduleScope holds the top-level declarations of one module.
+type moduleScope struct {
+	mod    *ast.Module
+	lines  []string          // source lines, used to render errors
+	types  map[string]Type   // declared struct and enum types, by name
+	funcs  map[string]*Func  // declared functions, by name
+	consts map[string]*Const // declared constants, by name
+	pub    map[string]bool   // names of the types declared pub
+	failed bool              // whether errors were found in the module
+}
+
+// Checker performs semantic analysis and type checking of a program.
+type Checker struct {
+	// Library is set when the program is built as a library, whose root module may then contain
+	// only declarations.
+	Library bool
+
+	modules map[*ast.Module]*moduleScope
+	symbols map[string]*Func // extern and exported functions, by C symbol
+	cur     *moduleScope     // module being checked
+	scope   *scope           // innermost scope of the function or top level being checked
+	fn      *Func            // function being checked, nil at the top level
+	tries   int              // number of try blocks of the current function enclosing the checked code
+	info    *Info
+	errs    diag.List // errors found so far
+
+	// undeclared holds the variables of the current function whose declaration failed, which
+	// later uses do not report as undefined
+	undeclared map[string]bool
+}
+
+// NewChecker creates a Checker.
+func NewChecker() *Checker {
+	return &Checker{
+		modules: make(map[*ast.Module]*moduleScope),
+		symbols: make(map[string]*Func),
+		info: &Info{
+			Types:    make(map[ast.Expr]Type),
+			Variants: make(map[ast.Expr]*Variant),
+			Calls:    make(map[*ast.Call]*Func),
+			Values:   make(map[ast.Expr]any),
+
+			FuncValues: make(map[ast.Expr]*Func),
+			Closures:   make(map[*ast.FuncLit]*Closure),
+			Vars:       make(map[any]*Var),
+			Bindings:   make(map[*ast.MatchArm][]*Var),
+		},
+	}
+}
+
+// Check type checks the root module and every module it imports, and returns the types it
+// computed and the errors found: a *diag.Diagnostic for a single error, a diag.List for several.
+// After errors the Info holds what could be computed, which tools such as the language server use
+// on code being edited.
+func (c *Checker) Check(root *ast.Module) (*Info, error) {
+	c.checkModule(root)
+	switch len(c.errs) {
+	case 0:
+		return c.info, nil
+	case 1:
+		return c.info, c.errs[0]
+	}
+	return c.info, c.errs
+}
+
+// Builtins returns the names of the built-in functions in alphabetical order.
+func Builtins() []string {
+	return slices.Sorted(maps.Keys(builtins))
+}
+
+// checkModule checks the imports of mod, then its declarations and statements. Every module is
+// checked once; import cycles are rejected before type checking. A module whose imports have
+// errors is not checked, since its uses of them would only report more of the same.
+func (c *Checker) checkModule(mod *ast.Module) error {
+	if scope, ok := c.modules[mod]; ok {
+		if scope.failed {
+			return errReported
+		}
+		return nil
+	}
+	// Imports are checked in name order, which fixes the order of Info.Funcs and of the errors
+	var failed error
+	for _, name := range slices.Sorted(maps.Keys(mod.Imports)) {
+		if err := c.checkModule(mod.Imports[name]); err != nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	scope := &moduleScope{
+		mod:    mod,
+		lines:  strings.Split(mod.Source, "\n"),
+		types:  make(map[string]Type),
+		funcs:  make(map[string]*Func),
+		consts: make(map[string]*Const),
+		pub:    make(map[string]bool),
+	}
+	c.modules[mod] = scope
+	c.cur = scope
+	if err := c.checkModuleBody(mod); err != nil {
+		scope.failed = true
+		return c.report(err)
+	}
+	return nil
+}
+
+// checkModuleBody checks the declarations and statements of mod, the current module. The errors
+// of function bodies, tests and top-level statements are recorded as they are found and checking
+// goes on; an error in the declarations stops it, since the code using them would fail too.
+func (c *Checker) checkModuleBody(mod *ast.Module) error {
+	if err := c.declareTypes(mod.Program); err != nil {
+		return err
+	}
+	if err := c.declareFuncs(mod.Program); err != nil {
+		return err
+	}
+	if err := c.declareConsts(mod.Program); err != nil {
+		return err
+	}
+	var failed error
+	for _, stmt := range mod.Program.Stmts {
+		if decl, ok := stmt.(*ast.FuncDecl); ok {
+			if err := c.checkFunc(c.cur.funcs[decl.Name]); err != nil {
+				failed = c.report(err)
+			}
+		}
+	}
+	if err := c.checkTests(mod.Program); err != nil {
+		failed = c.report(err)
+	}
+	c.resetScope()
+	c.fn = nil
+	for _, stmt := range mod.Program.Stmts {
+		switch stmt.(type) {
+		case *ast.FuncDecl, *ast.TypeDecl, *ast.EnumDecl, *ast.ConstDecl, *ast.ImportDecl, *ast.TestDecl, *ast.BenchDecl:
+			continue
+		}
+		var err error
+		switch {
+		case mod.Path != "main":
+			err = c.errorCodef(stmt, diag.CodeMisplaced, "only declarations are allowed at the top level of an imported module")
+		case c.Library:
+			err = c.errorCodef(stmt, diag.CodeMisplaced, "only declarations are allowed at the top level of a library")
+		default:
+			err = c.checkStmt(stmt)
+		}
+		if err != nil {
+			failed = c.report(err)
+		}
+	}
+	return failed
+}
+
+// errReported is returned by the checks of code whose errors are already recorded.
+var errReported = errors.New("errors reported")
+
+// report records err, unless it is errReported, and returns errReported.
+func (c *Checker) report(err error) error {
+	if err == errReported {
+		return err
+	}
+	var d *diag.Diagnostic
+	if !errors.As(err, &d) {
+		d = &diag.Diagnostic{Severity: diag.Error, Code: diag.CodeType, Message: err.Error()}
+	}
+	c.errs = append(c.errs, d)
+	return errReported
+}
+
+// errorf returns a semantic error pointing at node n in the current module.
+func (c *Checker) errorf(n any, format string, args ...any) error {
+	return c.errorCodef(n, diag.CodeType, format, args...)
+}
+
+// errorCodef is errorf for an error with its own diagnostic code.
+func (c *Checker) errorCodef(n any, code, format string, args ...any) error {
+	return c.diagnostic(ast.PosOf(n), diag.Error, code, format, args...)
+}
+
+// diagnostic returns a diagnostic spanning the word at pos in the current module.
+func (c *Checker) diagnostic(pos ast.Pos, severity diag.Severity, code, format string, args ...any) *diag.Diagnostic {
+	src := ""
+	if pos.Line >= 1 && pos.Line <= len(c.cur.lines) {
+		src = c.cur.lines[pos.Line-1]
+	}
+	span := diag.WordSpan(c.cur.mod.File, diag.Pos{Line: pos.Line, Column: pos.Column}, src)
+	d := diag.Errorf(code, span, src, format, args...)
+	d.Severity = severity
+	return d
+}
@@ -0,0 +1,136 @@
+package sema
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lexer"
+	"github.com/engpetarmarinov/pede/parser"
+)
+
+// check parses src as the main module of a program and type checks it.
+func check(t *testing.T, src string) (*Info, error) {
+	t.Helper()
+	prog, err := parser.NewParser(lexer.NewLexer(src)).Parse()
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	mod := &ast.Module{Path: "main", File: "main.pede", Source: src, Program: prog, Imports: map[string]*ast.Module{}}
+	return NewChecker().Check(mod)
+}
+
+func TestCheckAccepts(t *testing.T) {
+	tests := map[string]string{
+		"arithmetic": "x = 3 + 4 * 2\nx += 1\nprint(x / 2)\n",
+		"lists":      "xs = [1, 2]\nxs[0] = 10\nappend(xs, 3)\nnames: [string] = []\nprint(len(xs) + len(names))\n",
+		"maps":       "m = {\"a\": 1}\nm[\"b\"] = 2\ndelete(m, \"a\")\nprint(has(m, \"b\"))\n",
+		"structs":    "type P {\n    x: float\n}\np = P{x: 1}\np.x = 2\nprint(p.x)\n",
+		"enums":      "enum S {\n    A(r)\n    B\n}\ns = S.A(1)\nprint(match s {\n    A(r) => r\n    B => 0\n})\n",
+		"constants":  "const N = 2 * 3\nlet x = N + 1\nprint(x)\n",
+		"exit":       "fn f(x: float): float {\n    if x > 0 {\n        return x\n    }\n    exit(1)\n}\nprint(f(1))\n",
+		"closures":   "fn add(n: float): fn(float): float {\n    return fn(x) { x + n }\n}\nprint(add(1)(2))\n",
+		"results":    "fn f(x: float): !float {\n    if x < 0 {\n        return error(\"neg\")\n    }\n    return x\n}\ntry {\n    print(f(1)?)\n} catch e {\n    print(e)\n}\n",
+		"tests":      "fn f(): float {\n    return 1\n}\ntest \"f\" {\n    assert(f() == 1, \"one\")\n    assert_eq(f(), 1)\n}\nbench \"f\" {\n    assert(f() > 0)\n}\n",
+	}
+	for name, src := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := check(t, src); err != nil {
+				t.Errorf("Check() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckRejects(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		code string
+		line int
+	}{
+		{"print(y)\n", `undefined variable "y"`, diag.CodeUndefined, 1},
+		{"x = 1\nx = \"a\"\n", "cannot assign string to x of type float", diag.CodeType, 2},
+		{"let x = 1\nx = 2\n", "cannot assign to x, which is declared with let", diag.CodeAssign, 2},
+		{"const N = 1\nN = 2\n", "cannot assign to constant N", diag.CodeAssign, 2},
+		{"x = 1\nconst A = x + 1\n", "the value of constant A is not known at compile time: x is not a constant", diag.CodeNotConstant, 2},
+		{"fn f(): float {\n    return 1\n}\nconst A = f()\n", "the value of constant A is not known at compile time: f is not a constant", diag.CodeNotConstant, 4},
+		{"x = 1 / 0\n", "division by zero", diag.CodeDivisionByZero, 1},
+		{"x = 1\nx /= 0\n", "division by zero", diag.CodeDivisionByZero, 2},
+		{"x = 1\nx /= 2 - 2\n", "division by zero", diag.CodeDivisionByZero, 2},
+		{"if 1 {\n}\n", "if condition must be bool, got float", diag.CodeType, 1},
+		{"xs = [1, \"a\"]\n", "list elements must all be float, got string", diag.CodeType, 1},
+		{"enum S {\n    A\n    B\n}\ns = S.A\nprint(match s {\n    A => 1\n})\n", "non-exhaustive match on S: missing B", diag.CodeMatch, 6},
+		{"fn f(): float {\n}\n", "missing return at the end of function f", diag.CodeMissingReturn, 1},
+		{"fn f(exit: fn(float)): float {\n    exit(1)\n}\n", "missing return at the end of function f", diag.CodeMissingReturn, 1},
+		{"test \"t\" {\n}\ntest \"t\" {\n}\n", `test "t" is already declared`, diag.CodeRedeclared, 3},
+		{"test \"t\" {\n    assert_eq(1, \"a\")\n}\n", "cannot compare float with string in assert_eq", diag.CodeType, 2},
+		{"fn len(x: float) {\n}\n", "cannot redeclare built-in function len", diag.CodeRedeclared, 1},
+		{"fn f(a: float) {\n}\nf()\n", "f expects 1 arguments, got 0", diag.CodeArgCount, 3},
+		{"return 1\n", "return outside of a function", diag.CodeMisplaced, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			_, err := check(t, tt.src)
+			var d *diag.Diagnostic
+			if !errors.As(err, &d) {
+				t.Fatalf("Check() error = %v, want a diagnostic", err)
+			}
+			if !strings.Contains(d.Message, tt.want) || d.Code != tt.code || d.Span.Start.Line != tt.line {
+				t.Errorf("Check() error = %s %q at line %d, want %s %q at line %d", d.Code, d.Message, d.Span.Start.Line, tt.code, tt.want, tt.line)
+			}
+		})
+	}
+}
+
+func TestCheckReportsEveryError(t *testing.T) {
+	src := `fn f(x: float): float {
+    y = x + "a"
+    print(y)
+    return z
+}
+test "t" {
+    assert(1)
+}
+x = 1
+x = "s"
+if x > 0 {
+    print(q)
+    g = fn(a: float): float { a + true }
+    print(g(1))
+}
+`
+	_, err := check(t, src)
+	var list diag.List
+	if !errors.As(err, &list) {
+		t.Fatalf("Check() error = %v, want a diag.List", err)
+	}
+	// Uses of y and g, whose declarations failed, are not reported again
+	want := []int{2, 4, 7, 10, 12, 13}
+	var lines []int
+	for _, d := range list {
+		lines = append(lines, d.Span.Start.Line)
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("Check() reported errors at lines %v, want %v:\n%v", lines, want, err)
+	}
+}
+
+func TestCheckFoldsConstants(t *testing.T) {
+	info, err := check(t, "const N = 2 * 3\nx = N + 1\n")
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	var sum *ast.Binary
+	for e := range info.Values {
+		if b, ok := e.(*ast.Binary); ok && b.Op == lexer.TokenPlus {
+			sum = b
+		}
+	}
+	if sum == nil || info.Values[sum] != 7.0 {
+		t.Errorf("N + 1 folded to %v, want 7", info.Values[sum])
+	}
+}
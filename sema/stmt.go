@@ -0,0 +1,310 @@
+package sema
+
+import (
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+	"github.com/engpetarmarinov/pede/lexer"
+)
+
+func (c *Checker) checkStmt(stmt ast.Stmt) error {
+	switch s := stmt.(type) {
+	case *ast.Assignment:
+		err := c.checkAssign(s)
+		if err != nil && c.info.Vars[s] == nil && c.lookup(s.Name) == nil {
+			c.undeclared[s.Name] = true
+		}
+		return err
+	case *ast.IndexAssign:
+		elem, err := c.checkExpr(s.Target)
+		if err != nil {
+			return err
+		}
+		t, err := c.checkExprHint(s.Expr, elem)
+		if err != nil {
+			return err
+		}
+		if !Identical(t, elem) {
+			return c.errorf(s.Expr, "cannot assign %s to an element of type %s", t, elem)
+		}
+		return nil
+	case *ast.FieldAssign:
+		ft, err := c.checkExpr(s.Target)
+		if err != nil {
+			return err
+		}
+		t, err := c.checkExprHint(s.Expr, ft)
+		if err != nil {
+			return err
+		}
+		if !Identical(t, ft) {
+			return c.errorf(s.Expr, "cannot assign %s to field %s of type %s", t, s.Target.Name, ft)
+		}
+		return nil
+	case *ast.CompoundAssign:
+		return c.checkCompoundAssign(s)
+	case *ast.ReturnStmt:
+		return c.checkReturn(s)
+	case *ast.TryStmt:
+		return c.checkTry(s)
+	case *ast.ConstDecl:
+		return c.errorCodef(s, diag.CodeMisplaced, "const declarations are only allowed at the top level")
+	case *ast.ExprStmt:
+		switch e := s.Expr.(type) {
+		case *ast.Call:
+			t, err := c.checkCall(e)
+			if err != nil {
+				return err
+			}
+			c.info.Types[e] = t
+			return nil
+		case *ast.Match:
+			return c.checkMatchStmt(e)
+		}
+		return c.errorf(s, "expression is not used")
+	case *ast.PrintStmt:
+		t, err := c.checkExpr(s.Expr)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.(*Basic); !ok || t == Ptr {
+			return c.errorf(s.Expr, "cannot print a value of type %s", t)
+		}
+		return nil
+	case *ast.Block:
+		c.openScope()
+		defer c.closeScope()
+		return c.checkStmts(s.Stmts)
+	case *ast.IfStmt:
+		if err := c.checkCond(s.Cond, "if"); err != nil {
+			return err
+		}
+		if err := c.checkStmt(s.Then); err != nil {
+			return err
+		}
+		if s.Else != nil {
+			return c.checkStmt(s.Else)
+		}
+		return nil
+	case *ast.WhileStmt:
+		if err := c.checkCond(s.Cond, "while"); err != nil {
+			return err
+		}
+		return c.checkStmt(s.Body)
+	case *ast.ForStmt:
+		t, err := c.checkExpr(s.Iter)
+		if err != nil {
+			return err
+		}
+		var elem Type
+		switch t := t.(type) {
+		case *List:
+			elem = t.Elem
+		case *Map:
+			elem = t.Key
+		default:
+			return c.errorf(s.Iter, "cannot iterate over %s", t)
+		}
+		// The loop variable lives in a scope of its own around the body
+		c.openScope()
+		defer c.closeScope()
+		v, err := c.declare(s.Pos, s.Var, elem, false)
+		if err != nil {
+			return err
+		}
+		c.info.Vars[s] = v
+		return c.checkStmt(s.Body)
+	default:
+		return c.errorf(stmt, "unsupported statement type %T", stmt)
+	}
+}
+
+// checkStmts checks a list of statements in the current scope. The error of a statement is
+// recorded and checking goes on with the next one, after which checkStmts returns errReported.
+func (c *Checker) checkStmts(stmts []ast.Stmt) error {
+	var failed error
+	for _, stmt := range stmts {
+		if err := c.checkStmt(stmt); err != nil {
+			failed = c.report(err)
+		}
+	}
+	return failed
+}
+
+// checkAssign checks `[let] name [: type] = expr`. A plain assignment updates the innermost
+// visible variable, which keeps the type of its first assignment, or declares a new one in the
+// current block; let always declares a new one.
+func (c *Checker) checkAssign(s *ast.Assignment) error {
+	if _, ok := c.cur.types[s.Name]; ok {
+		return c.errorCodef(s, diag.CodeAssign, "cannot assign to type name %s", s.Name)
+	}
+	if _, ok := c.cur.mod.Imports[s.Name]; ok {
+		return c.errorCodef(s, diag.CodeAssign, "cannot assign to module name %s", s.Name)
+	}
+	var declared Type
+	if s.Type != nil {
+		t, err := c.resolveType(s.Type)
+		if err != nil {
+			return err
+		}
+		declared = t
+	}
+	var prev *Var
+	if !s.Let {
+		prev = c.lookup(s.Name)
+	}
+	hint := declared
+	if hint == nil && prev != nil {
+		hint = prev.Type
+	}
+	t, err := c.checkExprHint(s.Expr, hint)
+	if err != nil {
+		return err
+	}
+	if declared != nil && !Identical(t, declared) {
+		return c.errorf(s.Expr, "cannot assign %s to %s of type %s", t, s.Name, declared)
+	}
+	if prev != nil {
+		if err := c.checkAssignable(s, prev); err != nil {
+			return err
+		}
+		// A variable keeps the type of its first assignment, so that it has a single stack slot
+		if !Identical(t, prev.Type) {
+			return c.errorf(s.Expr, "cannot assign %s to %s of type %s", t, s.Name, prev.Type)
+		}
+		c.info.Vars[s] = prev
+		return nil
+	}
+	if _, ok := c.cur.consts[s.Name]; ok && !s.Let {
+		return c.errorCodef(s, diag.CodeAssign, "cannot assign to constant %s", s.Name)
+	}
+	v, err := c.declare(s.Pos, s.Name, t, s.Let)
+	if err != nil {
+		return err
+	}
+	c.info.Vars[s] = v
+	return nil
+}
+
+// checkAssignable requires v to be reassignable, that is not declared with let and not captured
+// by the function literal being checked, which only holds a copy of it.
+func (c *Checker) checkAssignable(n any, v *Var) error {
+	if v.Let {
+		return c.errorCodef(n, diag.CodeAssign, "cannot assign to %s, which is declared with let", v.Name)
+	}
+	if c.captured(v) {
+		return c.errorCodef(n, diag.CodeAssign, "cannot assign to %s, which is captured from the enclosing function", v.Name)
+	}
+	return nil
+}
+
+// checkCompoundAssign checks `target op= expr`, which requires a float target and value.
+func (c *Checker) checkCompoundAssign(s *ast.CompoundAssign) error {
+	if target, ok := s.Target.(*ast.Variable); ok {
+		v := c.lookup(target.Name)
+		if v == nil {
+			if _, ok := c.cur.consts[target.Name]; ok {
+				return c.errorCodef(s, diag.CodeAssign, "cannot assign to constant %s", target.Name)
+			}
+		} else if err := c.checkAssignable(s, v); err != nil {
+			return err
+		}
+	}
+	tt, err := c.checkExpr(s.Target)
+	if err != nil {
+		return err
+	}
+	if !Identical(tt, Float) {
+		return c.errorf(s.Target, "operator %s= requires a float target, got %s", s.Op, tt)
+	}
+	t, err := c.checkExpr(s.Expr)
+	if err != nil {
+		return err
+	}
+	if !Identical(t, Float) {
+		return c.errorf(s.Expr, "operator %s= requires a float operand, got %s", s.Op, t)
+	}
+	// As for the / operator, codegen checks only divisors that are not constant
+	if s.Op == lexer.TokenSlash && c.info.Values[s.Expr] == 0.0 {
+		return c.errorCodef(s.Expr, diag.CodeDivisionByZero, "division by zero")
+	}
+	return nil
+}
+
+// checkReturn checks a return statement against the result type of the enclosing function.
+func (c *Checker) checkReturn(s *ast.ReturnStmt) error {
+	if c.fn == nil {
+		return c.errorCodef(s, diag.CodeMisplaced, "return outside of a function")
+	}
+	if c.fn.infer {
+		// The first return of a function literal without a result annotation decides its result
+		c.fn.infer = false
+		if s.Value != nil {
+			t, err := c.checkExpr(s.Value)
+			if err != nil {
+				return err
+			}
+			c.fn.Result = t
+		}
+		return nil
+	}
+	if c.fn.Result == nil {
+		if s.Value != nil {
+			return c.errorf(s.Value, "function %s does not return a value", c.fn.Name)
+		}
+		return nil
+	}
+	if s.Value == nil {
+		return c.errorCodef(s, diag.CodeMissingReturn, "function %s must return a value of type %s", c.fn.Name, c.fn.Result)
+	}
+	return c.checkReturnValue(s.Value, c.fn)
+}
+
+// checkReturnValue checks e, returned from the function f. A function returning a result !T
+// returns either an error(...) or a value of type T.
+func (c *Checker) checkReturnValue(e ast.Expr, f *Func) error {
+	hint := f.Result
+	r, isResult := f.Result.(*Result)
+	if isResult && !c.isErrorCall(e) {
+		hint = r.Value
+	}
+	t, err := c.checkExprHint(e, hint)
+	if err != nil {
+		return err
+	}
+	if Identical(t, f.Result) || isResult && Identical(t, r.Value) {
+		return nil
+	}
+	return c.errorf(e, "cannot return %s from function %s, which returns %s", t, f.Name, f.Result)
+}
+
+// checkTry checks a try statement. The catch block is outside of the try, so errors propagated in
+// it go to an enclosing try or the caller.
+func (c *Checker) checkTry(s *ast.TryStmt) error {
+	c.tries++
+	err := c.checkStmt(s.Body)
+	c.tries--
+	if err != nil {
+		return err
+	}
+	c.openScope()
+	defer c.closeScope()
+	if s.Var != "" {
+		v, err := c.declare(s.Pos, s.Var, String, false)
+		if err != nil {
+			return err
+		}
+		c.info.Vars[s] = v
+	}
+	return c.checkStmts(s.Catch.Stmts)
+}
+
+func (c *Checker) checkCond(cond ast.Expr, keyword string) error {
+	t, err := c.checkExpr(cond)
+	if err != nil {
+		return err
+	}
+	if !Identical(t, Bool) {
+		return c.errorf(cond, "%s condition must be bool, got %s", keyword, t)
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package sema
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/engpetarmarinov/pede/ast"
+	"github.com/engpetarmarinov/pede/diag"
+)
+
+// Type is the static type of a pede expression.
+type Type interface {
+	String() string
+}
+
+// Basic is a built-in scalar type.
+type Basic struct {
+	name string
+}
+
+func (b *Basic) String() string {
+	return b.name
+}
+
+var (
+	Float  = &Basic{name: "float"}
+	String = &Basic{name: "string"}
+	Bool   = &Basic{name: "bool"}
+	// Ptr is an opaque C pointer, passed to and returned from extern functions.
+	Ptr = &Basic{name: "ptr"}
+	// Void is the result type of calls to functions that return no value.
+	Void = &Basic{name: "void"}
+)
+
+// List is a growable list of Elem values.
+type List struct {
+	Elem Type
+}
+
+func (l *List) String() string {
+	return "[" + l.Elem.String() + "]"
+}
+
+// Map is a hash map from Key to Value; keys are floats or strings.
+type Map struct {
+	Key   Type
+	Value Type
+}
+
+func (m *Map) String() string {
+	return "{" + m.Key.String() + ": " + m.Value.String() + "}"
+}
+
+// Result is the type of values that are either a Value or an error carrying a message: !Value
+type Result struct {
+	Value Type
+}
+
+func (r *Result) String() string {
+	return "!" + r.Value.String()
+}
+
+// Struct is a declared record type. Structs are nominal: two structs are identical only if
+// they come from the same declaration.
+type Struct struct {
+	Module string // path of the declaring module
+	Name   string
+	Fields []*Field
+}
+
+type Field struct {
+	Name string
+	Type Type
+}
+
+func (s *Struct) String() string {
+	return s.Name
+}
+
+// Field returns the field called name and its index, or nil and -1 if there is none.
+func (s *Struct) Field(name string) (*Field, int) {
+	for i, f := range s.Fields {
+		if f.Name == name {
+			return f, i
+		}
+	}
+	return nil, -1
+}
+
+// Enum is a declared tagged union. Like structs, enums are nominal.
+type Enum struct {
+	Module   string // path of the declaring module
+	Name     string
+	Variants []*Variant
+}
+
+// Variant is one alternative of an enum; Tag is its index in Variants.
+type Variant struct {
+	Enum   *Enum
+	Name   string
+	Tag    int
+	Fields []*Field
+}
+
+func (e *Enum) String() string {
+	return e.Name
+}
+
+// Variant returns the variant called name, or nil if there is none.
+func (e *Enum) Variant(name string) *Variant {
+	for _, v := range e.Variants {
+		if v.Name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// Func is a declared function or a function literal.
+type Func struct {
+	Module string // path of the declaring module
+	Name   string // "literal" for function literals
+	Params []*Field
+	Result Type // nil for functions that return no value
+	Pub    bool
+	Decl   *ast.FuncDecl // nil for function literals
+
+	infer bool // the result of a function literal is still to be inferred from its body
+}
+
+// Signature returns the type of f used as a value.
+func (f *Func) Signature() *Signature {
+	sig := &Signature{Result: f.Result}
+	for _, p := range f.Params {
+		sig.Params = append(sig.Params, p.Type)
+	}
+	return sig
+}
+
+func (f *Func) String() string {
+	return f.Signature().String()
+}
+
+// Signature is the type of function values: fn(Params...): Result
+type Signature struct {
+	Params []Type
+	Result Type // nil for functions that return no value
+}
+
+func (s *Signature) String() string {
+	var sb strings.Builder
+	sb.WriteString("fn(")
+	for i, p := range s.Params {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(p.String())
+	}
+	sb.WriteString(")")
+	if s.Result != nil {
+		sb.WriteString(": " + s.Result.String())
+	}
+	return sb.String()
+}
+
+// Closure is a function literal. Captures are the variables of enclosing functions it uses; their
+// values are copied into the environment of the function value when the literal is evaluated.
+type Closure struct {
+	Func     *Func
+	Params   []*Var
+	Captures []*Var
+	Result   ast.Expr // final expression of the body, whose value is returned, or nil
+}
+
+// Var is a variable: a function parameter, a loop or match binding, or a local variable
+// introduced by its first assignment or by let.
+type Var struct {
+	Name string
+	Type Type
+	Let  bool    // declared with let, so it cannot be reassigned
+	Pos  ast.Pos // position of the declaration
+
+	shadows *Var   // variable of an enclosing scope hidden by this one
+	hidden  []*Var // closed-over shadows of this variable, reported if it is used again
+}
+
+// Const is a constant declared with const.
+type Const struct {
+	Module string // path of the declaring module
+	Name   string
+	Type   Type
+	Value  any // float64, string or bool
+	Pub    bool
+}
+
+// validKey reports whether values of t can be used as map keys.
+func validKey(t Type) bool {
+	return t == Float || t == String
+}
+
+// Identical reports whether a and b denote the same type.
+func Identical(a, b Type) bool {
+	switch a := a.(type) {
+	case *List:
+		b, ok := b.(*List)
+		return ok && Identical(a.Elem, b.Elem)
+	case *Map:
+		b, ok := b.(*Map)
+		return ok && Identical(a.Key, b.Key) && Identical(a.Value, b.Value)
+	case *Result:
+		b, ok := b.(*Result)
+		return ok && Identical(a.Value, b.Value)
+	case *Signature:
+		b, ok := b.(*Signature)
+		if !ok || len(a.Params) != len(b.Params) || (a.Result == nil) != (b.Result == nil) {
+			return false
+		}
+		for i := range a.Params {
+			if !Identical(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return a.Result == nil || Identical(a.Result, b.Result)
+	}
+	return a == b
+}
+
+// Info records the types computed by the checker, for use by codegen.
+type Info struct {
+	Types    map[ast.Expr]Type     // type of every checked expression
+	Variants map[ast.Expr]*Variant // enum variant built by each constructor call or selector
+	Calls    map[*ast.Call]*Func   // function called by each call of a declared function
+	Funcs    []*Func               // every declared function, imported modules first
+	Values   map[ast.Expr]any      // value of every constant expression: a float64, string or bool
+
+	FuncValues map[ast.Expr]*Func        // declared function referred to by each use of one as a value
+	Closures   map[*ast.FuncLit]*Closure // every checked function literal
+
+	// Vars maps every *ast.Variable, *ast.Assignment, *ast.ForStmt, *ast.TryStmt and parameter
+	// *ast.Field to the variable it refers to or declares, and Bindings maps match arms to the variables their
+	// patterns bind, nil for ignored fields.
+	Vars     map[any]*Var
+	Bindings map[*ast.MatchArm][]*Var
+
+	Warnings []*diag.Diagnostic // suspicious but valid code, such as probably mistaken shadowing
+}
+
+// TypeOf returns the type recorded for e, or nil if e was not checked.
+func (info *Info) TypeOf(e ast.Expr) Type {
+	return info.Types[e]
+}
+
+// Terminates reports whether stmt always leaves the enclosing block, by returning from the
+// function or by calling exit(). The checker requires it of the bodies of functions with a result,
+// and lint reports the code that follows such a statement.
+func (info *Info) Terminates(stmt ast.Stmt) bool {
+	switch s := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.Block:
+		return slices.ContainsFunc(s.Stmts, info.Terminates)
+	case *ast.IfStmt:
+		return s.Else != nil && info.Terminates(s.Then) && info.Terminates(s.Else)
+	case *ast.TryStmt:
+		return info.Terminates(s.Body) && info.Terminates(s.Catch)
+	case *ast.ExprStmt:
+		switch e := s.Expr.(type) {
+		case *ast.Call:
+			// A variable called exit hides the built-in
+			fn, ok := e.Func.(*ast.Variable)
+			return ok && fn.Name == "exit" && info.Vars[fn] == nil
+		case *ast.Match:
+			for _, arm := range e.Arms {
+				if arm.Block == nil || !info.Terminates(arm.Block) {
+					return false
+				}
+			}
+			// The checker rejects non-exhaustive matches, so one of the arms always runs
+			return len(e.Arms) > 0
+		}
+	}
+	return false
+}